@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/YaguarEgor/chirpy_server/internal/content"
+	"github.com/YaguarEgor/chirpy_server/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxChirpLength = 140
+	// chirpLinkWeight is how many characters a link counts for, so long
+	// URLs don't eat up a chirp.
+	chirpLinkWeight = 23
+	spamThreshold   = 0.8
+)
+
+// defaultChirpPipeline is the order chirp bodies are checked in unless
+// CHIRP_PIPELINE lists other stages. Bodies are normalized before anything
+// looks at them, and spam scoring reads the links and mentions, so it
+// comes after them.
+const defaultChirpPipeline = "normalize,length,profanity,links,mentions,spam"
+
+// newChirpPipeline builds the pipeline every chirp body goes through from
+// a comma-separated list of stage names.
+func newChirpPipeline(cfg *apiConfig, names string) (*content.Pipeline, error) {
+	if strings.TrimSpace(names) == "" {
+		names = defaultChirpPipeline
+	}
+	var stages []content.Stage
+	var seen []string
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if slices.Contains(seen, name) {
+			return nil, fmt.Errorf("stage %q is listed twice", name)
+		}
+		seen = append(seen, name)
+
+		switch name {
+		case "normalize":
+			stages = append(stages, content.Normalize{})
+		case "length":
+			stages = append(stages, content.Length{Max: maxChirpLength, LinkWeight: chirpLinkWeight})
+		case "profanity":
+			stages = append(stages, content.Profanity{Filter: cfg.word_filter})
+		case "links":
+			stages = append(stages, content.Links{})
+		case "mentions":
+			stages = append(stages, mentionStage{db: cfg.db})
+		case "spam":
+			stages = append(stages, content.Spam{Threshold: spamThreshold})
+		default:
+			return nil, fmt.Errorf("unknown stage %q", name)
+		}
+	}
+	return content.NewPipeline(stages...), nil
+}
+
+// mentionStage resolves the handles a chirp mentions. Handles that don't
+// belong to anyone are left as plain text.
+type mentionStage struct {
+	db database.Store
+}
+
+func (mentionStage) Name() string { return "mentions" }
+
+func (s mentionStage) Process(ctx context.Context, draft *content.Draft) error {
+	draft.Mentions = nil
+	handles := extractMentions(draft.Body)
+	if len(handles) == 0 {
+		return nil
+	}
+	users, err := s.db.GetUsersByHandles(ctx, handles)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		draft.Mentions = append(draft.Mentions, content.Mention{Handle: user.Handle, UserID: user.ID})
+	}
+	return nil
+}
+
+// checkChirp runs a body written by author through the chirp pipeline,
+// within the limits of the author's tier. It is the one place chirp bodies
+// are checked, whether they are created, edited or imported.
+func (cfg *apiConfig) checkChirp(ctx context.Context, author uuid.UUID, limits Limits, body string) (content.Draft, error) {
+	return cfg.pipeline.Run(ctx, content.Draft{
+		AuthorID:  author,
+		Body:      body,
+		MaxLength: limits.MaxChirpLength,
+	})
+}
+
+// respondWithChirpError reports an error from checkChirp: a rejected body
+// is the client's fault, anything else is ours. A body that is too long
+// also gets the limit and its length, for clients to show.
+func respondWithChirpError(w http.ResponseWriter, err error) {
+	type lengthErrorResponse struct {
+		Error string `json:"error"`
+		Limit int    `json:"limit"`
+		Used  int    `json:"used"`
+	}
+
+	var too_long *content.LengthError
+	if errors.As(err, &too_long) {
+		respondWithJSON(w, http.StatusBadRequest, lengthErrorResponse{
+			Error: too_long.Error(),
+			Limit: too_long.Limit,
+			Used:  too_long.Used,
+		})
+		return
+	}
+	var reject *content.RejectError
+	if errors.As(err, &reject) {
+		respondWithError(w, http.StatusBadRequest, reject.Reason, nil)
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, "Couldn't check chirp", err)
+}
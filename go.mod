@@ -9,4 +9,10 @@ require (
 	golang.org/x/crypto v0.35.0
 )
 
-require github.com/golang-jwt/jwt/v5 v5.2.1
+require (
+	github.com/golang-jwt/jwt/v5 v5.2.1
+	github.com/rivo/uniseg v0.4.7
+	golang.org/x/text v0.22.0
+)
+
+require golang.org/x/sys v0.30.0 // indirect
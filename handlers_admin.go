@@ -1,8 +1,15 @@
 package main
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+
+	"github.com/YaguarEgor/chirpy_server/internal/auth"
+	"github.com/YaguarEgor/chirpy_server/internal/database"
+	"github.com/google/uuid"
 )
 
 func (cfg *apiConfig) handlerCounts(w http.ResponseWriter, r *http.Request) {
@@ -25,6 +32,58 @@ func (cfg *apiConfig) handlerReset(w http.ResponseWriter, r *http.Request) {
 	}
 	cfg.fileserverHits.Store(0)
 	cfg.db.DeleteUsers(r.Context())
+	// Without the bootstrapped admin nobody could use the admin API again
+	// until a restart.
+	if cfg.admin_email != "" {
+		err := bootstrapAdmin(r.Context(), cfg, cfg.admin_email, cfg.admin_password)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't recreate admin", err)
+			return
+		}
+	}
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Hits reset to 0 and database reset to initial state."))
-}
\ No newline at end of file
+}
+// handlerSetUserRole promotes or demotes a user. The new role shows up in
+// their access tokens from their next refresh on.
+func (cfg *apiConfig) handlerSetUserRole(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	user_id, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode request", err)
+		return
+	}
+	role, err := auth.ParseRole(params.Role)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	user, err := cfg.db.SetUserRole(r.Context(), database.SetUserRoleParams{
+		ID:   user_id,
+		Role: string(role),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "User is not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't set role", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, newUser(user))
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/YaguarEgor/chirpy_server/internal/database"
+	"github.com/YaguarEgor/chirpy_server/internal/filter"
+)
+
+type BannedWord struct {
+	Word      string    `json:"word"`
+	Policy    string    `json:"policy"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func newBannedWord(word database.BannedWord) BannedWord {
+	return BannedWord{
+		Word:      word.Word,
+		Policy:    word.Policy,
+		UpdatedAt: word.UpdatedAt,
+	}
+}
+
+func (cfg *apiConfig) handlerGetBannedWords(w http.ResponseWriter, r *http.Request) {
+	banned_words, err := cfg.db.ListBannedWords(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get banned words", err)
+		return
+	}
+
+	data := []BannedWord{}
+	for _, word := range banned_words {
+		data = append(data, newBannedWord(word))
+	}
+	respondWithJSON(w, http.StatusOK, data)
+}
+
+// handlerPutBannedWord adds a word to the filter or changes its policy. The
+// change applies to chirps posted from then on.
+func (cfg *apiConfig) handlerPutBannedWord(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	type parameters struct {
+		Policy string `json:"policy"`
+	}
+
+	word, err := filter.NormalizeWord(r.PathValue("word"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Banned word must be a single word", err)
+		return
+	}
+
+	params := parameters{Policy: string(filter.PolicyMask)}
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode request", err)
+		return
+	}
+	policy, err := filter.ParsePolicy(params.Policy)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	banned_word, err := cfg.db.UpsertBannedWord(r.Context(), database.UpsertBannedWordParams{
+		Word:   word,
+		Policy: string(policy),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save banned word", err)
+		return
+	}
+	if err := loadBannedWords(r.Context(), cfg.db, cfg.word_filter); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reload banned words", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, newBannedWord(banned_word))
+}
+
+func (cfg *apiConfig) handlerDeleteBannedWord(w http.ResponseWriter, r *http.Request) {
+	word, err := filter.NormalizeWord(r.PathValue("word"))
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Banned word is not found", err)
+		return
+	}
+
+	deleted, err := cfg.db.DeleteBannedWord(r.Context(), word)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete banned word", err)
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusNotFound, "Banned word is not found", nil)
+		return
+	}
+	if err := loadBannedWords(r.Context(), cfg.db, cfg.word_filter); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reload banned words", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
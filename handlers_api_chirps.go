@@ -1,41 +1,188 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
-	"sort"
 	"time"
 
 	"github.com/YaguarEgor/chirpy_server/internal/auth"
 	"github.com/YaguarEgor/chirpy_server/internal/database"
+	"github.com/YaguarEgor/chirpy_server/internal/content"
 	"github.com/google/uuid"
 )
 
 type Chirp struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	UserID    uuid.UUID `json:"user_id"`
-	Body      string    `json:"body"`
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	UserID     uuid.UUID  `json:"user_id"`
+	Body       string     `json:"body"`
+	InReplyTo  *uuid.UUID `json:"in_reply_to"`
+	ReplyCount int32      `json:"reply_count"`
+	LikeCount  int32      `json:"like_count"`
+	// LikedByMe is only set when the request is authenticated.
+	LikedByMe *bool `json:"liked_by_me,omitempty"`
+	// Deleted marks a tombstone: a deleted chirp kept so its replies still
+	// have a parent.
+	Deleted bool `json:"deleted,omitempty"`
+	// Kind is "chirp", "rechirp" or "quote". Rechirps and quotes embed the
+	// chirp they point at in ReferencedChirp.
+	Kind            string           `json:"kind"`
+	ReferencedChirp *ReferencedChirp `json:"referenced_chirp,omitempty"`
+}
+
+// ReferencedChirp is the chirp a rechirp or quote points at. Once the
+// original is deleted only Unavailable is set.
+type ReferencedChirp struct {
+	*Chirp
+	Unavailable bool `json:"unavailable,omitempty"`
+}
+
+func newChirp(chirp database.Chirp) Chirp {
+	c := Chirp{
+		ID:         chirp.ID,
+		CreatedAt:  chirp.CreatedAt,
+		UpdatedAt:  chirp.UpdatedAt,
+		UserID:     chirp.UserID,
+		Body:       chirp.Body,
+		ReplyCount: chirp.ReplyCount,
+		LikeCount:  chirp.LikeCount,
+		Deleted:    chirp.DeletedAt.Valid,
+		Kind:       chirp.Kind,
+	}
+	if chirp.InReplyTo.Valid {
+		c.InReplyTo = &chirp.InReplyTo.UUID
+	}
+	return c
+}
+
+// publishChirp does the work that follows storing a chirp or an edit of
+// it: flagging it for review, saving its hashtags and notifying the users
+// it mentions. It belongs in the same transaction as the write, so a chirp
+// is never left half published.
+func publishChirp(ctx context.Context, db database.Store, chirp database.Chirp, draft content.Draft) error {
+	if err := flagChirp(ctx, db, chirp, draft); err != nil {
+		return err
+	}
+	if err := tagChirp(ctx, db, chirp); err != nil {
+		return err
+	}
+	return notifyMentions(ctx, db, chirp, draft.Mentions)
+}
+
+// flagChirp puts a chirp up for review if the pipeline found flagged words
+// in it.
+func flagChirp(ctx context.Context, db database.Store, chirp database.Chirp, draft content.Draft) error {
+	if len(draft.FlaggedWords) == 0 {
+		return nil
+	}
+	return db.FlagChirp(ctx, database.FlagChirpParams{ChirpID: chirp.ID, Words: draft.FlaggedWords})
+}
+
+// viewer returns the caller when the request carries a valid access token.
+// Public endpoints use it to personalise their responses, so a missing or
+// invalid token is not an error.
+func (cfg *apiConfig) viewer(r *http.Request) uuid.NullUUID {
+	user_id, err := cfg.authenticate(r)
+	if err != nil {
+		return uuid.NullUUID{}
+	}
+	return uuid.NullUUID{UUID: user_id, Valid: true}
+}
+
+// chirpsResponse converts chirps for a response. Referenced chirps are
+// loaded with one query for the whole list and, when there is a viewer, so
+// is liked_by_me.
+func (cfg *apiConfig) chirpsResponse(ctx context.Context, viewer uuid.NullUUID, chirps []database.Chirp) ([]Chirp, error) {
+	data := []Chirp{}
+	for _, chirp := range chirps {
+		data = append(data, newChirp(chirp))
+	}
+	if len(chirps) == 0 {
+		return data, nil
+	}
+
+	ids := make([]uuid.UUID, len(chirps))
+	var reference_ids []uuid.UUID
+	for i, chirp := range chirps {
+		ids[i] = chirp.ID
+		if chirp.ReferenceID.Valid {
+			reference_ids = append(reference_ids, chirp.ReferenceID.UUID)
+		}
+	}
+
+	referenced := map[uuid.UUID]Chirp{}
+	if len(reference_ids) > 0 {
+		originals, err := cfg.db.GetChirpsByIDs(ctx, reference_ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, original := range originals {
+			if !original.DeletedAt.Valid {
+				referenced[original.ID] = newChirp(original)
+			}
+		}
+	}
+	for i, chirp := range chirps {
+		if chirp.Kind == "chirp" {
+			continue
+		}
+		if original, ok := referenced[chirp.ReferenceID.UUID]; ok {
+			data[i].ReferencedChirp = &ReferencedChirp{Chirp: &original}
+		} else {
+			data[i].ReferencedChirp = &ReferencedChirp{Unavailable: true}
+		}
+	}
+
+	if !viewer.Valid {
+		return data, nil
+	}
+	liked_ids, err := cfg.db.GetLikedChirpIDs(ctx, database.GetLikedChirpIDsParams{
+		UserID:   viewer.UUID,
+		ChirpIds: ids,
+	})
+	if err != nil {
+		return nil, err
+	}
+	liked := map[uuid.UUID]bool{}
+	for _, id := range liked_ids {
+		liked[id] = true
+	}
+	for i := range data {
+		liked_by_me := liked[data[i].ID]
+		data[i].LikedByMe = &liked_by_me
+	}
+	return data, nil
 }
 
 func (cfg *apiConfig) handlerChirps(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	type parameters struct {
-		Body string `json:"body"`
+		Body      string     `json:"body"`
+		InReplyTo *uuid.UUID `json:"in_reply_to"`
 	}
 
 	token, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't get JWT token", err)
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get JWT token", err)
 		return
 	}
-	id, err := auth.ValidateJWT(token, cfg.secret_key)
+	id, err := cfg.keyring.ValidateJWT(token)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT token", err)
 		return
 	}
+	if !cfg.requireVerifiedEmail(w, r, id) {
+		return
+	}
+	limits, err := cfg.limitsFor(r.Context(), id)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User is not found", err)
+		return
+	}
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
@@ -44,28 +191,51 @@ func (cfg *apiConfig) handlerChirps(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode request", err)
 		return
 	}
-	if len(params.Body) > 140 {
-		respondWithError(w, http.StatusBadRequest, "Chirp is too long", nil)
+	draft, err := cfg.checkChirp(r.Context(), id, limits, params.Body)
+	if err != nil {
+		respondWithChirpError(w, err)
 		return
 	}
 
-	chirp, err := cfg.db.CreateChirp(r.Context(), database.CreateChirpParams {
-		Body:  removeBadWords(params.Body),
-		UserID: id,
+	var in_reply_to uuid.NullUUID
+	if params.InReplyTo != nil {
+		parent, err := cfg.db.GetChirp(r.Context(), *params.InReplyTo)
+		if err != nil || parent.DeletedAt.Valid {
+			respondWithError(w, http.StatusNotFound, "Chirp to reply to is not found", err)
+			return
+		}
+		in_reply_to = uuid.NullUUID{UUID: parent.ID, Valid: true}
+	}
+
+	var chirp database.Chirp
+	err = cfg.db.InTx(r.Context(), func(db database.Store) error {
+		err := takePostingSlot(r.Context(), db, id, limits)
+		if err != nil {
+			return err
+		}
+		chirp, err = db.CreateChirp(r.Context(), database.CreateChirpParams {
+			Body:  draft.Body,
+			UserID: id,
+			InReplyTo: in_reply_to,
+			Kind: "chirp",
+		})
+		if err != nil {
+			return err
+		}
+		return publishChirp(r.Context(), db, chirp, draft)
 	})
 
+	var too_many *rateError
+	if errors.As(err, &too_many) {
+		respondWithRateError(w, too_many)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't add chirp to database", err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, Chirp {
-		ID: chirp.ID,
-		CreatedAt: chirp.CreatedAt,
-		UpdatedAt: chirp.UpdatedAt,
-		Body: chirp.Body,
-		UserID: chirp.UserID,
-	})
+	respondWithJSON(w, http.StatusCreated, newChirp(chirp))
 	
 }
 
@@ -77,32 +247,47 @@ func (cfg *apiConfig) handlerGetChirps(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Query().Get("author_id")
 	order := r.URL.Query().Get("sort")
 
-
+	var author_id uuid.NullUUID
 	if id != "" {
-		chirps, err = cfg.db.GetChirpsByAuthor(r.Context(), uuid.MustParse(id))
-	} else {
-		chirps, err = cfg.db.GetChirps(r.Context())
+		author_id.UUID, err = uuid.Parse(id)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid author ID", err)
+			return
+		}
+		author_id.Valid = true
 	}
+
+	page, err := parseChirpPage(r)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirps", err)
+		respondWithError(w, http.StatusBadRequest, "Invalid pagination parameters", err)
 		return
 	}
 
 	if order == "desc" {
-		sort.Slice(chirps, func(i, j int) bool {
-			return chirps[i].CreatedAt.After(chirps[j].CreatedAt)
+		chirps, err = cfg.db.ListChirpsDesc(r.Context(), database.ListChirpsDescParams{
+			UserID:         author_id,
+			AfterCreatedAt: page.afterCreatedAt,
+			AfterID:        page.afterID,
+			RowLimit:       page.rowLimit(),
+		})
+	} else {
+		chirps, err = cfg.db.ListChirpsAsc(r.Context(), database.ListChirpsAscParams{
+			UserID:         author_id,
+			AfterCreatedAt: page.afterCreatedAt,
+			AfterID:        page.afterID,
+			RowLimit:       page.rowLimit(),
 		})
 	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirps", err)
+		return
+	}
+	chirps = page.trim(w, r, chirps)
 
-	data := []Chirp {}
-	for _, chirp := range chirps {
-		data = append(data, Chirp {
-			ID: chirp.ID,
-			CreatedAt: chirp.CreatedAt,
-			UpdatedAt: chirp.UpdatedAt,
-			Body: chirp.Body,
-			UserID: chirp.UserID,
-		})
+	data, err := cfg.chirpsResponse(r.Context(), cfg.viewer(r), chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get likes", err)
+		return
 	}
 
 	respondWithJSON(w, http.StatusOK, data)
@@ -111,24 +296,36 @@ func (cfg *apiConfig) handlerGetChirps(w http.ResponseWriter, r *http.Request) {
 func (cfg *apiConfig) handlerGetChirp(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
-	chirp_id := r.PathValue("chirpID")
-	chirp, err := cfg.db.GetChirp(r.Context(), uuid.MustParse(chirp_id))
+	chirp_id, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+	chirp, err := cfg.db.GetChirp(r.Context(), chirp_id)
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "Couldn't get chirp", err)
 		return
 	}
+	if chirp.DeletedAt.Valid {
+		respondWithError(w, http.StatusNotFound, "Chirp was deleted", nil)
+		return
+	}
 
-	respondWithJSON(w, http.StatusOK, Chirp {
-		ID: chirp.ID,
-		CreatedAt: chirp.CreatedAt,
-		UpdatedAt: chirp.UpdatedAt,
-		Body: chirp.Body,
-		UserID: chirp.UserID,
-	})
+	data, err := cfg.chirpsResponse(r.Context(), cfg.viewer(r), []database.Chirp{chirp})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get likes", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, data[0])
 }
 
 func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Request) {
-	chirp_id := r.PathValue("chirpID")
+	chirp_id, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
 
 	token, err := auth.GetBearerToken(r.Header)
 	if err != nil {
@@ -136,24 +333,30 @@ func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	user_id, err := auth.ValidateJWT(token, cfg.secret_key)
+	claims, err := cfg.keyring.ValidateAccessToken(token)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Token is invalid", err)
 		return
 	}
 
-	chirp, err := cfg.db.GetChirp(r.Context(), uuid.MustParse(chirp_id))
-	if err != nil {
+	chirp, err := cfg.db.GetChirp(r.Context(), chirp_id)
+	if err != nil || chirp.DeletedAt.Valid {
 		respondWithError(w, http.StatusNotFound, "Chirp is not found", err)
 		return
 	}
 
-	if chirp.UserID != user_id {
+	// Moderators may delete anyone's chirps.
+	if chirp.UserID != claims.UserID && !claims.Role.AtLeast(auth.RoleModerator) {
 		respondWithError(w, http.StatusForbidden, "Chirp is not yours", err)
 		return
 	}
 
-	err = cfg.db.DeleteChirp(r.Context(), chirp.ID)
+	// A chirp with replies becomes a tombstone so the thread stays intact.
+	if chirp.ReplyCount > 0 {
+		err = cfg.db.TombstoneChirp(r.Context(), chirp.ID)
+	} else {
+		err = cfg.db.DeleteChirp(r.Context(), chirp.ID)
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp", err)
 		return
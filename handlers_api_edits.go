@@ -0,0 +1,108 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/YaguarEgor/chirpy_server/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerEditChirp replaces the body of one of the caller's chirps, for
+// tiers that allow editing. The new body goes through the same checks as a
+// new chirp, and the hashtags, mentions and review flag follow the new
+// body.
+func (cfg *apiConfig) handlerEditChirp(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	chirp_id, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+	user_id, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT token", err)
+		return
+	}
+	if !cfg.requireVerifiedEmail(w, r, user_id) {
+		return
+	}
+
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode request", err)
+		return
+	}
+
+	chirp, err := cfg.db.GetChirp(r.Context(), chirp_id)
+	if err != nil || chirp.DeletedAt.Valid {
+		respondWithError(w, http.StatusNotFound, "Chirp is not found", err)
+		return
+	}
+	if chirp.UserID != user_id {
+		respondWithError(w, http.StatusForbidden, "Chirp is not yours", nil)
+		return
+	}
+	if chirp.Kind == "rechirp" {
+		respondWithError(w, http.StatusBadRequest, "Rechirps have no body to edit", nil)
+		return
+	}
+	limits, err := cfg.limitsFor(r.Context(), user_id)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User is not found", err)
+		return
+	}
+	if !limits.CanEditChirps {
+		respondWithError(w, http.StatusForbidden, "Editing chirps requires Chirpy Red", nil)
+		return
+	}
+
+	draft, err := cfg.checkChirp(r.Context(), user_id, limits, params.Body)
+	if err != nil {
+		respondWithChirpError(w, err)
+		return
+	}
+
+	err = cfg.db.InTx(r.Context(), func(db database.Store) error {
+		var err error
+		chirp, err = db.UpdateChirpBody(r.Context(), database.UpdateChirpBodyParams{
+			ID:   chirp.ID,
+			Body: draft.Body,
+		})
+		if err != nil {
+			return err
+		}
+		err = db.PruneChirpTags(r.Context(), database.PruneChirpTagsParams{
+			ChirpID: chirp.ID,
+			Keep:    extractHashtags(chirp.Body),
+		})
+		if err != nil {
+			return err
+		}
+		return publishChirp(r.Context(), db, chirp, draft)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Chirp is not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp", err)
+		return
+	}
+
+	data, err := cfg.chirpsResponse(r.Context(), uuid.NullUUID{UUID: user_id, Valid: true}, []database.Chirp{chirp})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get likes", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, data[0])
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/YaguarEgor/chirpy_server/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerFollow(w http.ResponseWriter, r *http.Request) {
+	follower_id, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT token", err)
+		return
+	}
+
+	followee_id, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+	if followee_id == follower_id {
+		respondWithError(w, http.StatusBadRequest, "You can't follow yourself", nil)
+		return
+	}
+	if _, err := cfg.db.GetUserByID(r.Context(), followee_id); err != nil {
+		respondWithError(w, http.StatusNotFound, "User is not found", err)
+		return
+	}
+
+	err = cfg.db.FollowUser(r.Context(), database.FollowUserParams{
+		FollowerID: follower_id,
+		FolloweeID: followee_id,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't follow user", err)
+		return
+	}
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+func (cfg *apiConfig) handlerUnfollow(w http.ResponseWriter, r *http.Request) {
+	follower_id, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT token", err)
+		return
+	}
+
+	followee_id, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	err = cfg.db.UnfollowUser(r.Context(), database.UnfollowUserParams{
+		FollowerID: follower_id,
+		FolloweeID: followee_id,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unfollow user", err)
+		return
+	}
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+func (cfg *apiConfig) handlerGetFollowers(w http.ResponseWriter, r *http.Request) {
+	cfg.respondWithFollowList(w, r, cfg.db.GetFollowers)
+}
+
+func (cfg *apiConfig) handlerGetFollowing(w http.ResponseWriter, r *http.Request) {
+	cfg.respondWithFollowList(w, r, cfg.db.GetFollowing)
+}
+
+// respondWithFollowList answers GET /api/users/{userID}/followers and
+// /following, which only differ in the query they run.
+func (cfg *apiConfig) respondWithFollowList(w http.ResponseWriter, r *http.Request, list func(ctx context.Context, id uuid.UUID) ([]database.User, error)) {
+	user_id, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+	if _, err := cfg.db.GetUserByID(r.Context(), user_id); err != nil {
+		respondWithError(w, http.StatusNotFound, "User is not found", err)
+		return
+	}
+
+	users, err := list(r.Context(), user_id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get users", err)
+		return
+	}
+
+	// The lists are public, so they only show public profiles.
+	data := []PublicUser{}
+	for _, user := range users {
+		data = append(data, newPublicUser(user))
+	}
+	respondWithJSON(w, http.StatusOK, data)
+}
+
+func (cfg *apiConfig) handlerTimeline(w http.ResponseWriter, r *http.Request) {
+	user_id, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT token", err)
+		return
+	}
+
+	page, err := parseChirpPage(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid pagination parameters", err)
+		return
+	}
+
+	chirps, err := cfg.db.GetTimeline(r.Context(), database.GetTimelineParams{
+		FollowerID:     user_id,
+		AfterCreatedAt: page.afterCreatedAt,
+		AfterID:        page.afterID,
+		RowLimit:       page.rowLimit(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get timeline", err)
+		return
+	}
+	chirps = page.trim(w, r, chirps)
+
+	data, err := cfg.chirpsResponse(r.Context(), uuid.NullUUID{UUID: user_id, Valid: true}, chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get likes", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, data)
+}
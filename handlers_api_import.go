@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/YaguarEgor/chirpy_server/internal/content"
+	"github.com/YaguarEgor/chirpy_server/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxImportChirps = 100
+
+// handlerImportChirps adds chirps from an archive to the caller's account,
+// keeping the time each was posted. Every body is checked like a new chirp,
+// and the chirps are stored in one transaction, so nothing is imported
+// unless all of them pass. Imported chirps are tagged and flagged like new
+// ones, but the users they mention are not notified about old news. Their
+// old dates keep them out of the posting rate, so they count toward the
+// tier's ImportsPerHour instead, by the time they were imported.
+func (cfg *apiConfig) handlerImportChirps(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	type importedChirp struct {
+		Body      string    `json:"body"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+	type parameters struct {
+		Chirps []importedChirp `json:"chirps"`
+	}
+
+	user_id, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT token", err)
+		return
+	}
+	if !cfg.requireVerifiedEmail(w, r, user_id) {
+		return
+	}
+	limits, err := cfg.limitsFor(r.Context(), user_id)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User is not found", err)
+		return
+	}
+
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode request", err)
+		return
+	}
+	if len(params.Chirps) == 0 {
+		respondWithError(w, http.StatusBadRequest, "No chirps to import", nil)
+		return
+	}
+	if len(params.Chirps) > maxImportChirps {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("At most %d chirps can be imported at once", maxImportChirps), nil)
+		return
+	}
+
+	now := time.Now().UTC()
+	drafts := make([]content.Draft, len(params.Chirps))
+	for i, imported := range params.Chirps {
+		if imported.CreatedAt.IsZero() {
+			params.Chirps[i].CreatedAt = now
+		} else if imported.CreatedAt.After(now) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("chirps[%d]: Chirp is dated in the future", i), nil)
+			return
+		}
+		// The column has no time zone, so store UTC like every other time.
+		params.Chirps[i].CreatedAt = params.Chirps[i].CreatedAt.UTC()
+
+		drafts[i], err = cfg.checkChirp(r.Context(), user_id, limits, imported.Body)
+		var reject *content.RejectError
+		if errors.As(err, &reject) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("chirps[%d]: %s", i, reject.Reason), nil)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check chirp", err)
+			return
+		}
+	}
+
+	var chirps []database.Chirp
+	err = cfg.db.InTx(r.Context(), func(db database.Store) error {
+		if err := takeImportSlots(r.Context(), db, user_id, limits, len(drafts)); err != nil {
+			return err
+		}
+		for i, draft := range drafts {
+			chirp, err := db.ImportChirp(r.Context(), database.ImportChirpParams{
+				CreatedAt: params.Chirps[i].CreatedAt,
+				Body:      draft.Body,
+				UserID:    user_id,
+			})
+			if err != nil {
+				return err
+			}
+			if err := flagChirp(r.Context(), db, chirp, draft); err != nil {
+				return err
+			}
+			if err := tagChirp(r.Context(), db, chirp); err != nil {
+				return err
+			}
+			chirps = append(chirps, chirp)
+		}
+		return nil
+	})
+	var too_many *rateError
+	if errors.As(err, &too_many) {
+		respondWithRateError(w, too_many)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't add chirps to database", err)
+		return
+	}
+
+	data, err := cfg.chirpsResponse(r.Context(), uuid.NullUUID{UUID: user_id, Valid: true}, chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get likes", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, data)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/YaguarEgor/chirpy_server/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerLikeChirp(w http.ResponseWriter, r *http.Request) {
+	user_id, chirp, ok := cfg.likeTarget(w, r)
+	if !ok {
+		return
+	}
+	err := cfg.db.LikeChirp(r.Context(), database.LikeChirpParams{
+		UserID:  user_id,
+		ChirpID: chirp.ID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't like chirp", err)
+		return
+	}
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+func (cfg *apiConfig) handlerUnlikeChirp(w http.ResponseWriter, r *http.Request) {
+	user_id, chirp, ok := cfg.likeTarget(w, r)
+	if !ok {
+		return
+	}
+	err := cfg.db.UnlikeChirp(r.Context(), database.UnlikeChirpParams{
+		UserID:  user_id,
+		ChirpID: chirp.ID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unlike chirp", err)
+		return
+	}
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// likeTarget authenticates the caller and loads the chirp named in the
+// path. It writes the error response itself and reports whether the caller
+// should go on.
+func (cfg *apiConfig) likeTarget(w http.ResponseWriter, r *http.Request) (uuid.UUID, database.Chirp, bool) {
+	user_id, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT token", err)
+		return uuid.Nil, database.Chirp{}, false
+	}
+	chirp_id, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return uuid.Nil, database.Chirp{}, false
+	}
+	chirp, err := cfg.db.GetChirp(r.Context(), chirp_id)
+	if err != nil || chirp.DeletedAt.Valid {
+		respondWithError(w, http.StatusNotFound, "Chirp is not found", err)
+		return uuid.Nil, database.Chirp{}, false
+	}
+	return user_id, chirp, true
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/YaguarEgor/chirpy_server/internal/lockout"
+)
+
+// Failed logins are counted per account and per client address. An
+// address gets more attempts than an account because many users can share
+// one.
+var (
+	accountLockoutPolicy = lockout.Policy{
+		FreeAttempts: 5,
+		BaseDelay:    time.Second,
+		MaxDelay:     15 * time.Minute,
+		ResetAfter:   time.Hour,
+	}
+	ipLockoutPolicy = lockout.Policy{
+		FreeAttempts: 20,
+		BaseDelay:    time.Second,
+		MaxDelay:     15 * time.Minute,
+		ResetAfter:   time.Hour,
+	}
+)
+
+func accountLockoutKey(email string) string {
+	return "account:" + strings.ToLower(email)
+}
+
+func (cfg *apiConfig) ipLockoutKey(r *http.Request) string {
+	return "ip:" + cfg.clientIP(r)
+}
+
+// checkLockout counts a login attempt against the account and the client
+// before the credentials are checked, so parallel guesses can't all get in
+// before the first of them fails. If either has failed too often to try
+// again yet, it responds with 429 and returns false.
+func (cfg *apiConfig) checkLockout(w http.ResponseWriter, r *http.Request, email string) bool {
+	account_wait, err := cfg.account_lockout.Attempt(r.Context(), accountLockoutKey(email))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check failed logins", err)
+		return false
+	}
+	var ip_wait time.Duration
+	if account_wait == 0 {
+		ip_wait, err = cfg.ip_lockout.Attempt(r.Context(), cfg.ipLockoutKey(r))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check failed logins", err)
+			return false
+		}
+		if ip_wait > 0 {
+			// The attempt is refused, so it can't count against the account.
+			cfg.forgiveAttempt(r.Context(), cfg.account_lockout, accountLockoutKey(email))
+		}
+	}
+	wait := max(account_wait, ip_wait)
+	if wait == 0 {
+		return true
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	respondWithError(w, http.StatusTooManyRequests, "Too many failed login attempts, try again later",
+		fmt.Errorf("login for %q from %s locked out for %s (account %s, address %s)", email, cfg.clientIP(r), wait, account_wait, ip_wait))
+	return false
+}
+
+// loginFailed responds with 401; checkLockout has already counted the
+// attempt. The reason is logged; the client only gets msg, so it can't tell
+// an unknown email from a wrong password.
+func (cfg *apiConfig) loginFailed(w http.ResponseWriter, r *http.Request, email, msg string, reason error) {
+	respondWithError(w, http.StatusUnauthorized, msg, fmt.Errorf("login for %q from %s failed: %w", email, cfg.clientIP(r), reason))
+}
+
+// loginPassed takes back the attempt checkLockout counted, for a step of the
+// login that succeeded without finishing it, such as a password that still
+// needs a second factor.
+func (cfg *apiConfig) loginPassed(r *http.Request, email string) {
+	cfg.forgiveAttempt(r.Context(), cfg.account_lockout, accountLockoutKey(email))
+	cfg.forgiveAttempt(r.Context(), cfg.ip_lockout, cfg.ipLockoutKey(r))
+}
+
+// loginSucceeded clears the account's failures. Only the client's attempt
+// is taken back, or logging into an account of one's own would reset its
+// failures.
+func (cfg *apiConfig) loginSucceeded(r *http.Request, email string) {
+	err := cfg.account_lockout.Reset(r.Context(), accountLockoutKey(email))
+	if err != nil {
+		log.Printf("Couldn't reset failed logins: %v", err)
+	}
+	cfg.forgiveAttempt(r.Context(), cfg.ip_lockout, cfg.ipLockoutKey(r))
+}
+
+func (cfg *apiConfig) forgiveAttempt(ctx context.Context, limiter *lockout.Limiter, key string) {
+	err := limiter.Forgive(ctx, key)
+	if err != nil {
+		log.Printf("Couldn't take back login attempt: %v", err)
+	}
+}
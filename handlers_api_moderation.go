@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/YaguarEgor/chirpy_server/internal/database"
+	"github.com/google/uuid"
+)
+
+// FlaggedChirp is a chirp waiting for review, with the banned words that
+// flagged it.
+type FlaggedChirp struct {
+	Chirp
+	FlaggedWords []string `json:"flagged_words"`
+}
+
+// handlerGetFlaggedChirps lists the review queue, oldest chirp first.
+func (cfg *apiConfig) handlerGetFlaggedChirps(w http.ResponseWriter, r *http.Request) {
+	page, err := parseChirpPage(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid pagination parameters", err)
+		return
+	}
+
+	rows, err := cfg.db.ListFlaggedChirps(r.Context(), database.ListFlaggedChirpsParams{
+		AfterCreatedAt: page.afterCreatedAt,
+		AfterID:        page.afterID,
+		RowLimit:       page.rowLimit(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get flagged chirps", err)
+		return
+	}
+	chirps := make([]database.Chirp, len(rows))
+	for i, row := range rows {
+		chirps[i] = row.Chirp
+	}
+	chirps = page.trim(w, r, chirps)
+
+	chirp_data, err := cfg.chirpsResponse(r.Context(), uuid.NullUUID{}, chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get referenced chirps", err)
+		return
+	}
+	data := []FlaggedChirp{}
+	for i, chirp := range chirp_data {
+		data = append(data, FlaggedChirp{Chirp: chirp, FlaggedWords: rows[i].FlaggedWords})
+	}
+
+	respondWithJSON(w, http.StatusOK, data)
+}
+
+// handlerReviewChirpFlag takes a chirp off the review queue and leaves it
+// up. Chirps that break the rules are removed with DELETE /api/chirps/{id}
+// instead.
+func (cfg *apiConfig) handlerReviewChirpFlag(w http.ResponseWriter, r *http.Request) {
+	chirp_id, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+
+	reviewed, err := cfg.db.ReviewChirpFlag(r.Context(), chirp_id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't review flag", err)
+		return
+	}
+	if reviewed == 0 {
+		respondWithError(w, http.StatusNotFound, "Chirp is not flagged", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
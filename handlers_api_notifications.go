@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/YaguarEgor/chirpy_server/internal/content"
+	"github.com/YaguarEgor/chirpy_server/internal/database"
+	"github.com/google/uuid"
+)
+
+// mentionPattern matches an @ that starts a word, so email addresses are not
+// mentions.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@])@([\p{L}\p{N}_]+)`)
+
+type Notification struct {
+	ID          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Kind        string    `json:"kind"`
+	ActorID     uuid.UUID `json:"actor_id"`
+	ActorHandle string    `json:"actor_handle"`
+	ChirpID     uuid.UUID `json:"chirp_id"`
+	Read        bool      `json:"read"`
+}
+
+// extractMentions returns the distinct handles mentioned in a chirp body.
+// Anything that can't be a handle is ignored.
+func extractMentions(body string) []string {
+	var handles []string
+	seen := map[string]bool{}
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		handle, err := normalizeHandle(match[1])
+		if err != nil || seen[handle] {
+			continue
+		}
+		seen[handle] = true
+		handles = append(handles, handle)
+	}
+	return handles
+}
+
+// notifyMentions notifies every user a chirp mentions except its author.
+// A user is notified once per chirp, however often it is edited.
+func notifyMentions(ctx context.Context, db database.Store, chirp database.Chirp, mentions []content.Mention) error {
+	for _, mention := range mentions {
+		if mention.UserID == chirp.UserID {
+			continue
+		}
+		err := db.CreateNotification(ctx, database.CreateNotificationParams{
+			UserID:  mention.UserID,
+			ActorID: chirp.UserID,
+			ChirpID: chirp.ID,
+			Kind:    "mention",
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handlerGetNotifications lists the caller's notifications, newest first.
+// With unread=true only unread ones are returned.
+func (cfg *apiConfig) handlerGetNotifications(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	user_id, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT token", err)
+		return
+	}
+
+	page, err := parseChirpPage(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid pagination parameters", err)
+		return
+	}
+
+	rows, err := cfg.db.ListNotifications(r.Context(), database.ListNotificationsParams{
+		UserID:         user_id,
+		UnreadOnly:     r.URL.Query().Get("unread") == "true",
+		AfterCreatedAt: page.afterCreatedAt,
+		AfterID:        page.afterID,
+		RowLimit:       page.rowLimit(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get notifications", err)
+		return
+	}
+	// Notifications are keyed by (created_at, id) just like chirps, so they
+	// share the chirp cursor.
+	if len(rows) > page.limit {
+		rows = rows[:page.limit]
+		last := rows[len(rows)-1].Notification
+		setNextLink(w, r, encodeCursor(chirpCursor{CreatedAt: last.CreatedAt, ID: last.ID}))
+	}
+
+	data := []Notification{}
+	for _, row := range rows {
+		data = append(data, Notification{
+			ID:          row.Notification.ID,
+			CreatedAt:   row.Notification.CreatedAt,
+			Kind:        row.Notification.Kind,
+			ActorID:     row.Notification.ActorID,
+			ActorHandle: row.ActorHandle,
+			ChirpID:     row.Notification.ChirpID,
+			Read:        row.Notification.ReadAt.Valid,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, data)
+}
+
+// handlerMarkNotificationsRead marks the notifications listed in `ids` as
+// read, or all of them when the body is empty.
+func (cfg *apiConfig) handlerMarkNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	type parameters struct {
+		IDs []uuid.UUID `json:"ids"`
+	}
+
+	user_id, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT token", err)
+		return
+	}
+
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode request", err)
+		return
+	}
+
+	if params.IDs == nil {
+		err = cfg.db.MarkAllNotificationsRead(r.Context(), user_id)
+	} else {
+		err = cfg.db.MarkNotificationsRead(r.Context(), database.MarkNotificationsReadParams{
+			UserID: user_id,
+			Ids:    params.IDs,
+		})
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't mark notifications as read", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/YaguarEgor/chirpy_server/internal/auth"
+	"github.com/YaguarEgor/chirpy_server/internal/database"
+	"github.com/YaguarEgor/chirpy_server/internal/lockout"
+	"github.com/YaguarEgor/chirpy_server/internal/mail"
+)
+
+const passwordResetTTL = time.Hour
+
+// Every request for a reset email counts against the address, whether or
+// not it has an account, so nobody can flood someone's mailbox with them.
+var passwordResetPolicy = lockout.Policy{
+	FreeAttempts: 3,
+	BaseDelay:    time.Minute,
+	MaxDelay:     time.Hour,
+	ResetAfter:   time.Hour,
+}
+
+func passwordResetKey(email string) string {
+	return "reset:" + strings.ToLower(email)
+}
+
+// sendPasswordResetEmail mails the user a one-time token that lets them set
+// a new password. Only the token's hash is stored.
+func (cfg *apiConfig) sendPasswordResetEmail(ctx context.Context, user database.User) error {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return err
+	}
+	err = cfg.db.CreatePasswordResetToken(ctx, database.CreatePasswordResetTokenParams{
+		TokenHash: cfg.hashToken(token),
+		UserID:    user.ID,
+		Email:     user.Email,
+		ExpiresAt: time.Now().UTC().Add(passwordResetTTL),
+	})
+	if err != nil {
+		return err
+	}
+	return cfg.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Send the token below with your new password to POST /api/password/reset.\n\n%s\n\nThe token expires in %s. If you didn't ask for a reset, ignore this email.",
+			token, passwordResetTTL),
+	})
+}
+
+// handlerForgotPassword emails a reset token if the address belongs to an
+// account. It answers 202 whether or not it does, and before looking, so
+// neither the answer nor how long it takes tells which addresses are
+// registered.
+func (cfg *apiConfig) handlerForgotPassword(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode request", err)
+		return
+	}
+
+	wait, err := cfg.reset_lockout.Attempt(r.Context(), passwordResetKey(params.Email))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check password resets", err)
+		return
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		respondWithError(w, http.StatusTooManyRequests, "Too many password resets requested, try again later", nil)
+		return
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+	cfg.mail_jobs.Add(1)
+	go func() {
+		defer cfg.mail_jobs.Done()
+		user, err := cfg.db.GetUserByEmail(ctx, params.Email)
+		if err == nil {
+			err = cfg.sendPasswordResetEmail(ctx, user)
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Couldn't send password reset email: %v", err)
+		}
+	}()
+
+	respondWithJSON(w, http.StatusAccepted, nil)
+}
+
+// handlerResetPassword sets a new password with a token from
+// handlerForgotPassword. Every session of the user is logged out, since
+// whoever knew the old password may still hold one.
+func (cfg *apiConfig) handlerResetPassword(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode request", err)
+		return
+	}
+	if params.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "Password is empty", nil)
+		return
+	}
+
+	reset, err := cfg.db.UsePasswordResetToken(r.Context(), cfg.hashToken(params.Token))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusBadRequest, "Token is invalid or expired", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check token", err)
+		return
+	}
+	// The token was mailed to an address the account no longer uses.
+	user, err := cfg.db.GetUserByID(r.Context(), reset.UserID)
+	if err != nil || user.Email != reset.Email {
+		respondWithError(w, http.StatusBadRequest, "Token is invalid or expired", err)
+		return
+	}
+
+	hashed_passwd, err := cfg.passwords.Hash(params.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		return
+	}
+	err = cfg.db.UpdatePassword(r.Context(), database.UpdatePasswordParams{
+		ID:             user.ID,
+		HashedPassword: hashed_passwd,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update password", err)
+		return
+	}
+	err = cfg.db.ExpirePasswordResetTokens(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't expire reset tokens", err)
+		return
+	}
+	err = cfg.db.RevokeUserRefreshTokens(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
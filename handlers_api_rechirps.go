@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/YaguarEgor/chirpy_server/internal/content"
+	"github.com/YaguarEgor/chirpy_server/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerRechirp re-shares a chirp. Without a body it is a plain rechirp,
+// which a user can only make once per chirp; with a body it is a quote.
+func (cfg *apiConfig) handlerRechirp(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	user_id, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT token", err)
+		return
+	}
+	if !cfg.requireVerifiedEmail(w, r, user_id) {
+		return
+	}
+	limits, err := cfg.limitsFor(r.Context(), user_id)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User is not found", err)
+		return
+	}
+
+	chirp_id, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode request", err)
+		return
+	}
+
+	original, err := cfg.db.GetChirp(r.Context(), chirp_id)
+	if err != nil || original.DeletedAt.Valid {
+		respondWithError(w, http.StatusNotFound, "Chirp is not found", err)
+		return
+	}
+	// Re-sharing a rechirp re-shares the chirp it points at.
+	if original.Kind == "rechirp" {
+		if !original.ReferenceID.Valid {
+			respondWithError(w, http.StatusNotFound, "Chirp is no longer available", nil)
+			return
+		}
+		original, err = cfg.db.GetChirp(r.Context(), original.ReferenceID.UUID)
+		if err != nil || original.DeletedAt.Valid {
+			respondWithError(w, http.StatusNotFound, "Chirp is no longer available", err)
+			return
+		}
+	}
+
+	kind := "rechirp"
+	var draft content.Draft
+	if params.Body != "" {
+		kind = "quote"
+		draft, err = cfg.checkChirp(r.Context(), user_id, limits, params.Body)
+		if err != nil {
+			respondWithChirpError(w, err)
+			return
+		}
+	}
+
+	var chirp database.Chirp
+	err = cfg.db.InTx(r.Context(), func(db database.Store) error {
+		err := takePostingSlot(r.Context(), db, user_id, limits)
+		if err != nil {
+			return err
+		}
+		chirp, err = db.CreateChirp(r.Context(), database.CreateChirpParams{
+			Body:        draft.Body,
+			UserID:      user_id,
+			Kind:        kind,
+			ReferenceID: uuid.NullUUID{UUID: original.ID, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+		return publishChirp(r.Context(), db, chirp, draft)
+	})
+	var too_many *rateError
+	if errors.As(err, &too_many) {
+		respondWithRateError(w, too_many)
+		return
+	}
+	if database.IsUniqueViolation(err) {
+		respondWithError(w, http.StatusConflict, "Chirp is already rechirped", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't add chirp to database", err)
+		return
+	}
+
+	data, err := cfg.chirpsResponse(r.Context(), uuid.NullUUID{UUID: user_id, Valid: true}, []database.Chirp{chirp})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get referenced chirp", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, data[0])
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"html"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/YaguarEgor/chirpy_server/internal/database"
+	"github.com/google/uuid"
+)
+
+// Postgres wraps matched terms in these private-use runes. The headline is
+// HTML-escaped before they are swapped for <mark> tags, so chirp bodies can't
+// inject markup into search results.
+const (
+	highlightStart = "\uE000"
+	highlightStop  = "\uE001"
+)
+
+type ChirpSearchResult struct {
+	Chirp
+	Rank     float32 `json:"rank"`
+	Headline string  `json:"headline"`
+}
+
+func (cfg *apiConfig) handlerSearchChirps(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		respondWithError(w, http.StatusBadRequest, "Search query is empty", nil)
+		return
+	}
+
+	var author_id uuid.NullUUID
+	if id := r.URL.Query().Get("author_id"); id != "" {
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid author ID", err)
+			return
+		}
+		author_id = uuid.NullUUID{UUID: parsed, Valid: true}
+	}
+
+	limit, err := parseLimit(r.URL.Query().Get("limit"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid limit", err)
+		return
+	}
+
+	offset := 0
+	if c := r.URL.Query().Get("cursor"); c != "" {
+		cursor, err := decodeCursor[searchCursor](c)
+		// The offset has to fit in the query's int32 together with the
+		// limit.
+		if err != nil || cursor.Offset < 0 || cursor.Offset > math.MaxInt32-limit-1 {
+			respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+			return
+		}
+		offset = cursor.Offset
+	}
+
+	rows, err := cfg.db.SearchChirps(r.Context(), database.SearchChirpsParams{
+		StartSel:  highlightStart,
+		StopSel:   highlightStop,
+		Query:     query,
+		UserID:    author_id,
+		RowLimit:  int32(limit + 1),
+		RowOffset: int32(offset),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search chirps", err)
+		return
+	}
+
+	if len(rows) > limit {
+		rows = rows[:limit]
+		setNextLink(w, r, encodeCursor(searchCursor{Offset: offset + limit}))
+	}
+
+	chirps := make([]database.Chirp, len(rows))
+	for i, row := range rows {
+		chirps[i] = row.Chirp
+	}
+	converted, err := cfg.chirpsResponse(r.Context(), cfg.viewer(r), chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search chirps", err)
+		return
+	}
+
+	data := []ChirpSearchResult{}
+	for i, row := range rows {
+		data = append(data, ChirpSearchResult{
+			Chirp:    converted[i],
+			Rank:     row.Rank,
+			Headline: highlight(row.Headline),
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, data)
+}
+
+// highlight escapes a headline and turns the highlight markers into <mark>
+// tags.
+func highlight(headline string) string {
+	return strings.NewReplacer(
+		highlightStart, "<mark>",
+		highlightStop, "</mark>",
+	).Replace(html.EscapeString(headline))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/YaguarEgor/chirpy_server/internal/database"
+	"github.com/google/uuid"
+)
+
+// Session is one login: the refresh token family it started, identified by
+// the family ID so it keeps the same ID across refreshes.
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+}
+
+// clientIP returns the address the request came from. Behind a proxy in
+// cfg.trusted_proxies that is the last address in X-Forwarded-For that
+// isn't another trusted proxy; anything before it could have been made up
+// by the client. Without trusted proxies forwarding headers are ignored.
+func (cfg *apiConfig) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !cfg.isTrustedProxy(addr) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = hop
+		if !cfg.isTrustedProxy(hop) {
+			break
+		}
+	}
+	return addr.Unmap().String()
+}
+
+func (cfg *apiConfig) isTrustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, proxy := range cfg.trusted_proxies {
+		if proxy.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg *apiConfig) handlerGetSessions(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	user_id, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT token", err)
+		return
+	}
+
+	rows, err := cfg.db.ListSessions(r.Context(), user_id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get sessions", err)
+		return
+	}
+
+	data := []Session{}
+	for _, row := range rows {
+		data = append(data, Session{
+			ID:         row.FamilyID,
+			CreatedAt:  row.CreatedAt,
+			LastUsedAt: row.LastUsedAt,
+			ExpiresAt:  row.ExpiresAt,
+			UserAgent:  row.UserAgent,
+			IP:         row.Ip,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, data)
+}
+
+func (cfg *apiConfig) handlerRevokeSession(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	user_id, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT token", err)
+		return
+	}
+
+	session_id, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid session ID", err)
+		return
+	}
+
+	revoked, err := cfg.db.RevokeSession(r.Context(), database.RevokeSessionParams{
+		FamilyID: session_id,
+		UserID:   user_id,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, http.StatusNotFound, "Session is not found", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// handlerRevokeSessions logs the caller out everywhere. Access tokens
+// already issued stay valid until they expire.
+func (cfg *apiConfig) handlerRevokeSessions(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	user_id, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT token", err)
+		return
+	}
+
+	err = cfg.db.RevokeUserRefreshTokens(r.Context(), user_id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/YaguarEgor/chirpy_server/internal/database"
+)
+
+const (
+	defaultTrendingWindow = 24 * time.Hour
+	maxTrendingWindow     = 7 * 24 * time.Hour
+	trendingLimit         = 10
+	maxTagLength          = 50
+)
+
+// hashtagPattern matches a # that starts a word, so "a#b" and "&#39;" are not
+// tags.
+var hashtagPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&#])#([\p{L}\p{N}_]+)`)
+
+type TrendingTag struct {
+	Tag   string  `json:"tag"`
+	Uses  int32   `json:"uses"`
+	Score float64 `json:"score"`
+}
+
+// extractHashtags returns the distinct, lowercased tags of a chirp body in
+// the order they first appear. Tags need at least one letter, so "#1" is
+// just a number.
+func extractHashtags(body string) []string {
+	var tags []string
+	seen := map[string]bool{}
+	for _, match := range hashtagPattern.FindAllStringSubmatch(body, -1) {
+		tag := normalizeTag(match[1])
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// normalizeTag lowercases a tag and drops a leading #. It returns "" for
+// strings that are not valid tags.
+func normalizeTag(s string) string {
+	tag := strings.ToLower(strings.TrimPrefix(s, "#"))
+	if tag == "" || len(tag) > maxTagLength || strings.IndexFunc(tag, unicode.IsLetter) < 0 {
+		return ""
+	}
+	for _, r := range tag {
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsNumber(r) {
+			return ""
+		}
+	}
+	return tag
+}
+
+// tagChirp stores the hashtags of a chirp.
+func tagChirp(ctx context.Context, db database.Store, chirp database.Chirp) error {
+	for _, name := range extractHashtags(chirp.Body) {
+		tag, err := db.UpsertTag(ctx, name)
+		if err != nil {
+			return err
+		}
+		err = db.AddChirpTag(ctx, database.AddChirpTagParams{
+			ChirpID: chirp.ID,
+			TagID:   tag.ID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cfg *apiConfig) handlerGetTagChirps(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	tag := normalizeTag(r.PathValue("tag"))
+	if tag == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid tag", nil)
+		return
+	}
+
+	page, err := parseChirpPage(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid pagination parameters", err)
+		return
+	}
+
+	chirps, err := cfg.db.GetChirpsByTag(r.Context(), database.GetChirpsByTagParams{
+		Tag:            tag,
+		AfterCreatedAt: page.afterCreatedAt,
+		AfterID:        page.afterID,
+		RowLimit:       page.rowLimit(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirps", err)
+		return
+	}
+	chirps = page.trim(w, r, chirps)
+
+	data, err := cfg.chirpsResponse(r.Context(), cfg.viewer(r), chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get likes", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, data)
+}
+
+// handlerTrendingTags ranks the tags used within `window` (a duration such
+// as "6h", 24h by default). A use counts for less the older it is: its
+// weight halves every quarter of the window.
+func (cfg *apiConfig) handlerTrendingTags(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	window, err := parseTrendingWindow(r.URL.Query().Get("window"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid window", err)
+		return
+	}
+
+	limit := trendingLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		limit, err = parseLimit(s)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid limit", err)
+			return
+		}
+	}
+
+	rows, err := cfg.db.GetTrendingTags(r.Context(), database.GetTrendingTagsParams{
+		HalfLifeSeconds: (window / 4).Seconds(),
+		WindowSeconds:   window.Seconds(),
+		RowLimit:        int32(limit),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get trending tags", err)
+		return
+	}
+
+	data := []TrendingTag{}
+	for _, row := range rows {
+		data = append(data, TrendingTag{
+			Tag:   row.Name,
+			Uses:  row.Uses,
+			Score: math.Round(row.Score*1000) / 1000,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, data)
+}
+
+func parseTrendingWindow(s string) (time.Duration, error) {
+	if s == "" {
+		return defaultTrendingWindow, nil
+	}
+	window, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if window < time.Minute || window > maxTrendingWindow {
+		return 0, fmt.Errorf("window must be between 1m and %s", maxTrendingWindow)
+	}
+	return window, nil
+}
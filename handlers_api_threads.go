@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/YaguarEgor/chirpy_server/internal/database"
+	"github.com/google/uuid"
+)
+
+// ChirpNode is a chirp together with its nested replies.
+type ChirpNode struct {
+	Chirp
+	Replies []ChirpNode `json:"replies"`
+}
+
+type ChirpThread struct {
+	// Ancestors runs from the root of the conversation down to the parent
+	// of Chirp.
+	Ancestors []Chirp   `json:"ancestors"`
+	Chirp     ChirpNode `json:"chirp"`
+}
+
+func (cfg *apiConfig) handlerGetThread(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	chirp_id, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+	chirp, err := cfg.db.GetChirp(r.Context(), chirp_id)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp", err)
+		return
+	}
+
+	ancestors, err := cfg.db.GetChirpAncestors(r.Context(), chirp.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thread", err)
+		return
+	}
+	descendants, err := cfg.db.GetChirpDescendants(r.Context(), chirp.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thread", err)
+		return
+	}
+
+	// Convert the whole thread at once so likes and referenced chirps are
+	// loaded with a single query each.
+	all := append(append([]database.Chirp{chirp}, ancestors...), descendants...)
+	converted, err := cfg.chirpsResponse(r.Context(), cfg.viewer(r), all)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thread", err)
+		return
+	}
+	root := converted[0]
+	thread := ChirpThread{Ancestors: converted[1 : 1+len(ancestors)]}
+
+	// Descendants come oldest first, so replies keep the order they were
+	// posted in.
+	children := map[uuid.UUID][]Chirp{}
+	for _, reply := range converted[1+len(ancestors):] {
+		parent := *reply.InReplyTo
+		children[parent] = append(children[parent], reply)
+	}
+	var build func(c Chirp) ChirpNode
+	build = func(c Chirp) ChirpNode {
+		node := ChirpNode{Chirp: c, Replies: []ChirpNode{}}
+		for _, reply := range children[c.ID] {
+			node.Replies = append(node.Replies, build(reply))
+		}
+		return node
+	}
+	thread.Chirp = build(root)
+
+	respondWithJSON(w, http.StatusOK, thread)
+}
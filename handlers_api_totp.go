@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/YaguarEgor/chirpy_server/internal/auth"
+	"github.com/YaguarEgor/chirpy_server/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	totpIssuer = "Chirpy"
+	// mfaChallengeTTL is how long a user has to enter their code after
+	// giving the right password.
+	mfaChallengeTTL   = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+// hasTOTP reports whether the user has confirmed TOTP enrollment, so
+// logging in takes a code as well as the password.
+func (cfg *apiConfig) hasTOTP(ctx context.Context, userID uuid.UUID) (bool, error) {
+	secret, err := cfg.db.GetTOTPSecret(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return secret.ConfirmedAt.Valid, nil
+}
+
+func (cfg *apiConfig) respondWithMFAChallenge(w http.ResponseWriter, user database.User) {
+	type response struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+	}
+
+	token, err := cfg.keyring.MakeMFAChallenge(user.ID, mfaChallengeTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error when creating MFA challenge", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		MFARequired: true,
+		MFAToken:    token,
+	})
+}
+
+// handlerLoginMFA finishes a login that handlerLogin answered with an MFA
+// challenge. It takes a code from the authenticator or, failing that, one of
+// the recovery codes.
+func (cfg *apiConfig) handlerLoginMFA(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	type parameters struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode request", err)
+		return
+	}
+	if params.Code == "" && params.RecoveryCode == "" {
+		respondWithError(w, http.StatusBadRequest, "Code is required", nil)
+		return
+	}
+
+	user_id, err := cfg.keyring.ValidateMFAChallenge(params.MFAToken)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "MFA challenge is invalid or expired", err)
+		return
+	}
+	user, err := cfg.db.GetUserByID(r.Context(), user_id)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "MFA challenge is invalid or expired", err)
+		return
+	}
+	if !cfg.checkLockout(w, r, user.Email) {
+		return
+	}
+	secret, err := cfg.db.GetTOTPSecret(r.Context(), user.ID)
+	if err != nil || !secret.ConfirmedAt.Valid {
+		respondWithError(w, http.StatusUnauthorized, "Two-factor authentication is not enabled", err)
+		return
+	}
+
+	if !cfg.useSecondFactor(w, r, user, secret, params.Code, params.RecoveryCode) {
+		return
+	}
+
+	cfg.loginSucceeded(r, user.Email)
+	cfg.respondWithSession(w, r, user)
+}
+
+// useSecondFactor checks a code from the authenticator or, when code is
+// empty, a recovery code, and uses it up so it can't be replayed. It
+// responds as a failed login and returns false if the code is wrong; the
+// caller has counted the attempt with checkLockout.
+func (cfg *apiConfig) useSecondFactor(w http.ResponseWriter, r *http.Request, user database.User, secret database.TotpSecret, code, recovery_code string) bool {
+	var used int64
+	var err error
+	if code != "" {
+		step, err := auth.ValidateTOTP(secret.Secret, code, time.Now())
+		if err != nil {
+			cfg.loginFailed(w, r, user.Email, "Incorrect code", err)
+			return false
+		}
+		// Only one login per code: the step must be newer than the last one.
+		used, err = cfg.db.UseTOTPStep(r.Context(), database.UseTOTPStepParams{
+			UserID:       user.ID,
+			LastUsedStep: step,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check code", err)
+			return false
+		}
+	} else {
+		used, err = cfg.db.UseRecoveryCode(r.Context(), database.UseRecoveryCodeParams{
+			UserID:   user.ID,
+			CodeHash: cfg.hashToken(auth.NormalizeRecoveryCode(recovery_code)),
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check recovery code", err)
+			return false
+		}
+	}
+	if used == 0 {
+		cfg.loginFailed(w, r, user.Email, "Incorrect code", errors.New("code is wrong or was already used"))
+		return false
+	}
+	return true
+}
+
+// handlerEnrollTOTP starts TOTP enrollment with a new secret. Nothing
+// changes for logging in until the secret is confirmed with a code, so
+// calling this again before then just replaces the secret.
+func (cfg *apiConfig) handlerEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	type response struct {
+		Secret     string `json:"secret"`
+		OtpauthURI string `json:"otpauth_uri"`
+	}
+
+	user_id, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT token", err)
+		return
+	}
+	user, err := cfg.db.GetUserByID(r.Context(), user_id)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get user", err)
+		return
+	}
+
+	secret, err := auth.MakeTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error when creating TOTP secret", err)
+		return
+	}
+	_, err = cfg.db.CreateTOTPSecret(r.Context(), database.CreateTOTPSecretParams{
+		UserID: user.ID,
+		Secret: secret,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save TOTP secret", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		Secret:     secret,
+		OtpauthURI: auth.TOTPURI(secret, totpIssuer, user.Email),
+	})
+}
+
+// handlerConfirmTOTP finishes enrollment once the user shows their
+// authenticator produces the right codes, and hands out the recovery codes.
+// They are only ever shown here; the server keeps their hashes.
+func (cfg *apiConfig) handlerConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	type parameters struct {
+		Code string `json:"code"`
+	}
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	user_id, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT token", err)
+		return
+	}
+
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode request", err)
+		return
+	}
+
+	secret, err := cfg.db.GetTOTPSecret(r.Context(), user_id)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusBadRequest, "Two-factor enrollment hasn't been started", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get TOTP secret", err)
+		return
+	}
+	if secret.ConfirmedAt.Valid {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+
+	step, err := auth.ValidateTOTP(secret.Secret, params.Code, time.Now())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Incorrect code", err)
+		return
+	}
+	confirmed, err := cfg.db.ConfirmTOTPSecret(r.Context(), database.ConfirmTOTPSecretParams{
+		UserID:       user_id,
+		LastUsedStep: step,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't confirm TOTP secret", err)
+		return
+	}
+	if confirmed == 0 {
+		respondWithError(w, http.StatusBadRequest, "Incorrect code", nil)
+		return
+	}
+
+	codes, err := auth.MakeRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error when creating recovery codes", err)
+		return
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = cfg.hashToken(auth.NormalizeRecoveryCode(code))
+	}
+	err = cfg.db.DeleteRecoveryCodes(r.Context(), user_id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save recovery codes", err)
+		return
+	}
+	err = cfg.db.CreateRecoveryCodes(r.Context(), database.CreateRecoveryCodesParams{
+		CodeHashes: hashes,
+		UserID:     user_id,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save recovery codes", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{RecoveryCodes: codes})
+}
+
+// confirmIdentity makes a logged in user prove who they are again, for
+// changes a stolen access token alone must not be able to make. It checks
+// the password and, with second_factor, a code when the user has TOTP
+// enabled. Wrong guesses count toward the same lockout as logins. It
+// responds and returns false unless the user passes.
+func (cfg *apiConfig) confirmIdentity(w http.ResponseWriter, r *http.Request, user database.User, password, code, recovery_code string, second_factor bool) bool {
+	if !cfg.checkLockout(w, r, user.Email) {
+		return false
+	}
+	_, err := cfg.passwords.Verify(password, user.HashedPassword)
+	if err != nil {
+		cfg.loginFailed(w, r, user.Email, "Incorrect password", err)
+		return false
+	}
+
+	if second_factor {
+		secret, err := cfg.db.GetTOTPSecret(r.Context(), user.ID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check two-factor authentication", err)
+			return false
+		}
+		if err == nil && secret.ConfirmedAt.Valid {
+			if code == "" && recovery_code == "" {
+				// The password was right, so only the missing code fails.
+				cfg.loginPassed(r, user.Email)
+				respondWithError(w, http.StatusUnauthorized, "Code is required", nil)
+				return false
+			}
+			if !cfg.useSecondFactor(w, r, user, secret, code, recovery_code) {
+				return false
+			}
+		}
+	}
+
+	cfg.loginSucceeded(r, user.Email)
+	return true
+}
+
+// handlerDisableTOTP turns two-factor authentication off. It takes the
+// password as well as an access token, and changing the password takes a
+// code, so a stolen access token alone can't remove the second factor.
+func (cfg *apiConfig) handlerDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	type parameters struct {
+		Password string `json:"password"`
+	}
+
+	user_id, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT token", err)
+		return
+	}
+
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode request", err)
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(r.Context(), user_id)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get user", err)
+		return
+	}
+	if !cfg.confirmIdentity(w, r, user, params.Password, "", "", false) {
+		return
+	}
+
+	err = cfg.db.DeleteTOTPSecret(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable two-factor authentication", err)
+		return
+	}
+	err = cfg.db.DeleteRecoveryCodes(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete recovery codes", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
-	"slices"
+	"regexp"
 	"strings"
 	"time"
 
@@ -18,10 +22,85 @@ type User struct {
 	CreatedAt 	 time.Time 	`json:"created_at"`
 	UpdatedAt 	 time.Time 	`json:"updated_at"`
 	Email     	 string    	`json:"email"`
+	Handle		 string		`json:"handle"`
+	EmailVerified bool		`json:"email_verified"`
 	IsChirpyRed	 bool		`json:"is_chirpy_red"`
+	// ChirpyRedExpiresAt is when Chirpy Red lapses unless it is renewed.
+	ChirpyRedExpiresAt *time.Time `json:"chirpy_red_expires_at,omitempty"`
+	Role		 string		`json:"role"`
 }
 
+// PublicUser is what anyone may see of a user, without logging in.
+type PublicUser struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Handle    string    `json:"handle"`
+}
+
+func newPublicUser(user database.User) PublicUser {
+	return PublicUser{
+		ID:        user.ID,
+		CreatedAt: user.CreatedAt,
+		Handle:    user.Handle,
+	}
+}
+
+func newUser(user database.User) User {
+	u := User{
+		ID:          user.ID,
+		CreatedAt:   user.CreatedAt,
+		UpdatedAt:   user.UpdatedAt,
+		Email:       user.Email,
+		Handle:        user.Handle,
+		EmailVerified: user.EmailVerified,
+		IsChirpyRed:   user.IsChirpyRed,
+		Role:          user.Role,
+	}
+	if user.ChirpyRedExpiresAt.Valid {
+		u.ChirpyRedExpiresAt = &user.ChirpyRedExpiresAt.Time
+	}
+	return u
+}
+
+
+
+// refreshTokenTTL is how long a refresh token lasts. Every refresh issues
+// a new token, so a client that keeps refreshing stays logged in.
+const refreshTokenTTL = 60 * 24 * time.Hour
+
+// handlePattern is what a handle looks like once lowercased: 3 to 15
+// letters, digits or underscores, the same characters a mention can hold.
+var handlePattern = regexp.MustCompile(`^[a-z0-9_]{3,15}$`)
+
+// normalizeHandle lowercases a handle, so handles are unique regardless of
+// case, and checks that it is valid.
+func normalizeHandle(s string) (string, error) {
+	handle := strings.ToLower(strings.TrimPrefix(s, "@"))
+	if !handlePattern.MatchString(handle) {
+		return "", errors.New("Handle must be 3 to 15 letters, digits or underscores")
+	}
+	return handle, nil
+}
+
+// reservedHandles can't be taken by users: "admin" is the handle
+// bootstrapAdmin creates the admin with.
+var reservedHandles = map[string]bool{
+	"admin": true,
+}
 
+// normalizeNewHandle normalizes a handle a user is choosing, which must not
+// be reserved. Mentions of reserved handles still resolve, so they go
+// through normalizeHandle alone.
+func normalizeNewHandle(s string) (string, error) {
+	handle, err := normalizeHandle(s)
+	if err != nil {
+		return "", err
+	}
+	if reservedHandles[handle] {
+		return "", errors.New("Handle is reserved")
+	}
+	return handle, nil
+}
 
 func handlerReadiness(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "text/plain; charset=utf-8")
@@ -29,23 +108,13 @@ func handlerReadiness(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(http.StatusText(http.StatusOK)))
 }
 
-func removeBadWords(s string) string {
-	bad_words := []string{"profane", "kerfuffle", "sharbert", "fornax"}
-	words := strings.Split(s, " ")
-	for ind, word := range words {
-		if slices.Contains(bad_words, strings.ToLower(word)) {
-			words[ind] = "****"
-		}
-	}
-	return strings.Join(words, " ")
-}
-
 func (cfg *apiConfig) handlerCreateUser(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	type parameters struct {
 		Email 	 	string 	`json:"email"`
 		Password 	string 	`json:"password"`
+		Handle		string	`json:"handle"`
 	}
 
 	var params parameters
@@ -57,7 +126,18 @@ func (cfg *apiConfig) handlerCreateUser(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	hashed_passwd, err := auth.HashPassword(params.Password)
+	err = validateEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	handle, err := normalizeNewHandle(params.Handle)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	hashed_passwd, err := cfg.passwords.Hash(params.Password)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
 		return
@@ -66,20 +146,24 @@ func (cfg *apiConfig) handlerCreateUser(w http.ResponseWriter, r *http.Request)
 	user, err := cfg.db.CreateUser(r.Context(), database.CreateUserParams{
 		Email: params.Email,
 		HashedPassword: hashed_passwd,
+		Handle: handle,
 	})
 
+	if database.IsUniqueViolation(err) {
+		respondWithError(w, http.StatusConflict, "Email or handle is already taken", err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create user", err)
 		return
 	}
-	my_user := User{
-		ID:        user.ID,
-		CreatedAt: user.CreatedAt,
-		UpdatedAt: user.UpdatedAt,
-		Email:     user.Email,
-		IsChirpyRed: user.IsChirpyRed,
+	// The account exists either way; if the email doesn't go out the user
+	// can ask for it again.
+	err = cfg.sendVerificationEmail(r.Context(), user)
+	if err != nil {
+		log.Printf("Couldn't send verification email to %s: %v", user.Email, err)
 	}
-	respondWithJSON(w, 201, my_user)
+	respondWithJSON(w, 201, newUser(user))
 }
 
 func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
@@ -90,12 +174,6 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		Password 	string 	`json:"password"`
 	}
 
-	type response struct {
-		User
-		JWTToken  	 string 	`json:"token"`
-		RefreshToken string 	`json:"refresh_token"`
-	}
-
 	var params parameters
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&params)
@@ -104,19 +182,76 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !cfg.checkLockout(w, r, params.Email) {
+		return
+	}
+
 	user, err := cfg.db.GetUserByEmail(r.Context(), params.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Take as long as a wrong password would, so the timing doesn't
+		// tell whether the email has an account.
+		cfg.passwords.Verify(params.Password, cfg.passwords.DummyHash())
+		cfg.loginFailed(w, r, params.Email, "Incorrect email or password", errors.New("no such user"))
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	rehash, err := cfg.passwords.Verify(params.Password, user.HashedPassword)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't find user with that email", err)
+		cfg.loginFailed(w, r, params.Email, "Incorrect email or password", err)
 		return
 	}
+	if rehash {
+		cfg.rehashPassword(r.Context(), user, params.Password)
+	}
 
-	err = auth.CheckPasswordHash(params.Password, user.HashedPassword)
+	enrolled, err := cfg.hasTOTP(r.Context(), user.ID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check two-factor authentication", err)
+		return
+	}
+	// The right password doesn't count as a failure, but the failures are
+	// only cleared once the second factor is given too, so knowing the
+	// password doesn't buy unlimited guesses at the code.
+	if enrolled {
+		cfg.loginPassed(r, params.Email)
+		cfg.respondWithMFAChallenge(w, user)
 		return
 	}
 
-	jwt_token, err := auth.MakeJWT(user.ID, cfg.secret_key, time.Hour)
+	cfg.loginSucceeded(r, params.Email)
+	cfg.respondWithSession(w, r, user)
+}
+
+// rehashPassword replaces an outdated password hash, now that the password
+// is known to be right. Failing to is logged but doesn't fail the login;
+// the old hash still works.
+func (cfg *apiConfig) rehashPassword(ctx context.Context, user database.User, password string) {
+	hashed_passwd, err := cfg.passwords.Hash(password)
+	if err == nil {
+		err = cfg.db.UpdatePassword(ctx, database.UpdatePasswordParams{
+			ID:             user.ID,
+			HashedPassword: hashed_passwd,
+		})
+	}
+	if err != nil {
+		log.Printf("Couldn't rehash password of user %s: %v", user.ID, err)
+	}
+}
+
+// respondWithSession starts a session for a user who has logged in: a new
+// refresh token family, and an access token.
+func (cfg *apiConfig) respondWithSession(w http.ResponseWriter, r *http.Request, user database.User) {
+	type response struct {
+		User
+		JWTToken  	 string 	`json:"token"`
+		RefreshToken string 	`json:"refresh_token"`
+	}
+
+	jwt_token, err := cfg.keyring.MakeJWT(user.ID, auth.Role(user.Role), time.Hour)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error when creating JWT token", err)
 		return
@@ -129,9 +264,12 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	_, err = cfg.db.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
-		Token: refresh_token,
+		TokenHash: cfg.hashToken(refresh_token),
 		UserID: user.ID,
-		ExpiresAt: time.Now().UTC().Add(time.Hour * 24 * 60),
+		ExpiresAt: time.Now().UTC().Add(refreshTokenTTL),
+		FamilyID: uuid.New(),
+		UserAgent: r.UserAgent(),
+		Ip: cfg.clientIP(r),
 	})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error when adding Refresh token to database", err)
@@ -139,37 +277,83 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	respondWithJSON(w, http.StatusOK, response {
-		User: User {
-			ID: user.ID,
-			CreatedAt: user.CreatedAt,
-			UpdatedAt: user.UpdatedAt,
-			Email: user.Email,
-			IsChirpyRed: user.IsChirpyRed,
-		},
+		User: newUser(user),
 		JWTToken: jwt_token,
 		RefreshToken: refresh_token,
 	})
 
 }
 
+// handlerRefreshToken trades a refresh token for a new access token and a
+// new refresh token. The presented token is revoked and linked to its
+// replacement; presenting a revoked token again means it was stolen or
+// replayed, so the whole family is revoked and the user has to log in.
 func (cfg *apiConfig) handlerRefreshToken(w http.ResponseWriter, r *http.Request) {
 	type response struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	token, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't get token", err)
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get token", err)
 		return
 	} 
 
-	user, err := cfg.db.GetUserFromRefreshToken(r.Context(), token)
+	old, err := cfg.db.GetRefreshToken(r.Context(), cfg.hashToken(token))
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't get refresh token", err)
 		return
-	} 
+	}
+	if old.RevokedAt.Valid {
+		cfg.revokeTokenFamily(w, r, old)
+		return
+	}
+	if !old.ExpiresAt.After(time.Now()) {
+		respondWithError(w, http.StatusUnauthorized, "Refresh token is expired", nil)
+		return
+	}
+	// The new access token carries the user's current role, so a change of
+	// role takes effect at the next refresh.
+	user, err := cfg.db.GetUserByID(r.Context(), old.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
 
-	access_token, err := auth.MakeJWT(user.ID, cfg.secret_key, time.Hour)
+	refresh_token, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error when creating Refresh token", err)
+		return
+	}
+	_, err = cfg.db.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+		TokenHash: cfg.hashToken(refresh_token),
+		UserID:    old.UserID,
+		ExpiresAt: time.Now().UTC().Add(refreshTokenTTL),
+		FamilyID:  old.FamilyID,
+		UserAgent: old.UserAgent,
+		Ip:        old.Ip,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error when adding Refresh token to database", err)
+		return
+	}
+
+	rotated, err := cfg.db.RotateRefreshToken(r.Context(), database.RotateRefreshTokenParams{
+		ReplacedBy: sql.NullString{String: cfg.hashToken(refresh_token), Valid: true},
+		TokenHash:  old.TokenHash,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rotate refresh token", err)
+		return
+	}
+	// Another request rotated the token first, so it was used twice.
+	if rotated == 0 {
+		cfg.revokeTokenFamily(w, r, old)
+		return
+	}
+
+	access_token, err := cfg.keyring.MakeJWT(user.ID, auth.Role(user.Role), time.Hour)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't create access token", err)
 		return
@@ -177,16 +361,34 @@ func (cfg *apiConfig) handlerRefreshToken(w http.ResponseWriter, r *http.Request
 
 	respondWithJSON(w, http.StatusOK, response {
 		Token: access_token,
+		RefreshToken: refresh_token,
 	})
 }
 
+// hashToken is the form a refresh or one-time token is stored and looked
+// up in.
+func (cfg *apiConfig) hashToken(token string) string {
+	return auth.HashToken(token, cfg.token_pepper)
+}
+
+// revokeTokenFamily responds to a reused refresh token by revoking every
+// token issued from the same login.
+func (cfg *apiConfig) revokeTokenFamily(w http.ResponseWriter, r *http.Request, reused database.RefreshToken) {
+	err := cfg.db.RevokeRefreshTokenFamily(r.Context(), reused.FamilyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke refresh tokens", err)
+		return
+	}
+	respondWithError(w, http.StatusUnauthorized, "Refresh token was already used", nil)
+}
+
 func (cfg *apiConfig) handlerRevokeToken(w http.ResponseWriter, r *http.Request) {
 	token, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't get token", err)
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get token", err)
 		return
 	} 
-	_, err = cfg.db.RevokeRefreshToken(r.Context(), token)
+	_, err = cfg.db.RevokeRefreshToken(r.Context(), cfg.hashToken(token))
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke token", err)
 		return
@@ -200,6 +402,14 @@ func (cfg *apiConfig) handlerEditUser(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		Email string `json:"email"`
 		Password string `json:"password"`
+		// Handle is optional; the current handle is kept when it is empty.
+		Handle string `json:"handle"`
+		// Changing the password takes the current one, and a code from the
+		// authenticator or a recovery code if TOTP is enabled, so a stolen
+		// access token alone can't take over the account.
+		CurrentPassword string `json:"current_password"`
+		Code string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
 	}
 
 	token, err := auth.GetBearerToken(r.Header)
@@ -208,7 +418,7 @@ func (cfg *apiConfig) handlerEditUser(w http.ResponseWriter, r *http.Request) {
 		return
 	} 
 
-	user_id, err := auth.ValidateJWT(token, cfg.secret_key)
+	user_id, err := cfg.keyring.ValidateJWT(token)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Token is invalid", err)
 		return
@@ -223,7 +433,35 @@ func (cfg *apiConfig) handlerEditUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	new_password, err := auth.HashPassword(params.Password)
+	err = validateEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	var handle sql.NullString
+	if params.Handle != "" {
+		handle.String, err = normalizeNewHandle(params.Handle)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		handle.Valid = true
+	}
+
+	current, err := cfg.db.GetUserByID(r.Context(), user_id)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get user", err)
+		return
+	}
+	// The password is always sent; it only changes if it doesn't match.
+	if _, err := cfg.passwords.Verify(params.Password, current.HashedPassword); err != nil {
+		if !cfg.confirmIdentity(w, r, current, params.CurrentPassword, params.Code, params.RecoveryCode, true) {
+			return
+		}
+	}
+
+	new_password, err := cfg.passwords.Hash(params.Password)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
 		return
@@ -233,19 +471,25 @@ func (cfg *apiConfig) handlerEditUser(w http.ResponseWriter, r *http.Request) {
 		ID: user_id,
 		Email: params.Email,
 		HashedPassword: new_password,
+		Handle: handle,
 	})
 
+	if database.IsUniqueViolation(err) {
+		respondWithError(w, http.StatusConflict, "Email or handle is already taken", err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
 		return
 	}
+	// A changed email address is unverified until the new one is confirmed.
+	if !user.EmailVerified {
+		err = cfg.sendVerificationEmail(r.Context(), user)
+		if err != nil {
+			log.Printf("Couldn't send verification email to %s: %v", user.Email, err)
+		}
+	}
 
-	respondWithJSON(w, http.StatusOK, User {
-		ID: user.ID,
-		CreatedAt: user.CreatedAt,
-		UpdatedAt: user.UpdatedAt,
-		Email: user.Email,
-		IsChirpyRed: user.IsChirpyRed,
-	})
+	respondWithJSON(w, http.StatusOK, newUser(user))
 
 }
\ No newline at end of file
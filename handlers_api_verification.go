@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"time"
+
+	"github.com/YaguarEgor/chirpy_server/internal/auth"
+	"github.com/YaguarEgor/chirpy_server/internal/database"
+	chirpymail "github.com/YaguarEgor/chirpy_server/internal/mail"
+	"github.com/google/uuid"
+)
+
+const emailVerificationTTL = 24 * time.Hour
+
+// validateEmail accepts a bare address such as "user@example.com", without
+// a display name or angle brackets.
+func validateEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return errors.New("Invalid email address")
+	}
+	return nil
+}
+
+// sendVerificationEmail mails the user a one-time token for their current
+// address. Only the token's hash is stored.
+func (cfg *apiConfig) sendVerificationEmail(ctx context.Context, user database.User) error {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return err
+	}
+	err = cfg.db.CreateEmailVerificationToken(ctx, database.CreateEmailVerificationTokenParams{
+		TokenHash: cfg.hashToken(token),
+		UserID:    user.ID,
+		Email:     user.Email,
+		ExpiresAt: time.Now().UTC().Add(emailVerificationTTL),
+	})
+	if err != nil {
+		return err
+	}
+	return cfg.mailer.Send(ctx, chirpymail.Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy email address",
+		Body: fmt.Sprintf("Confirm this address by sending the token below to POST /api/users/verify.\n\n%s\n\nThe token expires in %s.",
+			token, emailVerificationTTL),
+	})
+}
+
+// requireVerifiedEmail responds with 403 and returns false when the user
+// hasn't verified their email address yet.
+func (cfg *apiConfig) requireVerifiedEmail(w http.ResponseWriter, r *http.Request, user_id uuid.UUID) bool {
+	user, err := cfg.db.GetUserByID(r.Context(), user_id)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User is not found", err)
+		return false
+	}
+	if !user.EmailVerified {
+		respondWithError(w, http.StatusForbidden, "Email address is not verified", nil)
+		return false
+	}
+	return true
+}
+
+func (cfg *apiConfig) handlerVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode request", err)
+		return
+	}
+
+	verification, err := cfg.db.UseEmailVerificationToken(r.Context(), cfg.hashToken(params.Token))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusBadRequest, "Token is invalid or expired", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check token", err)
+		return
+	}
+
+	user, err := cfg.db.VerifyEmail(r.Context(), database.VerifyEmailParams{
+		ID:    verification.UserID,
+		Email: verification.Email,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusBadRequest, "Email address has changed since the token was sent", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, newUser(user))
+}
+
+// handlerResendVerification sends a fresh token, for when the first email
+// never arrived or its token expired.
+func (cfg *apiConfig) handlerResendVerification(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	user_id, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT token", err)
+		return
+	}
+	user, err := cfg.db.GetUserByID(r.Context(), user_id)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User is not found", err)
+		return
+	}
+	if user.EmailVerified {
+		respondWithError(w, http.StatusConflict, "Email address is already verified", nil)
+		return
+	}
+
+	err = cfg.sendVerificationEmail(r.Context(), user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send verification email", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
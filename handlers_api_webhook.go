@@ -1,47 +1,131 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/YaguarEgor/chirpy_server/internal/auth"
+	"github.com/YaguarEgor/chirpy_server/internal/database"
 	"github.com/google/uuid"
 )
 
+// paymentGracePeriod is how long a subscriber keeps Chirpy Red after Polka
+// reports a failed payment, to give the retries a chance.
+const paymentGracePeriod = 7 * 24 * time.Hour
+
+// handlerWebhook keeps Chirpy Red in step with the subscription at Polka:
+//
+//   - user.upgraded starts or renews it, until data.expires_at when set.
+//   - user.downgraded ends it at once.
+//   - user.payment_failed starts a grace period; Chirpy Red expires at the
+//     end of it unless a renewal comes first.
+//
+// Polka may deliver events late or out of order, so each is applied only if
+// it happened no earlier than the last one applied, going by
+// data.occurred_at, or by when it arrived if Polka leaves that out. Other
+// events, and events that don't apply, are acknowledged and ignored.
 func (cfg *apiConfig) handlerWebhook(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	type parameters struct {
 		Event string `json:"event"`
 		Data  struct {
-			UserID uuid.UUID `json:"user_id"`
+			UserID     uuid.UUID  `json:"user_id"`
+			ExpiresAt  *time.Time `json:"expires_at"`
+			OccurredAt *time.Time `json:"occurred_at"`
 		} `json:"data"`
 	}
 
-	var params parameters
-	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&params)
+	key, err := auth.GetAPIKey(r.Header)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't decode request", err)
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get API key", err)
 		return
 	}
-	if params.Event != "user.upgraded" {
-		respondWithJSON(w, http.StatusNoContent, nil)
+	if key != cfg.polka_key {
+		respondWithError(w, http.StatusUnauthorized, "API key is invalid", err)
 		return
 	}
-	key, err := auth.GetAPIKey(r.Header)
+	var params parameters
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't get API key", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode request", err)
 		return
 	}
-	if key != cfg.polka_key {
-		respondWithError(w, http.StatusUnauthorized, "API key is invalid", err)
+
+	occurred_at := time.Now().UTC()
+	if params.Data.OccurredAt != nil {
+		occurred_at = params.Data.OccurredAt.UTC()
+	}
+
+	var updated int64
+	switch params.Event {
+	case "user.upgraded":
+		var expires_at sql.NullTime
+		if params.Data.ExpiresAt != nil {
+			expires_at = sql.NullTime{Time: params.Data.ExpiresAt.UTC(), Valid: true}
+		}
+		updated, err = cfg.db.UpdateSubscription(r.Context(), database.UpdateSubscriptionParams{
+			ID:                 params.Data.UserID,
+			ChirpyRedExpiresAt: expires_at,
+			EventAt:            occurred_at,
+		})
+	case "user.downgraded":
+		updated, err = cfg.db.CancelSubscription(r.Context(), database.CancelSubscriptionParams{
+			EventAt: occurred_at,
+			ID:      params.Data.UserID,
+		})
+	case "user.payment_failed":
+		updated, err = cfg.db.RecordPaymentFailure(r.Context(), database.RecordPaymentFailureParams{
+			GraceUntil: time.Now().UTC().Add(paymentGracePeriod),
+			EventAt:    occurred_at,
+			ID:         params.Data.UserID,
+		})
+	default:
+		respondWithJSON(w, http.StatusNoContent, nil)
 		return
 	}
-	err = cfg.db.UpdateSubscription(r.Context(), params.Data.UserID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't update user", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
 		return
 	}
+	if updated == 0 {
+		// Either there is no such user, or the event is stale or is a
+		// payment failure for a user without Chirpy Red.
+		_, err := cfg.db.GetUserByID(r.Context(), params.Data.UserID)
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "User is not found", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+			return
+		}
+	}
 	respondWithJSON(w, http.StatusNoContent, nil)
 }
+
+// expireSubscriptions takes Chirpy Red away from users whose subscription
+// or grace period has run out, every interval until ctx is done.
+func (cfg *apiConfig) expireSubscriptions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expired, err := cfg.db.ExpireSubscriptions(ctx, time.Now().UTC())
+			if err != nil {
+				log.Printf("error when expiring subscriptions: %v", err)
+			} else if expired > 0 {
+				log.Printf("expired %d Chirpy Red subscriptions", expired)
+			}
+		}
+	}
+}
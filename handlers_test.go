@@ -0,0 +1,2701 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/YaguarEgor/chirpy_server/internal/auth"
+	"github.com/YaguarEgor/chirpy_server/internal/database"
+	"github.com/YaguarEgor/chirpy_server/internal/lockout"
+	"github.com/YaguarEgor/chirpy_server/internal/mail"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	testSecret      = "test-secret"
+	testPolkaKey    = "test-polka-key"
+	testTokenPepper = "test-token-pepper"
+)
+
+// newTestServer starts the real mux against an in-memory store. Access
+// tokens are signed with a fresh Ed25519 key; tokens signed with testSecret
+// are still accepted for an hour, as they are while migrating off HMAC.
+func newTestServer(t *testing.T) (*apiConfig, *httptest.Server) {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating signing key: %v", err)
+	}
+	key, err := auth.NewSigningKey(private)
+	if err != nil {
+		t.Fatalf("creating signing key: %v", err)
+	}
+	mailer := &recordingMailer{}
+	lockouts := lockout.NewMemoryStore()
+	cfg := &apiConfig{
+		db:              database.NewMemoryStore(),
+		keyring:         auth.NewKeyring(key, auth.NewHMACKey(testSecret).AcceptedUntil(time.Now().Add(time.Hour))),
+		platform:        "dev",
+		polka_key:       testPolkaKey,
+		token_pepper:    testTokenPepper,
+		mailer:          mailer,
+		passwords:       auth.DefaultPasswordHasher(),
+		account_lockout: lockout.NewLimiter(lockouts, accountLockoutPolicy),
+		ip_lockout:      lockout.NewLimiter(lockouts, ipLockoutPolicy),
+		reset_lockout:   lockout.NewLimiter(lockouts, passwordResetPolicy),
+	}
+	cfg.word_filter, err = newWordFilter(context.Background(), cfg.db, "")
+	if err != nil {
+		t.Fatalf("loading banned words: %v", err)
+	}
+	cfg.pipeline, err = newChirpPipeline(cfg, "")
+	if err != nil {
+		t.Fatalf("building chirp pipeline: %v", err)
+	}
+	srv := httptest.NewServer(newServeMux(cfg, "."))
+	testMailers.Store(srv, mailer)
+	t.Cleanup(func() {
+		srv.Close()
+		testMailers.Delete(srv)
+	})
+	return cfg, srv
+}
+
+// recordingMailer keeps every message so tests can read the tokens in them.
+type recordingMailer struct {
+	mu       sync.Mutex
+	messages []mail.Message
+}
+
+func (m *recordingMailer) Send(ctx context.Context, msg mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// testMailers maps each test server to its recordingMailer.
+var testMailers sync.Map
+
+var mailTokenPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// mailedToken returns the token in the latest message sent to email.
+func mailedToken(t *testing.T, srv *httptest.Server, email string) string {
+	t.Helper()
+	v, _ := testMailers.Load(srv)
+	mailer := v.(*recordingMailer)
+	mailer.mu.Lock()
+	defer mailer.mu.Unlock()
+	for i := len(mailer.messages) - 1; i >= 0; i-- {
+		if msg := mailer.messages[i]; msg.To == email {
+			if token := mailTokenPattern.FindString(msg.Body); token != "" {
+				return token
+			}
+		}
+	}
+	t.Fatalf("no token was mailed to %s", email)
+	return ""
+}
+
+// doRequest sends body (JSON-encoded unless it is already a string) and
+// returns the status code and raw response body. authorization is used as
+// the Authorization header when non-empty.
+func doRequest(t *testing.T, srv *httptest.Server, method, path, authorization string, body any) (int, []byte) {
+	t.Helper()
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(b)
+	default:
+		dat, err := json.Marshal(b)
+		if err != nil {
+			t.Fatalf("marshalling request body: %v", err)
+		}
+		reader = bytes.NewReader(dat)
+	}
+	req, err := http.NewRequest(method, srv.URL+path, reader)
+	if err != nil {
+		t.Fatalf("building request: %v", err)
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	dat, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading response body: %v", err)
+	}
+	return resp.StatusCode, dat
+}
+
+func decodeJSON[T any](t *testing.T, dat []byte) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(dat, &v); err != nil {
+		t.Fatalf("decoding %q: %v", dat, err)
+	}
+	return v
+}
+
+type loginResponse struct {
+	User
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// createUser signs up, verifies and logs in a user, failing the test on
+// any error. The handle is the local part of the email address.
+func createUser(t *testing.T, srv *httptest.Server, email, password string) loginResponse {
+	t.Helper()
+	handle, _, _ := strings.Cut(email, "@")
+	params := map[string]string{"email": email, "password": password, "handle": handle}
+	if code, dat := doRequest(t, srv, "POST", "/api/users", "", params); code != http.StatusCreated {
+		t.Fatalf("creating user %s: status %d: %s", email, code, dat)
+	}
+	verify := map[string]string{"token": mailedToken(t, srv, email)}
+	if code, dat := doRequest(t, srv, "POST", "/api/users/verify", "", verify); code != http.StatusOK {
+		t.Fatalf("verifying %s: status %d: %s", email, code, dat)
+	}
+	code, dat := doRequest(t, srv, "POST", "/api/login", "", params)
+	if code != http.StatusOK {
+		t.Fatalf("logging in %s: status %d: %s", email, code, dat)
+	}
+	return decodeJSON[loginResponse](t, dat)
+}
+
+func createChirp(t *testing.T, srv *httptest.Server, token, body string) Chirp {
+	t.Helper()
+	code, dat := doRequest(t, srv, "POST", "/api/chirps", "Bearer "+token, map[string]string{"body": body})
+	if code != http.StatusCreated {
+		t.Fatalf("creating chirp: status %d: %s", code, dat)
+	}
+	return decodeJSON[Chirp](t, dat)
+}
+
+func TestHandlerReadiness(t *testing.T) {
+	_, srv := newTestServer(t)
+	code, dat := doRequest(t, srv, "GET", "/api/healthz", "", nil)
+	if code != http.StatusOK || string(dat) != "OK" {
+		t.Errorf("GET /api/healthz = %d %q, want 200 \"OK\"", code, dat)
+	}
+}
+
+func TestHandlerCreateUser(t *testing.T) {
+	_, srv := newTestServer(t)
+	createUser(t, srv, "taken@example.com", "password")
+
+	tests := []struct {
+		name     string
+		body     any
+		wantCode int
+	}{
+		{
+			name:     "Valid user",
+			body:     map[string]string{"email": "new@example.com", "password": "password", "handle": "New_User"},
+			wantCode: http.StatusCreated,
+		},
+		{
+			name:     "Duplicate email",
+			body:     map[string]string{"email": "taken@example.com", "password": "password", "handle": "someone"},
+			wantCode: http.StatusConflict,
+		},
+		{
+			name:     "Duplicate handle",
+			body:     map[string]string{"email": "other@example.com", "password": "password", "handle": "TAKEN"},
+			wantCode: http.StatusConflict,
+		},
+		{
+			name:     "Invalid email",
+			body:     map[string]string{"email": "Other <other@example.com>", "password": "password", "handle": "other"},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Missing handle",
+			body:     map[string]string{"email": "other@example.com", "password": "password"},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Invalid handle",
+			body:     map[string]string{"email": "other@example.com", "password": "password", "handle": "no spaces"},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Reserved handle",
+			body:     map[string]string{"email": "other@example.com", "password": "password", "handle": "Admin"},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Malformed JSON",
+			body:     "{",
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, dat := doRequest(t, srv, "POST", "/api/users", "", tt.body)
+			if code != tt.wantCode {
+				t.Fatalf("POST /api/users = %d, want %d: %s", code, tt.wantCode, dat)
+			}
+			if code != http.StatusCreated {
+				return
+			}
+			user := decodeJSON[User](t, dat)
+			if user.Email != "new@example.com" || user.Handle != "new_user" || user.IsChirpyRed {
+				t.Errorf("POST /api/users returned %+v", user)
+			}
+		})
+	}
+}
+
+func TestHandlerLogin(t *testing.T) {
+	_, srv := newTestServer(t)
+	createUser(t, srv, "user@example.com", "password")
+
+	tests := []struct {
+		name     string
+		body     any
+		wantCode int
+	}{
+		{
+			name:     "Correct password",
+			body:     map[string]string{"email": "user@example.com", "password": "password"},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Wrong password",
+			body:     map[string]string{"email": "user@example.com", "password": "wrong"},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Unknown email",
+			body:     map[string]string{"email": "nobody@example.com", "password": "password"},
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, dat := doRequest(t, srv, "POST", "/api/login", "", tt.body)
+			if code != tt.wantCode {
+				t.Fatalf("POST /api/login = %d, want %d: %s", code, tt.wantCode, dat)
+			}
+			if code != http.StatusOK {
+				return
+			}
+			resp := decodeJSON[loginResponse](t, dat)
+			if resp.Token == "" || resp.RefreshToken == "" {
+				t.Errorf("POST /api/login returned empty tokens: %s", dat)
+			}
+		})
+	}
+}
+
+func TestHandlerRefreshAndRevoke(t *testing.T) {
+	_, srv := newTestServer(t)
+	user := createUser(t, srv, "user@example.com", "password")
+
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		authorization string
+		wantCode      int
+	}{
+		{name: "Refresh without token", method: "POST", path: "/api/refresh", wantCode: http.StatusUnauthorized},
+		{name: "Refresh with unknown token", method: "POST", path: "/api/refresh", authorization: "Bearer unknown", wantCode: http.StatusUnauthorized},
+		{name: "Refresh with valid token", method: "POST", path: "/api/refresh", authorization: "Bearer " + user.RefreshToken, wantCode: http.StatusOK},
+		{name: "Revoke without token", method: "POST", path: "/api/revoke", wantCode: http.StatusUnauthorized},
+		{name: "Revoke valid token", method: "POST", path: "/api/revoke", authorization: "Bearer " + user.RefreshToken, wantCode: http.StatusNoContent},
+		{name: "Refresh with revoked token", method: "POST", path: "/api/refresh", authorization: "Bearer " + user.RefreshToken, wantCode: http.StatusUnauthorized},
+	}
+
+	// The cases run in order: the revoke case changes what the last one sees.
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, dat := doRequest(t, srv, tt.method, tt.path, tt.authorization, nil)
+			if code != tt.wantCode {
+				t.Fatalf("%s %s = %d, want %d: %s", tt.method, tt.path, code, tt.wantCode, dat)
+			}
+			if tt.path == "/api/refresh" && code == http.StatusOK {
+				resp := decodeJSON[struct {
+					Token string `json:"token"`
+				}](t, dat)
+				if resp.Token == "" {
+					t.Errorf("POST /api/refresh returned an empty token")
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerRefreshRotation(t *testing.T) {
+	_, srv := newTestServer(t)
+	user := createUser(t, srv, "user@example.com", "password")
+	code, dat := doRequest(t, srv, "POST", "/api/login", "", map[string]string{"email": "user@example.com", "password": "password"})
+	if code != http.StatusOK {
+		t.Fatalf("second login = %d: %s", code, dat)
+	}
+	otherSession := decodeJSON[loginResponse](t, dat).RefreshToken
+
+	type refreshResponse struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	refresh := func(t *testing.T, token string) (int, refreshResponse) {
+		t.Helper()
+		code, dat := doRequest(t, srv, "POST", "/api/refresh", "Bearer "+token, nil)
+		if code != http.StatusOK {
+			return code, refreshResponse{}
+		}
+		return code, decodeJSON[refreshResponse](t, dat)
+	}
+
+	code, rotated := refresh(t, user.RefreshToken)
+	if code != http.StatusOK || rotated.Token == "" || rotated.RefreshToken == "" || rotated.RefreshToken == user.RefreshToken {
+		t.Fatalf("first refresh = %d %+v, want a new token pair", code, rotated)
+	}
+	code, next := refresh(t, rotated.RefreshToken)
+	if code != http.StatusOK {
+		t.Fatalf("refreshing with the rotated token = %d, want %d", code, http.StatusOK)
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		wantCode int
+	}{
+		{name: "Reused token", token: user.RefreshToken, wantCode: http.StatusUnauthorized},
+		{name: "Latest token of the reused family", token: next.RefreshToken, wantCode: http.StatusUnauthorized},
+		{name: "Token from another login", token: otherSession, wantCode: http.StatusOK},
+	}
+
+	// The cases run in order: the reuse revokes the family for the next one.
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, _ := refresh(t, tt.token); code != tt.wantCode {
+				t.Errorf("POST /api/refresh = %d, want %d", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestRefreshTokensStoredHashed(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	user := createUser(t, srv, "user@example.com", "password")
+
+	if _, err := cfg.db.GetRefreshToken(context.Background(), user.RefreshToken); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("looking up the raw token error = %v, want %v", err, sql.ErrNoRows)
+	}
+	rt, err := cfg.db.GetRefreshToken(context.Background(), auth.HashToken(user.RefreshToken, testTokenPepper))
+	if err != nil {
+		t.Fatalf("looking up the hashed token error = %v", err)
+	}
+	if rt.UserID != user.ID {
+		t.Errorf("stored token belongs to %v, want %v", rt.UserID, user.ID)
+	}
+}
+
+func TestHandlerEditUser(t *testing.T) {
+	_, srv := newTestServer(t)
+	user := createUser(t, srv, "user@example.com", "password")
+	createUser(t, srv, "taken@example.com", "password")
+
+	tests := []struct {
+		name          string
+		authorization string
+		body          any
+		wantCode      int
+	}{
+		{
+			name:     "Missing token",
+			body:     map[string]string{"email": "new@example.com", "password": "new"},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:          "Invalid token",
+			authorization: "Bearer invalid",
+			body:          map[string]string{"email": "new@example.com", "password": "new"},
+			wantCode:      http.StatusUnauthorized,
+		},
+		{
+			name:          "Email already taken",
+			authorization: "Bearer " + user.Token,
+			body:          map[string]string{"email": "taken@example.com", "password": "new", "current_password": "password"},
+			wantCode:      http.StatusConflict,
+		},
+		{
+			name:          "Handle already taken",
+			authorization: "Bearer " + user.Token,
+			body:          map[string]string{"email": "new@example.com", "password": "new", "current_password": "password", "handle": "taken"},
+			wantCode:      http.StatusConflict,
+		},
+		{
+			name:          "Invalid handle",
+			authorization: "Bearer " + user.Token,
+			body:          map[string]string{"email": "new@example.com", "password": "new", "handle": "x"},
+			wantCode:      http.StatusBadRequest,
+		},
+		{
+			name:          "New password without the current one",
+			authorization: "Bearer " + user.Token,
+			body:          map[string]string{"email": "user@example.com", "password": "new"},
+			wantCode:      http.StatusUnauthorized,
+		},
+		{
+			name:          "Wrong current password",
+			authorization: "Bearer " + user.Token,
+			body:          map[string]string{"email": "user@example.com", "password": "new", "current_password": "wrong"},
+			wantCode:      http.StatusUnauthorized,
+		},
+		{
+			name:          "Same password without the current one",
+			authorization: "Bearer " + user.Token,
+			body:          map[string]string{"email": "user@example.com", "password": "password"},
+			wantCode:      http.StatusOK,
+		},
+		{
+			name:          "Valid update",
+			authorization: "Bearer " + user.Token,
+			body:          map[string]string{"email": "new@example.com", "password": "new", "current_password": "password"},
+			wantCode:      http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, dat := doRequest(t, srv, "PUT", "/api/users", tt.authorization, tt.body)
+			if code != tt.wantCode {
+				t.Fatalf("PUT /api/users = %d, want %d: %s", code, tt.wantCode, dat)
+			}
+		})
+	}
+
+	login := map[string]string{"email": "new@example.com", "password": "new"}
+	code, dat := doRequest(t, srv, "POST", "/api/login", "", login)
+	if code != http.StatusOK {
+		t.Fatalf("logging in with updated credentials = %d: %s", code, dat)
+	}
+	if got := decodeJSON[loginResponse](t, dat); got.Handle != "user" {
+		t.Errorf("handle after an update without one = %q, want %q", got.Handle, "user")
+	}
+}
+
+func TestHandlerChirps(t *testing.T) {
+	_, srv := newTestServer(t)
+	user := createUser(t, srv, "user@example.com", "password")
+
+	tests := []struct {
+		name          string
+		authorization string
+		body          any
+		wantCode      int
+		wantBody      string
+	}{
+		{
+			name:          "Valid chirp",
+			authorization: "Bearer " + user.Token,
+			body:          map[string]string{"body": "Hello, world!"},
+			wantCode:      http.StatusCreated,
+			wantBody:      "Hello, world!",
+		},
+		{
+			name:          "Profanity is masked",
+			authorization: "Bearer " + user.Token,
+			body:          map[string]string{"body": "What a Kerfuffle it was"},
+			wantCode:      http.StatusCreated,
+			wantBody:      "What a **** it was",
+		},
+		{
+			name:          "Chirp is too long",
+			authorization: "Bearer " + user.Token,
+			body:          map[string]string{"body": strings.Repeat("a", 141)},
+			wantCode:      http.StatusBadRequest,
+		},
+		{
+			name:     "Missing token",
+			body:     map[string]string{"body": "Hello"},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:          "Invalid token",
+			authorization: "Bearer invalid",
+			body:          map[string]string{"body": "Hello"},
+			wantCode:      http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, dat := doRequest(t, srv, "POST", "/api/chirps", tt.authorization, tt.body)
+			if code != tt.wantCode {
+				t.Fatalf("POST /api/chirps = %d, want %d: %s", code, tt.wantCode, dat)
+			}
+			if code != http.StatusCreated {
+				return
+			}
+			chirp := decodeJSON[Chirp](t, dat)
+			if chirp.Body != tt.wantBody || chirp.UserID != user.ID {
+				t.Errorf("POST /api/chirps returned %+v, want body %q by %v", chirp, tt.wantBody, user.ID)
+			}
+		})
+	}
+}
+
+func TestHandlerChirpLength(t *testing.T) {
+	_, srv := newTestServer(t)
+	alice := createUser(t, srv, "alice@example.com", "password")
+
+	accepted := []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "140 Cyrillic letters", body: strings.Repeat("ж", 140), want: strings.Repeat("ж", 140)},
+		{name: "140 emoji", body: strings.Repeat("\U0001f44d\U0001f3fd", 140), want: strings.Repeat("\U0001f44d\U0001f3fd", 140)},
+		{name: "Long link", body: strings.Repeat("a", 110) + " https://example.com/" + strings.Repeat("x", 100), want: strings.Repeat("a", 110) + " https://example.com/" + strings.Repeat("x", 100)},
+		{name: "Normalized", body: "cafe\u0301 \u202eevil\u202c\x00", want: "caf\u00e9 evil"},
+	}
+	for _, tt := range accepted {
+		t.Run(tt.name, func(t *testing.T) {
+			if chirp := createChirp(t, srv, alice.Token, tt.body); chirp.Body != tt.want {
+				t.Errorf("body = %q, want %q", chirp.Body, tt.want)
+			}
+		})
+	}
+
+	code, dat := doRequest(t, srv, "POST", "/api/chirps", "Bearer "+alice.Token, map[string]string{"body": strings.Repeat("ж", 150)})
+	if code != http.StatusBadRequest {
+		t.Fatalf("POST /api/chirps with 150 characters = %d, want %d: %s", code, http.StatusBadRequest, dat)
+	}
+	type lengthError struct {
+		Error string `json:"error"`
+		Limit int    `json:"limit"`
+		Used  int    `json:"used"`
+	}
+	want := lengthError{Error: "Chirp is too long: 150 characters used, 140 allowed", Limit: 140, Used: 150}
+	if got := decodeJSON[lengthError](t, dat); got != want {
+		t.Errorf("error = %+v, want %+v", got, want)
+	}
+}
+
+func TestHandlerLimits(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	alice := createUser(t, srv, "alice@example.com", "password")
+	bob := createUser(t, srv, "bob@example.com", "password")
+
+	type limitsResponse struct {
+		Limits
+		ChirpsThisHour int `json:"chirps_this_hour"`
+	}
+	getLimits := func(t *testing.T, token string) limitsResponse {
+		t.Helper()
+		code, dat := doRequest(t, srv, "GET", "/api/limits", "Bearer "+token, nil)
+		if code != http.StatusOK {
+			t.Fatalf("GET /api/limits = %d: %s", code, dat)
+		}
+		return decodeJSON[limitsResponse](t, dat)
+	}
+
+	if code, _ := doRequest(t, srv, "GET", "/api/limits", "", nil); code != http.StatusUnauthorized {
+		t.Errorf("GET /api/limits without a token = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if got := getLimits(t, alice.Token); got.Limits != tierLimits[TierFree] || got.ChirpsThisHour != 0 {
+		t.Errorf("free limits = %+v", got)
+	}
+
+	// A free user gets 140 characters, Chirpy Red twice that.
+	long := map[string]string{"body": strings.Repeat("a", 280)}
+	if code, dat := doRequest(t, srv, "POST", "/api/chirps", "Bearer "+alice.Token, long); code != http.StatusBadRequest {
+		t.Errorf("280 characters as a free user = %d, want %d: %s", code, http.StatusBadRequest, dat)
+	}
+	if _, err := cfg.db.UpdateSubscription(context.Background(), database.UpdateSubscriptionParams{ID: alice.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if code, dat := doRequest(t, srv, "POST", "/api/chirps", "Bearer "+alice.Token, long); code != http.StatusCreated {
+		t.Errorf("280 characters as Chirpy Red = %d, want %d: %s", code, http.StatusCreated, dat)
+	}
+	if got := getLimits(t, alice.Token); got.Limits != tierLimits[TierChirpyRed] || got.ChirpsThisHour != 1 {
+		t.Errorf("Chirpy Red limits = %+v", got)
+	}
+
+	// Once bob has used up the hour, chirps, quotes and rechirps are refused.
+	first := createChirp(t, srv, bob.Token, "chirp 0")
+	var last Chirp
+	for i := 1; i < tierLimits[TierFree].ChirpsPerHour; i++ {
+		last = createChirp(t, srv, bob.Token, fmt.Sprintf("chirp %d", i))
+	}
+	if got := getLimits(t, bob.Token).ChirpsThisHour; got != 30 {
+		t.Errorf("chirps_this_hour = %d, want 30", got)
+	}
+	dat, err := json.Marshal(map[string]string{"body": "one too many"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", srv.URL+"/api/chirps", bytes.NewReader(dat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+bob.Token)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("POST /api/chirps over the rate = %d, want %d", resp.StatusCode, http.StatusTooManyRequests)
+	}
+	if retry, err := strconv.Atoi(resp.Header.Get("Retry-After")); err != nil || retry < 3590 || retry > 3600 {
+		t.Errorf("Retry-After = %q, want about an hour", resp.Header.Get("Retry-After"))
+	}
+	path := "/api/chirps/" + first.ID.String() + "/rechirps"
+	if code, _ := doRequest(t, srv, "POST", path, "Bearer "+bob.Token, nil); code != http.StatusTooManyRequests {
+		t.Errorf("POST %s over the rate = %d, want %d", path, code, http.StatusTooManyRequests)
+	}
+	// Deleting a chirp doesn't give its place back.
+	if code, _ := doRequest(t, srv, "DELETE", "/api/chirps/"+last.ID.String(), "Bearer "+bob.Token, nil); code != http.StatusNoContent {
+		t.Fatalf("DELETE /api/chirps/%s = %d, want %d", last.ID, code, http.StatusNoContent)
+	}
+	if code, _ := doRequest(t, srv, "POST", "/api/chirps", "Bearer "+bob.Token, map[string]string{"body": "after a delete"}); code != http.StatusTooManyRequests {
+		t.Errorf("POST /api/chirps after a delete = %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if got := getLimits(t, bob.Token).ChirpsThisHour; got != 30 {
+		t.Errorf("chirps_this_hour after a delete = %d, want 30", got)
+	}
+	// Every user has a quota of their own.
+	createChirp(t, srv, alice.Token, "still here")
+}
+
+func TestHandlerGetChirps(t *testing.T) {
+	_, srv := newTestServer(t)
+	alice := createUser(t, srv, "alice@example.com", "password")
+	bob := createUser(t, srv, "bob@example.com", "password")
+	first := createChirp(t, srv, alice.Token, "first")
+	second := createChirp(t, srv, bob.Token, "second")
+	third := createChirp(t, srv, alice.Token, "third")
+
+	tests := []struct {
+		name     string
+		query    string
+		wantCode int
+		wantIDs  []Chirp
+	}{
+		{name: "All chirps", query: "", wantCode: http.StatusOK, wantIDs: []Chirp{first, second, third}},
+		{name: "Descending", query: "?sort=desc", wantCode: http.StatusOK, wantIDs: []Chirp{third, second, first}},
+		{name: "By author", query: "?author_id=" + alice.ID.String(), wantCode: http.StatusOK, wantIDs: []Chirp{first, third}},
+		{name: "By author descending", query: "?author_id=" + alice.ID.String() + "&sort=desc", wantCode: http.StatusOK, wantIDs: []Chirp{third, first}},
+		{name: "Invalid author", query: "?author_id=not-a-uuid", wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, dat := doRequest(t, srv, "GET", "/api/chirps"+tt.query, "", nil)
+			if code != tt.wantCode {
+				t.Fatalf("GET /api/chirps%s = %d, want %d: %s", tt.query, code, tt.wantCode, dat)
+			}
+			if code != http.StatusOK {
+				return
+			}
+			got := decodeJSON[[]Chirp](t, dat)
+			if len(got) != len(tt.wantIDs) {
+				t.Fatalf("GET /api/chirps%s returned %d chirps, want %d", tt.query, len(got), len(tt.wantIDs))
+			}
+			for i := range got {
+				if got[i].ID != tt.wantIDs[i].ID {
+					t.Errorf("chirp %d = %q, want %q", i, got[i].Body, tt.wantIDs[i].Body)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerGetChirpsPagination(t *testing.T) {
+	_, srv := newTestServer(t)
+	user := createUser(t, srv, "user@example.com", "password")
+	var created []Chirp
+	for i := range 5 {
+		created = append(created, createChirp(t, srv, user.Token, fmt.Sprintf("chirp %d", i)))
+	}
+	reversed := slices.Clone(created)
+	slices.Reverse(reversed)
+
+	tests := []struct {
+		name  string
+		query string
+		want  []Chirp
+	}{
+		{name: "Ascending", query: "?limit=2", want: created},
+		{name: "Descending", query: "?limit=2&sort=desc", want: reversed},
+		{name: "By author", query: "?limit=3&author_id=" + user.ID.String(), want: created},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []Chirp
+			next := "/api/chirps" + tt.query
+			for pages := 0; next != ""; pages++ {
+				if pages > len(tt.want) {
+					t.Fatalf("pagination did not terminate")
+				}
+				resp, err := srv.Client().Get(srv.URL + next)
+				if err != nil {
+					t.Fatalf("GET %s: %v", next, err)
+				}
+				dat, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					t.Fatalf("GET %s = %d: %s", next, resp.StatusCode, dat)
+				}
+				got = append(got, decodeJSON[[]Chirp](t, dat)...)
+
+				next = ""
+				if link := resp.Header.Get("Link"); link != "" {
+					next = strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("paged through %d chirps, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i].ID != tt.want[i].ID {
+					t.Errorf("chirp %d = %q, want %q", i, got[i].Body, tt.want[i].Body)
+				}
+			}
+		})
+	}
+
+	for _, query := range []string{"?cursor=not-a-cursor", "?limit=0", "?limit=abc"} {
+		if code, dat := doRequest(t, srv, "GET", "/api/chirps"+query, "", nil); code != http.StatusBadRequest {
+			t.Errorf("GET /api/chirps%s = %d, want %d: %s", query, code, http.StatusBadRequest, dat)
+		}
+	}
+}
+
+func TestHandlerSearchChirps(t *testing.T) {
+	_, srv := newTestServer(t)
+	alice := createUser(t, srv, "alice@example.com", "password")
+	bob := createUser(t, srv, "bob@example.com", "password")
+	createChirp(t, srv, alice.Token, "Gophers love Go")
+	createChirp(t, srv, bob.Token, "I love <b>Go</b> and Rust")
+	createChirp(t, srv, alice.Token, "Nothing to see here")
+
+	tests := []struct {
+		name          string
+		query         string
+		wantCode      int
+		wantHeadlines []string
+	}{
+		{
+			name:          "Ranked by relevance",
+			query:         "?q=go",
+			wantCode:      http.StatusOK,
+			wantHeadlines: []string{"Gophers love <mark>Go</mark>", "I love &lt;b&gt;<mark>Go</mark>&lt;/b&gt; and Rust"},
+		},
+		{
+			name:          "Every term must match",
+			query:         "?q=love+rust",
+			wantCode:      http.StatusOK,
+			wantHeadlines: []string{"I <mark>love</mark> &lt;b&gt;Go&lt;/b&gt; and <mark>Rust</mark>"},
+		},
+		{
+			name:          "Filtered by author",
+			query:         "?q=love&author_id=" + alice.ID.String(),
+			wantCode:      http.StatusOK,
+			wantHeadlines: []string{"Gophers <mark>love</mark> Go"},
+		},
+		{name: "No matches", query: "?q=python", wantCode: http.StatusOK, wantHeadlines: []string{}},
+		{name: "Empty query", query: "?q=", wantCode: http.StatusBadRequest},
+		{name: "Invalid author", query: "?q=go&author_id=nope", wantCode: http.StatusBadRequest},
+		{name: "Invalid cursor", query: "?q=go&cursor=nope", wantCode: http.StatusBadRequest},
+		{name: "Negative offset", query: "?q=go&cursor=" + encodeCursor(searchCursor{Offset: -1}), wantCode: http.StatusBadRequest},
+		{name: "Offset past int32", query: "?q=go&cursor=" + encodeCursor(searchCursor{Offset: math.MaxInt32 + 1}), wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, dat := doRequest(t, srv, "GET", "/api/chirps/search"+tt.query, "", nil)
+			if code != tt.wantCode {
+				t.Fatalf("GET /api/chirps/search%s = %d, want %d: %s", tt.query, code, tt.wantCode, dat)
+			}
+			if code != http.StatusOK {
+				return
+			}
+			got := []string{}
+			for _, result := range decodeJSON[[]ChirpSearchResult](t, dat) {
+				got = append(got, result.Headline)
+			}
+			if !slices.Equal(got, tt.wantHeadlines) {
+				t.Errorf("headlines = %q, want %q", got, tt.wantHeadlines)
+			}
+		})
+	}
+}
+
+func TestHandlerGetChirp(t *testing.T) {
+	_, srv := newTestServer(t)
+	user := createUser(t, srv, "user@example.com", "password")
+	chirp := createChirp(t, srv, user.Token, "hello")
+
+	tests := []struct {
+		name     string
+		id       string
+		wantCode int
+	}{
+		{name: "Existing chirp", id: chirp.ID.String(), wantCode: http.StatusOK},
+		{name: "Unknown chirp", id: "00000000-0000-0000-0000-000000000000", wantCode: http.StatusNotFound},
+		{name: "Invalid ID", id: "not-a-uuid", wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, dat := doRequest(t, srv, "GET", "/api/chirps/"+tt.id, "", nil)
+			if code != tt.wantCode {
+				t.Fatalf("GET /api/chirps/%s = %d, want %d: %s", tt.id, code, tt.wantCode, dat)
+			}
+			if code == http.StatusOK && decodeJSON[Chirp](t, dat).Body != "hello" {
+				t.Errorf("GET /api/chirps/%s returned %s", tt.id, dat)
+			}
+		})
+	}
+}
+
+func TestHandlerDeleteChirp(t *testing.T) {
+	_, srv := newTestServer(t)
+	alice := createUser(t, srv, "alice@example.com", "password")
+	bob := createUser(t, srv, "bob@example.com", "password")
+	chirp := createChirp(t, srv, alice.Token, "hello")
+	path := "/api/chirps/" + chirp.ID.String()
+
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		wantCode      int
+	}{
+		{name: "Missing token", path: path, wantCode: http.StatusUnauthorized},
+		{name: "Invalid token", path: path, authorization: "Bearer invalid", wantCode: http.StatusUnauthorized},
+		{name: "Invalid ID", path: "/api/chirps/not-a-uuid", authorization: "Bearer " + alice.Token, wantCode: http.StatusBadRequest},
+		{name: "Not the author", path: path, authorization: "Bearer " + bob.Token, wantCode: http.StatusForbidden},
+		{name: "Author deletes", path: path, authorization: "Bearer " + alice.Token, wantCode: http.StatusNoContent},
+		{name: "Already deleted", path: path, authorization: "Bearer " + alice.Token, wantCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, dat := doRequest(t, srv, "DELETE", tt.path, tt.authorization, nil)
+			if code != tt.wantCode {
+				t.Fatalf("DELETE %s = %d, want %d: %s", tt.path, code, tt.wantCode, dat)
+			}
+		})
+	}
+}
+
+func TestHandlerEditChirp(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	alice := createUser(t, srv, "alice@example.com", "password")
+	bob := createUser(t, srv, "bob@example.com", "password")
+	chirp := createChirp(t, srv, alice.Token, "learning #go")
+	path := "/api/chirps/" + chirp.ID.String()
+
+	// Editing is a Chirpy Red perk.
+	if code, dat := doRequest(t, srv, "PUT", path, "Bearer "+alice.Token, map[string]string{"body": "hi"}); code != http.StatusForbidden {
+		t.Fatalf("PUT %s as a free user = %d, want %d: %s", path, code, http.StatusForbidden, dat)
+	}
+	if _, err := cfg.db.UpdateSubscription(context.Background(), database.UpdateSubscriptionParams{ID: alice.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		body          string
+		wantCode      int
+	}{
+		{name: "Missing token", path: path, body: "hi", wantCode: http.StatusUnauthorized},
+		{name: "Invalid ID", path: "/api/chirps/not-a-uuid", authorization: "Bearer " + alice.Token, body: "hi", wantCode: http.StatusBadRequest},
+		{name: "Unknown chirp", path: "/api/chirps/" + uuid.NewString(), authorization: "Bearer " + alice.Token, body: "hi", wantCode: http.StatusNotFound},
+		{name: "Not the author", path: path, authorization: "Bearer " + bob.Token, body: "hi", wantCode: http.StatusForbidden},
+		{name: "Too long", path: path, authorization: "Bearer " + alice.Token, body: strings.Repeat("a", 281), wantCode: http.StatusBadRequest},
+		{name: "Author edits", path: path, authorization: "Bearer " + alice.Token, body: "switching to #zig, kerfuffle @bob", wantCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, dat := doRequest(t, srv, "PUT", tt.path, tt.authorization, map[string]string{"body": tt.body})
+			if code != tt.wantCode {
+				t.Fatalf("PUT %s = %d, want %d: %s", tt.path, code, tt.wantCode, dat)
+			}
+		})
+	}
+
+	_, dat := doRequest(t, srv, "GET", path, "", nil)
+	edited := decodeJSON[Chirp](t, dat)
+	if edited.Body != "switching to #zig, **** @bob" {
+		t.Errorf("edited body = %q", edited.Body)
+	}
+	if !edited.UpdatedAt.After(edited.CreatedAt) {
+		t.Errorf("updated_at %v is not after created_at %v", edited.UpdatedAt, edited.CreatedAt)
+	}
+
+	// The hashtags and mentions follow the new body.
+	for tag, want := range map[string]int{"go": 0, "zig": 1} {
+		_, dat := doRequest(t, srv, "GET", "/api/tags/"+tag+"/chirps", "", nil)
+		if got := len(decodeJSON[[]Chirp](t, dat)); got != want {
+			t.Errorf("chirps tagged #%s = %d, want %d", tag, got, want)
+		}
+	}
+	_, dat = doRequest(t, srv, "GET", "/api/notifications", "Bearer "+bob.Token, nil)
+	if got := len(decodeJSON[[]Notification](t, dat)); got != 1 {
+		t.Errorf("bob's notifications = %d, want 1", got)
+	}
+
+	code, dat := doRequest(t, srv, "POST", path+"/rechirps", "Bearer "+bob.Token, nil)
+	if code != http.StatusCreated {
+		t.Fatalf("POST %s/rechirps = %d: %s", path, code, dat)
+	}
+	rechirp := decodeJSON[Chirp](t, dat)
+	if code, _ := doRequest(t, srv, "PUT", "/api/chirps/"+rechirp.ID.String(), "Bearer "+bob.Token, map[string]string{"body": "hi"}); code != http.StatusBadRequest {
+		t.Errorf("editing a rechirp = %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestHandlerImportChirps(t *testing.T) {
+	_, srv := newTestServer(t)
+	alice := createUser(t, srv, "alice@example.com", "password")
+	bob := createUser(t, srv, "bob@example.com", "password")
+	first := createChirp(t, srv, alice.Token, "posted today")
+	archived := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	type importedChirp struct {
+		Body      string    `json:"body"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+	importChirps := func(t *testing.T, authorization string, chirps []importedChirp, wantCode int) []byte {
+		t.Helper()
+		code, dat := doRequest(t, srv, "POST", "/api/chirps/import", authorization, map[string][]importedChirp{"chirps": chirps})
+		if code != wantCode {
+			t.Fatalf("POST /api/chirps/import = %d, want %d: %s", code, wantCode, dat)
+		}
+		return dat
+	}
+
+	importChirps(t, "", []importedChirp{{Body: "hi"}}, http.StatusUnauthorized)
+	importChirps(t, "Bearer "+alice.Token, nil, http.StatusBadRequest)
+	importChirps(t, "Bearer "+alice.Token, make([]importedChirp, maxImportChirps+1), http.StatusBadRequest)
+	importChirps(t, "Bearer "+alice.Token, []importedChirp{{Body: "tomorrow", CreatedAt: time.Now().Add(time.Hour)}}, http.StatusBadRequest)
+	dat := importChirps(t, "Bearer "+alice.Token, []importedChirp{
+		{Body: "fine", CreatedAt: archived},
+		{Body: strings.Repeat("a", 141), CreatedAt: archived},
+	}, http.StatusBadRequest)
+	if msg := decodeJSON[map[string]string](t, dat)["error"]; msg != "chirps[1]: Chirp is too long: 141 characters used, 140 allowed" {
+		t.Errorf("error = %q", msg)
+	}
+	_, dat = doRequest(t, srv, "GET", "/api/chirps", "", nil)
+	if got := len(decodeJSON[[]Chirp](t, dat)); got != 1 {
+		t.Fatalf("chirps after a rejected import = %d, want 1", got)
+	}
+
+	dat = importChirps(t, "Bearer "+alice.Token, []importedChirp{
+		{Body: "what a kerfuffle #throwback @bob", CreatedAt: archived},
+		{Body: "older still", CreatedAt: archived.Add(-time.Hour).In(time.FixedZone("MSK", 3*60*60))},
+	}, http.StatusCreated)
+	imported := decodeJSON[[]Chirp](t, dat)
+	if len(imported) != 2 || imported[0].Body != "what a **** #throwback @bob" || !imported[0].CreatedAt.Equal(archived) {
+		t.Fatalf("imported = %+v", imported)
+	}
+	if got := imported[1].CreatedAt; got.Location() != time.UTC || !got.Equal(archived.Add(-time.Hour)) {
+		t.Errorf("chirp imported with a time zone was created at %s, want %s", got, archived.Add(-time.Hour))
+	}
+
+	// Imported chirps take their place in history.
+	_, dat = doRequest(t, srv, "GET", "/api/chirps", "", nil)
+	got := []uuid.UUID{}
+	for _, chirp := range decodeJSON[[]Chirp](t, dat) {
+		got = append(got, chirp.ID)
+	}
+	if want := []uuid.UUID{imported[1].ID, imported[0].ID, first.ID}; !slices.Equal(got, want) {
+		t.Errorf("chirps = %v, want %v", got, want)
+	}
+	_, dat = doRequest(t, srv, "GET", "/api/tags/throwback/chirps", "", nil)
+	if len(decodeJSON[[]Chirp](t, dat)) != 1 {
+		t.Errorf("imported chirp is not tagged: %s", dat)
+	}
+	_, dat = doRequest(t, srv, "GET", "/api/notifications", "Bearer "+bob.Token, nil)
+	if len(decodeJSON[[]Notification](t, dat)) != 0 {
+		t.Errorf("imported mention notified bob: %s", dat)
+	}
+
+	// The import quota counts chirps by when they were imported, however
+	// old they are.
+	archive := make([]importedChirp, tierLimits[TierFree].ImportsPerHour-1)
+	for i := range archive {
+		archive[i] = importedChirp{Body: fmt.Sprintf("old chirp %d", i), CreatedAt: archived}
+	}
+	code, dat := doRequest(t, srv, "POST", "/api/chirps/import", "Bearer "+alice.Token, map[string][]importedChirp{"chirps": archive})
+	if code != http.StatusTooManyRequests {
+		t.Fatalf("importing past the quota = %d, want %d: %s", code, http.StatusTooManyRequests, dat)
+	}
+	importChirps(t, "Bearer "+alice.Token, archive[:len(archive)-2], http.StatusCreated)
+	importChirps(t, "Bearer "+bob.Token, archive, http.StatusCreated)
+}
+
+func TestNewChirpPipeline(t *testing.T) {
+	cfg, _ := newTestServer(t)
+
+	tests := []struct {
+		names   string
+		want    []string
+		wantErr bool
+	}{
+		{names: "", want: []string{"normalize", "length", "profanity", "links", "mentions", "spam"}},
+		{names: "profanity, length", want: []string{"profanity", "length"}},
+		{names: "length,shouting", wantErr: true},
+		{names: "length,length", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		p, err := newChirpPipeline(cfg, tt.names)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("newChirpPipeline(%q) error = %v, wantErr %v", tt.names, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !slices.Equal(p.Stages(), tt.want) {
+			t.Errorf("newChirpPipeline(%q) stages = %v, want %v", tt.names, p.Stages(), tt.want)
+		}
+	}
+}
+
+func TestHandlerWebhook(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	user := createUser(t, srv, "user@example.com", "password")
+	ctx := context.Background()
+
+	event := func(name string, data map[string]any) map[string]any {
+		if data == nil {
+			data = map[string]any{}
+		}
+		data["user_id"] = user.ID
+		return map[string]any{"event": name, "data": data}
+	}
+	send := func(t *testing.T, body any, wantCode int) {
+		t.Helper()
+		code, dat := doRequest(t, srv, "POST", "/api/polka/webhooks", "ApiKey "+testPolkaKey, body)
+		if code != wantCode {
+			t.Fatalf("POST /api/polka/webhooks = %d, want %d: %s", code, wantCode, dat)
+		}
+	}
+	// subscription logs in to see the user as clients do.
+	subscription := func(t *testing.T) (bool, *time.Time) {
+		t.Helper()
+		login := map[string]string{"email": "user@example.com", "password": "password"}
+		_, dat := doRequest(t, srv, "POST", "/api/login", "", login)
+		got := decodeJSON[loginResponse](t, dat)
+		return got.IsChirpyRed, got.ChirpyRedExpiresAt
+	}
+
+	upgraded := event("user.upgraded", nil)
+	tests := []struct {
+		name          string
+		authorization string
+		body          any
+		wantCode      int
+	}{
+		{name: "Missing API key", body: upgraded, wantCode: http.StatusUnauthorized},
+		{name: "Wrong API key", authorization: "ApiKey wrong", body: upgraded, wantCode: http.StatusUnauthorized},
+		{name: "Other events need the key too", body: event("user.renamed", nil), wantCode: http.StatusUnauthorized},
+		{name: "Other events are ignored", authorization: "ApiKey " + testPolkaKey, body: event("user.renamed", nil), wantCode: http.StatusNoContent},
+		{
+			name:          "Unknown user",
+			authorization: "ApiKey " + testPolkaKey,
+			body:          map[string]any{"event": "user.upgraded", "data": map[string]any{"user_id": uuid.New()}},
+			wantCode:      http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, dat := doRequest(t, srv, "POST", "/api/polka/webhooks", tt.authorization, tt.body)
+			if code != tt.wantCode {
+				t.Fatalf("POST /api/polka/webhooks = %d, want %d: %s", code, tt.wantCode, dat)
+			}
+		})
+	}
+	if red, _ := subscription(t); red {
+		t.Fatalf("user is Chirpy Red before any upgrade")
+	}
+
+	t.Run("Upgrade and downgrade", func(t *testing.T) {
+		send(t, upgraded, http.StatusNoContent)
+		if red, expires := subscription(t); !red || expires != nil {
+			t.Errorf("after user.upgraded: is_chirpy_red = %v, expires at %v; want no expiry", red, expires)
+		}
+		send(t, event("user.downgraded", nil), http.StatusNoContent)
+		if red, _ := subscription(t); red {
+			t.Errorf("user is still Chirpy Red after user.downgraded")
+		}
+	})
+
+	t.Run("Payment failure without Chirpy Red", func(t *testing.T) {
+		send(t, event("user.payment_failed", nil), http.StatusNoContent)
+		if red, expires := subscription(t); red || expires != nil {
+			t.Errorf("after user.payment_failed for a free user: is_chirpy_red = %v, expires at %v", red, expires)
+		}
+	})
+
+	t.Run("Payment failure grace period", func(t *testing.T) {
+		send(t, upgraded, http.StatusNoContent)
+		send(t, event("user.payment_failed", nil), http.StatusNoContent)
+		red, expires := subscription(t)
+		if !red || expires == nil {
+			t.Fatalf("after user.payment_failed: is_chirpy_red = %v, expires at %v; want a grace period", red, expires)
+		}
+		if want := time.Now().Add(paymentGracePeriod); expires.Sub(want).Abs() > time.Minute {
+			t.Errorf("grace period ends at %v, want about %v", expires, want)
+		}
+
+		// A retry that fails too doesn't push the end back.
+		send(t, event("user.payment_failed", nil), http.StatusNoContent)
+		if _, again := subscription(t); again == nil || !again.Equal(*expires) {
+			t.Errorf("second failure moved the expiry from %v to %v", expires, again)
+		}
+
+		if _, err := cfg.db.ExpireSubscriptions(ctx, time.Now().UTC()); err != nil {
+			t.Fatal(err)
+		}
+		if red, _ := subscription(t); !red {
+			t.Errorf("user lost Chirpy Red during the grace period")
+		}
+		expired, err := cfg.db.ExpireSubscriptions(ctx, expires.Add(time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if red, expires := subscription(t); expired != 1 || red || expires != nil {
+			t.Errorf("after the grace period: expired %d, is_chirpy_red = %v, expires at %v", expired, red, expires)
+		}
+	})
+
+	t.Run("Renewal ends the grace period", func(t *testing.T) {
+		send(t, upgraded, http.StatusNoContent)
+		send(t, event("user.payment_failed", nil), http.StatusNoContent)
+		send(t, upgraded, http.StatusNoContent)
+		if red, expires := subscription(t); !red || expires != nil {
+			t.Errorf("after renewal: is_chirpy_red = %v, expires at %v; want no expiry", red, expires)
+		}
+		if _, err := cfg.db.ExpireSubscriptions(ctx, time.Now().Add(2*paymentGracePeriod)); err != nil {
+			t.Fatal(err)
+		}
+		if red, _ := subscription(t); !red {
+			t.Errorf("renewed subscription expired with the old grace period")
+		}
+		send(t, event("user.downgraded", nil), http.StatusNoContent)
+	})
+
+	t.Run("Grace period doesn't shorten a paid period", func(t *testing.T) {
+		expires_at := time.Now().Add(4 * paymentGracePeriod).UTC().Truncate(time.Microsecond)
+		send(t, event("user.upgraded", map[string]any{"expires_at": expires_at}), http.StatusNoContent)
+		send(t, event("user.payment_failed", nil), http.StatusNoContent)
+		if red, expires := subscription(t); !red || expires == nil || !expires.Equal(expires_at) {
+			t.Errorf("after user.payment_failed: is_chirpy_red = %v, expires at %v; want %v", red, expires, expires_at)
+		}
+		send(t, event("user.downgraded", nil), http.StatusNoContent)
+	})
+
+	t.Run("Background expiry", func(t *testing.T) {
+		expires_at := time.Now().Add(50 * time.Millisecond).UTC().Truncate(time.Microsecond)
+		send(t, event("user.upgraded", map[string]any{"expires_at": expires_at}), http.StatusNoContent)
+		if red, expires := subscription(t); !red || expires == nil || !expires.Equal(expires_at) {
+			t.Fatalf("after user.upgraded: is_chirpy_red = %v, expires at %v; want %v", red, expires, expires_at)
+		}
+
+		job_ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go cfg.expireSubscriptions(job_ctx, 10*time.Millisecond)
+		for deadline := time.Now().Add(5 * time.Second); ; {
+			limits, err := cfg.limitsFor(ctx, user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if limits.Tier == TierFree {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("subscription did not expire")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if red, expires := subscription(t); red || expires != nil {
+			t.Errorf("after expiry: is_chirpy_red = %v, expires at %v", red, expires)
+		}
+	})
+
+	// This runs last: its events are dated ahead, so undated events sent
+	// after them would be ignored as late.
+	t.Run("Late events are ignored", func(t *testing.T) {
+		start := time.Now()
+		send(t, event("user.downgraded", map[string]any{"occurred_at": start.Add(2 * time.Minute)}), http.StatusNoContent)
+		// The upgrade happened before the downgrade but arrives after it.
+		send(t, event("user.upgraded", map[string]any{"occurred_at": start.Add(time.Minute)}), http.StatusNoContent)
+		if red, _ := subscription(t); red {
+			t.Errorf("a late user.upgraded undid a newer user.downgraded")
+		}
+		send(t, event("user.upgraded", map[string]any{"occurred_at": start.Add(3 * time.Minute)}), http.StatusNoContent)
+		send(t, event("user.payment_failed", map[string]any{"occurred_at": start.Add(time.Minute)}), http.StatusNoContent)
+		if red, expires := subscription(t); !red || expires != nil {
+			t.Errorf("after a late user.payment_failed: is_chirpy_red = %v, expires at %v; want no expiry", red, expires)
+		}
+	})
+}
+
+// loginAdmin creates an admin the way ADMIN_EMAIL does and logs them in.
+func loginAdmin(t *testing.T, cfg *apiConfig, srv *httptest.Server) loginResponse {
+	t.Helper()
+	if err := bootstrapAdmin(context.Background(), cfg, "admin@example.com", "admin-password"); err != nil {
+		t.Fatalf("bootstrapAdmin() error = %v", err)
+	}
+	login := map[string]string{"email": "admin@example.com", "password": "admin-password"}
+	code, dat := doRequest(t, srv, "POST", "/api/login", "", login)
+	if code != http.StatusOK {
+		t.Fatalf("logging in as admin: status %d: %s", code, dat)
+	}
+	return decodeJSON[loginResponse](t, dat)
+}
+
+func TestHandlerAdmin(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	user := createUser(t, srv, "user@example.com", "password")
+	admin := loginAdmin(t, cfg, srv)
+	if admin.Role != "admin" {
+		t.Fatalf("bootstrapped admin has role %q, want admin", admin.Role)
+	}
+
+	for range 3 {
+		doRequest(t, srv, "GET", "/app/", "", nil)
+	}
+	if code, _ := doRequest(t, srv, "GET", "/admin/metrics", "", nil); code != http.StatusUnauthorized {
+		t.Errorf("GET /admin/metrics without a token = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := doRequest(t, srv, "GET", "/admin/metrics", "Bearer "+user.Token, nil); code != http.StatusForbidden {
+		t.Errorf("GET /admin/metrics as a user = %d, want %d", code, http.StatusForbidden)
+	}
+	code, dat := doRequest(t, srv, "GET", "/admin/metrics", "Bearer "+admin.Token, nil)
+	if code != http.StatusOK || !strings.Contains(string(dat), "visited 3 times") {
+		t.Errorf("GET /admin/metrics = %d %q, want 3 visits", code, dat)
+	}
+
+	if code, _ := doRequest(t, srv, "POST", "/admin/reset", "Bearer "+user.Token, nil); code != http.StatusForbidden {
+		t.Errorf("POST /admin/reset as a user = %d, want %d", code, http.StatusForbidden)
+	}
+	cfg.platform = "prod"
+	if code, _ := doRequest(t, srv, "POST", "/admin/reset", "Bearer "+admin.Token, nil); code != http.StatusForbidden {
+		t.Errorf("POST /admin/reset outside dev = %d, want %d", code, http.StatusForbidden)
+	}
+
+	cfg.platform = "dev"
+	cfg.admin_email = "admin@example.com"
+	cfg.admin_password = "admin-password"
+	if code, _ := doRequest(t, srv, "POST", "/admin/reset", "Bearer "+admin.Token, nil); code != http.StatusOK {
+		t.Errorf("POST /admin/reset in dev = %d, want %d", code, http.StatusOK)
+	}
+	if cfg.fileserverHits.Load() != 0 {
+		t.Errorf("fileserver hits after reset = %d, want 0", cfg.fileserverHits.Load())
+	}
+	login := map[string]string{"email": "user@example.com", "password": "password"}
+	if code, _ := doRequest(t, srv, "POST", "/api/login", "", login); code != http.StatusUnauthorized {
+		t.Errorf("login after reset = %d, want %d", code, http.StatusUnauthorized)
+	}
+	// The admin is bootstrapped again.
+	login = map[string]string{"email": "admin@example.com", "password": "admin-password"}
+	code, dat = doRequest(t, srv, "POST", "/api/login", "", login)
+	if code != http.StatusOK {
+		t.Fatalf("admin login after reset = %d, want %d: %s", code, http.StatusOK, dat)
+	}
+	if admin := decodeJSON[loginResponse](t, dat); admin.Role != "admin" {
+		t.Errorf("admin after reset has role %q, want admin", admin.Role)
+	}
+}
+
+func TestHandlerRoles(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	alice := createUser(t, srv, "alice@example.com", "password")
+	bob := createUser(t, srv, "bob@example.com", "password")
+	admin := loginAdmin(t, cfg, srv)
+	chirp := createChirp(t, srv, alice.Token, "Moderate me")
+
+	setRole := func(t *testing.T, token, userID, role string, wantCode int) {
+		t.Helper()
+		code, dat := doRequest(t, srv, "PUT", "/admin/users/"+userID+"/role", "Bearer "+token, map[string]string{"role": role})
+		if code != wantCode {
+			t.Fatalf("setting role of %s to %q = %d, want %d: %s", userID, role, code, wantCode, dat)
+		}
+	}
+	setRole(t, bob.Token, bob.ID.String(), "admin", http.StatusForbidden)
+	setRole(t, admin.Token, bob.ID.String(), "superuser", http.StatusBadRequest)
+	setRole(t, admin.Token, uuid.NewString(), "moderator", http.StatusNotFound)
+	setRole(t, admin.Token, bob.ID.String(), "moderator", http.StatusOK)
+
+	// bob's access token still says user until it is refreshed.
+	if code, _ := doRequest(t, srv, "DELETE", "/api/chirps/"+chirp.ID.String(), "Bearer "+bob.Token, nil); code != http.StatusForbidden {
+		t.Errorf("deleting another user's chirp with a stale token = %d, want %d", code, http.StatusForbidden)
+	}
+	code, dat := doRequest(t, srv, "POST", "/api/refresh", "Bearer "+bob.RefreshToken, nil)
+	if code != http.StatusOK {
+		t.Fatalf("POST /api/refresh = %d: %s", code, dat)
+	}
+	moderator := decodeJSON[loginResponse](t, dat)
+	if code, _ := doRequest(t, srv, "GET", "/admin/metrics", "Bearer "+moderator.Token, nil); code != http.StatusForbidden {
+		t.Errorf("GET /admin/metrics as a moderator = %d, want %d", code, http.StatusForbidden)
+	}
+	if code, _ := doRequest(t, srv, "DELETE", "/api/chirps/"+chirp.ID.String(), "Bearer "+moderator.Token, nil); code != http.StatusNoContent {
+		t.Errorf("deleting another user's chirp as a moderator = %d, want %d", code, http.StatusNoContent)
+	}
+
+	// ADMIN_EMAIL promotes an existing user without needing a password.
+	if err := bootstrapAdmin(context.Background(), cfg, "alice@example.com", ""); err != nil {
+		t.Fatalf("bootstrapAdmin() error = %v", err)
+	}
+	if user, _ := cfg.db.GetUserByEmail(context.Background(), "alice@example.com"); user.Role != "admin" {
+		t.Errorf("alice's role after bootstrapAdmin() = %q, want admin", user.Role)
+	}
+	if err := bootstrapAdmin(context.Background(), cfg, "nobody@example.com", ""); err == nil {
+		t.Errorf("bootstrapAdmin() for an unknown email without a password succeeded, want an error")
+	}
+}
+
+func TestHandlerWordFilter(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	alice := createUser(t, srv, "alice@example.com", "password")
+	admin := loginAdmin(t, cfg, srv)
+
+	if chirp := createChirp(t, srv, alice.Token, "Kerfuffle! What a $h4rbert, honestly"); chirp.Body != "****! What a ****, honestly" {
+		t.Errorf("masked body = %q", chirp.Body)
+	}
+
+	setPolicy := func(t *testing.T, token, word, policy string, wantCode int) {
+		t.Helper()
+		code, dat := doRequest(t, srv, "PUT", "/admin/filter/words/"+word, "Bearer "+token, map[string]string{"policy": policy})
+		if code != wantCode {
+			t.Fatalf("setting %q to %q = %d, want %d: %s", word, policy, code, wantCode, dat)
+		}
+	}
+	setPolicy(t, alice.Token, "gadzooks", "reject", http.StatusForbidden)
+	setPolicy(t, admin.Token, "gadzooks", "ban", http.StatusBadRequest)
+	setPolicy(t, admin.Token, "gadzooks", "reject", http.StatusOK)
+	setPolicy(t, admin.Token, "Fornax", "flag", http.StatusOK)
+
+	code, dat := doRequest(t, srv, "GET", "/admin/filter/words", "Bearer "+admin.Token, nil)
+	if code != http.StatusOK {
+		t.Fatalf("GET /admin/filter/words = %d: %s", code, dat)
+	}
+	words := map[string]string{}
+	for _, word := range decodeJSON[[]BannedWord](t, dat) {
+		words[word.Word] = word.Policy
+	}
+	if words["gadzooks"] != "reject" || words["fornax"] != "flag" || words["kerfuffle"] != "mask" {
+		t.Errorf("banned words = %v", words)
+	}
+
+	// Edits apply without a restart.
+	code, dat = doRequest(t, srv, "POST", "/api/chirps", "Bearer "+alice.Token, map[string]string{"body": "GADZ00KS!"})
+	if code != http.StatusBadRequest {
+		t.Errorf("chirp with a rejected word = %d, want %d: %s", code, http.StatusBadRequest, dat)
+	}
+	flagged := createChirp(t, srv, alice.Token, "fornax, again")
+	if flagged.Body != "fornax, again" {
+		t.Errorf("flagged body = %q, want it unchanged", flagged.Body)
+	}
+	createChirp(t, srv, alice.Token, "nothing to see")
+
+	code, dat = doRequest(t, srv, "GET", "/api/moderation/flags", "Bearer "+alice.Token, nil)
+	if code != http.StatusForbidden {
+		t.Errorf("GET /api/moderation/flags as a user = %d, want %d", code, http.StatusForbidden)
+	}
+	code, dat = doRequest(t, srv, "GET", "/api/moderation/flags", "Bearer "+admin.Token, nil)
+	if code != http.StatusOK {
+		t.Fatalf("GET /api/moderation/flags = %d: %s", code, dat)
+	}
+	queue := decodeJSON[[]FlaggedChirp](t, dat)
+	if len(queue) != 1 || queue[0].ID != flagged.ID || !slices.Equal(queue[0].FlaggedWords, []string{"fornax"}) {
+		t.Fatalf("review queue = %+v, want the fornax chirp", queue)
+	}
+
+	path := "/api/moderation/flags/" + flagged.ID.String()
+	if code, dat := doRequest(t, srv, "DELETE", path, "Bearer "+admin.Token, nil); code != http.StatusNoContent {
+		t.Errorf("DELETE %s = %d: %s", path, code, dat)
+	}
+	if code, _ := doRequest(t, srv, "DELETE", path, "Bearer "+admin.Token, nil); code != http.StatusNotFound {
+		t.Errorf("reviewing a flag twice = %d, want %d", code, http.StatusNotFound)
+	}
+	if _, dat := doRequest(t, srv, "GET", "/api/moderation/flags", "Bearer "+admin.Token, nil); len(decodeJSON[[]FlaggedChirp](t, dat)) != 0 {
+		t.Errorf("review queue after review = %s, want it empty", dat)
+	}
+
+	if code, dat := doRequest(t, srv, "DELETE", "/admin/filter/words/gadzooks", "Bearer "+admin.Token, nil); code != http.StatusNoContent {
+		t.Errorf("DELETE /admin/filter/words/gadzooks = %d: %s", code, dat)
+	}
+	if code, _ := doRequest(t, srv, "DELETE", "/admin/filter/words/gadzooks", "Bearer "+admin.Token, nil); code != http.StatusNotFound {
+		t.Errorf("deleting a word twice = %d, want %d", code, http.StatusNotFound)
+	}
+	if chirp := createChirp(t, srv, alice.Token, "gadzooks"); chirp.Body != "gadzooks" {
+		t.Errorf("body after deleting the word = %q", chirp.Body)
+	}
+}
+
+func TestHandlerFollows(t *testing.T) {
+	_, srv := newTestServer(t)
+	alice := createUser(t, srv, "alice@example.com", "password")
+	bob := createUser(t, srv, "bob@example.com", "password")
+	carol := createUser(t, srv, "carol@example.com", "password")
+
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		authorization string
+		wantCode      int
+	}{
+		{name: "Missing token", method: "POST", path: "/api/users/" + bob.ID.String() + "/follow", wantCode: http.StatusUnauthorized},
+		{name: "Invalid user ID", method: "POST", path: "/api/users/nope/follow", authorization: "Bearer " + alice.Token, wantCode: http.StatusBadRequest},
+		{name: "Unknown user", method: "POST", path: "/api/users/00000000-0000-0000-0000-000000000000/follow", authorization: "Bearer " + alice.Token, wantCode: http.StatusNotFound},
+		{name: "Follow yourself", method: "POST", path: "/api/users/" + alice.ID.String() + "/follow", authorization: "Bearer " + alice.Token, wantCode: http.StatusBadRequest},
+		{name: "Follow bob", method: "POST", path: "/api/users/" + bob.ID.String() + "/follow", authorization: "Bearer " + alice.Token, wantCode: http.StatusNoContent},
+		{name: "Follow bob again", method: "POST", path: "/api/users/" + bob.ID.String() + "/follow", authorization: "Bearer " + alice.Token, wantCode: http.StatusNoContent},
+		{name: "Follow carol", method: "POST", path: "/api/users/" + carol.ID.String() + "/follow", authorization: "Bearer " + alice.Token, wantCode: http.StatusNoContent},
+		{name: "Carol follows bob", method: "POST", path: "/api/users/" + bob.ID.String() + "/follow", authorization: "Bearer " + carol.Token, wantCode: http.StatusNoContent},
+		{name: "Unfollow without token", method: "DELETE", path: "/api/users/" + bob.ID.String() + "/follow", wantCode: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, dat := doRequest(t, srv, tt.method, tt.path, tt.authorization, nil)
+			if code != tt.wantCode {
+				t.Fatalf("%s %s = %d, want %d: %s", tt.method, tt.path, code, tt.wantCode, dat)
+			}
+		})
+	}
+
+	lists := []struct {
+		path string
+		want []uuid.UUID
+	}{
+		{path: "/api/users/" + bob.ID.String() + "/followers", want: []uuid.UUID{carol.ID, alice.ID}},
+		{path: "/api/users/" + alice.ID.String() + "/following", want: []uuid.UUID{carol.ID, bob.ID}},
+		{path: "/api/users/" + alice.ID.String() + "/followers", want: []uuid.UUID{}},
+	}
+	for _, list := range lists {
+		code, dat := doRequest(t, srv, "GET", list.path, "", nil)
+		if code != http.StatusOK {
+			t.Fatalf("GET %s = %d: %s", list.path, code, dat)
+		}
+		got := []uuid.UUID{}
+		for _, user := range decodeJSON[[]PublicUser](t, dat) {
+			got = append(got, user.ID)
+		}
+		if !slices.Equal(got, list.want) {
+			t.Errorf("GET %s = %v, want %v", list.path, got, list.want)
+		}
+		// Anyone can read the lists, so they must not give away accounts.
+		for _, field := range []string{`"email"`, `"role"`, `"email_verified"`, `"is_chirpy_red"`} {
+			if bytes.Contains(dat, []byte(field)) {
+				t.Errorf("GET %s exposes %s: %s", list.path, field, dat)
+			}
+		}
+	}
+	if code, _ := doRequest(t, srv, "GET", "/api/users/00000000-0000-0000-0000-000000000000/followers", "", nil); code != http.StatusNotFound {
+		t.Errorf("followers of unknown user = %d, want %d", code, http.StatusNotFound)
+	}
+
+	code, _ := doRequest(t, srv, "DELETE", "/api/users/"+carol.ID.String()+"/follow", "Bearer "+alice.Token, nil)
+	if code != http.StatusNoContent {
+		t.Fatalf("unfollowing carol = %d, want %d", code, http.StatusNoContent)
+	}
+	code, dat := doRequest(t, srv, "GET", "/api/users/"+alice.ID.String()+"/following", "", nil)
+	if got := decodeJSON[[]User](t, dat); code != http.StatusOK || len(got) != 1 || got[0].ID != bob.ID {
+		t.Errorf("alice follows %s after unfollowing carol, want only bob", dat)
+	}
+}
+
+func TestHandlerTimeline(t *testing.T) {
+	_, srv := newTestServer(t)
+	alice := createUser(t, srv, "alice@example.com", "password")
+	bob := createUser(t, srv, "bob@example.com", "password")
+	carol := createUser(t, srv, "carol@example.com", "password")
+	dave := createUser(t, srv, "dave@example.com", "password")
+	for _, followee := range []loginResponse{bob, carol} {
+		doRequest(t, srv, "POST", "/api/users/"+followee.ID.String()+"/follow", "Bearer "+alice.Token, nil)
+	}
+
+	first := createChirp(t, srv, bob.Token, "bob 1")
+	createChirp(t, srv, alice.Token, "alice's own chirp")
+	second := createChirp(t, srv, carol.Token, "carol 1")
+	createChirp(t, srv, dave.Token, "dave is not followed")
+	third := createChirp(t, srv, bob.Token, "bob 2")
+
+	if code, _ := doRequest(t, srv, "GET", "/api/timeline", "", nil); code != http.StatusUnauthorized {
+		t.Errorf("GET /api/timeline without token = %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	code, dat := doRequest(t, srv, "GET", "/api/timeline", "Bearer "+alice.Token, nil)
+	if code != http.StatusOK {
+		t.Fatalf("GET /api/timeline = %d: %s", code, dat)
+	}
+	got := []uuid.UUID{}
+	for _, chirp := range decodeJSON[[]Chirp](t, dat) {
+		got = append(got, chirp.ID)
+	}
+	want := []uuid.UUID{third.ID, second.ID, first.ID}
+	if !slices.Equal(got, want) {
+		t.Errorf("GET /api/timeline = %s, want bob 2, carol 1, bob 1", dat)
+	}
+}
+
+func createReply(t *testing.T, srv *httptest.Server, token, body string, inReplyTo uuid.UUID) Chirp {
+	t.Helper()
+	params := map[string]any{"body": body, "in_reply_to": inReplyTo}
+	code, dat := doRequest(t, srv, "POST", "/api/chirps", "Bearer "+token, params)
+	if code != http.StatusCreated {
+		t.Fatalf("creating reply: status %d: %s", code, dat)
+	}
+	return decodeJSON[Chirp](t, dat)
+}
+
+func TestHandlerThreads(t *testing.T) {
+	_, srv := newTestServer(t)
+	alice := createUser(t, srv, "alice@example.com", "password")
+	bob := createUser(t, srv, "bob@example.com", "password")
+
+	root := createChirp(t, srv, alice.Token, "root")
+	first := createReply(t, srv, bob.Token, "first reply", root.ID)
+	nested := createReply(t, srv, alice.Token, "nested reply", first.ID)
+	second := createReply(t, srv, bob.Token, "second reply", root.ID)
+
+	if first.InReplyTo == nil || *first.InReplyTo != root.ID {
+		t.Errorf("reply in_reply_to = %v, want %v", first.InReplyTo, root.ID)
+	}
+	params := map[string]any{"body": "orphan", "in_reply_to": uuid.Nil}
+	if code, _ := doRequest(t, srv, "POST", "/api/chirps", "Bearer "+bob.Token, params); code != http.StatusNotFound {
+		t.Errorf("replying to an unknown chirp = %d, want %d", code, http.StatusNotFound)
+	}
+
+	getThread := func(id uuid.UUID) ChirpThread {
+		t.Helper()
+		code, dat := doRequest(t, srv, "GET", "/api/chirps/"+id.String()+"/thread", "", nil)
+		if code != http.StatusOK {
+			t.Fatalf("GET thread of %v = %d: %s", id, code, dat)
+		}
+		return decodeJSON[ChirpThread](t, dat)
+	}
+
+	thread := getThread(root.ID)
+	if len(thread.Ancestors) != 0 || thread.Chirp.ReplyCount != 2 || len(thread.Chirp.Replies) != 2 {
+		t.Fatalf("root thread = %+v, want no ancestors and 2 replies", thread)
+	}
+	if thread.Chirp.Replies[0].ID != first.ID || thread.Chirp.Replies[1].ID != second.ID {
+		t.Errorf("root replies are not in posting order")
+	}
+	if replies := thread.Chirp.Replies[0].Replies; len(replies) != 1 || replies[0].ID != nested.ID {
+		t.Errorf("first reply's replies = %+v, want the nested reply", replies)
+	}
+
+	thread = getThread(nested.ID)
+	if len(thread.Ancestors) != 2 || thread.Ancestors[0].ID != root.ID || thread.Ancestors[1].ID != first.ID {
+		t.Errorf("nested reply ancestors = %+v, want root then first reply", thread.Ancestors)
+	}
+
+	// Deleting a chirp with replies leaves a tombstone.
+	if code, _ := doRequest(t, srv, "DELETE", "/api/chirps/"+root.ID.String(), "Bearer "+alice.Token, nil); code != http.StatusNoContent {
+		t.Fatalf("deleting root = %d, want %d", code, http.StatusNoContent)
+	}
+	if code, _ := doRequest(t, srv, "GET", "/api/chirps/"+root.ID.String(), "", nil); code != http.StatusNotFound {
+		t.Errorf("GET deleted root = %d, want %d", code, http.StatusNotFound)
+	}
+	thread = getThread(first.ID)
+	if len(thread.Ancestors) != 1 || !thread.Ancestors[0].Deleted || thread.Ancestors[0].Body != "" {
+		t.Errorf("ancestors after deleting root = %+v, want a tombstone", thread.Ancestors)
+	}
+	_, dat := doRequest(t, srv, "GET", "/api/chirps", "", nil)
+	for _, chirp := range decodeJSON[[]Chirp](t, dat) {
+		if chirp.ID == root.ID {
+			t.Errorf("GET /api/chirps lists the tombstone")
+		}
+	}
+	if code, _ := doRequest(t, srv, "DELETE", "/api/chirps/"+root.ID.String(), "Bearer "+alice.Token, nil); code != http.StatusNotFound {
+		t.Errorf("deleting a tombstone = %d, want %d", code, http.StatusNotFound)
+	}
+
+	// Deleting a leaf removes it and decrements its parent's reply count.
+	if code, _ := doRequest(t, srv, "DELETE", "/api/chirps/"+nested.ID.String(), "Bearer "+alice.Token, nil); code != http.StatusNoContent {
+		t.Fatalf("deleting nested reply = %d, want %d", code, http.StatusNoContent)
+	}
+	thread = getThread(first.ID)
+	if thread.Chirp.ReplyCount != 0 || len(thread.Chirp.Replies) != 0 {
+		t.Errorf("first reply after deleting its reply = %+v, want no replies", thread.Chirp)
+	}
+}
+
+func TestHandlerLikes(t *testing.T) {
+	_, srv := newTestServer(t)
+	alice := createUser(t, srv, "alice@example.com", "password")
+	bob := createUser(t, srv, "bob@example.com", "password")
+	chirp := createChirp(t, srv, alice.Token, "like me")
+	other := createChirp(t, srv, alice.Token, "nobody likes me")
+	path := "/api/chirps/" + chirp.ID.String() + "/likes"
+
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		authorization string
+		wantCode      int
+	}{
+		{name: "Missing token", method: "POST", path: path, wantCode: http.StatusUnauthorized},
+		{name: "Invalid chirp ID", method: "POST", path: "/api/chirps/nope/likes", authorization: "Bearer " + bob.Token, wantCode: http.StatusBadRequest},
+		{name: "Unknown chirp", method: "POST", path: "/api/chirps/" + uuid.Nil.String() + "/likes", authorization: "Bearer " + bob.Token, wantCode: http.StatusNotFound},
+		{name: "Bob likes", method: "POST", path: path, authorization: "Bearer " + bob.Token, wantCode: http.StatusNoContent},
+		{name: "Bob likes again", method: "POST", path: path, authorization: "Bearer " + bob.Token, wantCode: http.StatusNoContent},
+		{name: "Alice likes", method: "POST", path: path, authorization: "Bearer " + alice.Token, wantCode: http.StatusNoContent},
+		{name: "Alice unlikes", method: "DELETE", path: path, authorization: "Bearer " + alice.Token, wantCode: http.StatusNoContent},
+		{name: "Alice unlikes again", method: "DELETE", path: path, authorization: "Bearer " + alice.Token, wantCode: http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, dat := doRequest(t, srv, tt.method, tt.path, tt.authorization, nil)
+			if code != tt.wantCode {
+				t.Fatalf("%s %s = %d, want %d: %s", tt.method, tt.path, code, tt.wantCode, dat)
+			}
+		})
+	}
+
+	views := []struct {
+		name          string
+		authorization string
+		wantLikedByMe *bool
+	}{
+		{name: "Anonymous", wantLikedByMe: nil},
+		{name: "Bob", authorization: "Bearer " + bob.Token, wantLikedByMe: func() *bool { b := true; return &b }()},
+		{name: "Alice", authorization: "Bearer " + alice.Token, wantLikedByMe: func() *bool { b := false; return &b }()},
+	}
+	for _, view := range views {
+		t.Run(view.name, func(t *testing.T) {
+			_, dat := doRequest(t, srv, "GET", "/api/chirps/"+chirp.ID.String(), view.authorization, nil)
+			got := decodeJSON[Chirp](t, dat)
+			if got.LikeCount != 1 {
+				t.Errorf("like_count = %d, want 1", got.LikeCount)
+			}
+			if (got.LikedByMe == nil) != (view.wantLikedByMe == nil) ||
+				(got.LikedByMe != nil && *got.LikedByMe != *view.wantLikedByMe) {
+				t.Errorf("liked_by_me = %s, want %v", dat, view.wantLikedByMe)
+			}
+
+			_, dat = doRequest(t, srv, "GET", "/api/chirps", view.authorization, nil)
+			list := decodeJSON[[]Chirp](t, dat)
+			if len(list) != 2 || list[0].ID != chirp.ID || list[1].ID != other.ID {
+				t.Fatalf("GET /api/chirps = %s", dat)
+			}
+			if list[0].LikeCount != 1 || list[1].LikeCount != 0 {
+				t.Errorf("like counts = %d, %d, want 1, 0", list[0].LikeCount, list[1].LikeCount)
+			}
+			if (list[0].LikedByMe == nil) != (view.wantLikedByMe == nil) {
+				t.Errorf("liked_by_me in list = %v, want %v", list[0].LikedByMe, view.wantLikedByMe)
+			}
+		})
+	}
+}
+
+func TestHandlerRechirps(t *testing.T) {
+	_, srv := newTestServer(t)
+	alice := createUser(t, srv, "alice@example.com", "password")
+	bob := createUser(t, srv, "bob@example.com", "password")
+	original := createChirp(t, srv, alice.Token, "original thought")
+	path := "/api/chirps/" + original.ID.String() + "/rechirps"
+
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		body          any
+		wantCode      int
+		wantKind      string
+		wantBody      string
+	}{
+		{name: "Missing token", path: path, wantCode: http.StatusUnauthorized},
+		{name: "Unknown chirp", path: "/api/chirps/" + uuid.Nil.String() + "/rechirps", authorization: "Bearer " + bob.Token, wantCode: http.StatusNotFound},
+		{name: "Plain rechirp", path: path, authorization: "Bearer " + bob.Token, wantCode: http.StatusCreated, wantKind: "rechirp"},
+		{name: "Rechirp twice", path: path, authorization: "Bearer " + bob.Token, wantCode: http.StatusConflict},
+		{
+			name:          "Quote",
+			path:          path,
+			authorization: "Bearer " + bob.Token,
+			body:          map[string]string{"body": "what a sharbert take"},
+			wantCode:      http.StatusCreated,
+			wantKind:      "quote",
+			wantBody:      "what a **** take",
+		},
+		{
+			name:          "Quote too long",
+			path:          path,
+			authorization: "Bearer " + bob.Token,
+			body:          map[string]string{"body": strings.Repeat("a", 141)},
+			wantCode:      http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, dat := doRequest(t, srv, "POST", tt.path, tt.authorization, tt.body)
+			if code != tt.wantCode {
+				t.Fatalf("POST %s = %d, want %d: %s", tt.path, code, tt.wantCode, dat)
+			}
+			if code != http.StatusCreated {
+				return
+			}
+			chirp := decodeJSON[Chirp](t, dat)
+			if chirp.Kind != tt.wantKind || chirp.Body != tt.wantBody {
+				t.Errorf("created %s %q, want %s %q", chirp.Kind, chirp.Body, tt.wantKind, tt.wantBody)
+			}
+			if chirp.ReferencedChirp == nil || chirp.ReferencedChirp.Chirp == nil || chirp.ReferencedChirp.ID != original.ID {
+				t.Errorf("referenced_chirp = %s, want the original", dat)
+			}
+		})
+	}
+
+	// Once the original is deleted, the list still works and shows the
+	// rechirp and the quote as unavailable.
+	if code, _ := doRequest(t, srv, "DELETE", "/api/chirps/"+original.ID.String(), "Bearer "+alice.Token, nil); code != http.StatusNoContent {
+		t.Fatalf("deleting the original = %d, want %d", code, http.StatusNoContent)
+	}
+	code, dat := doRequest(t, srv, "GET", "/api/chirps?author_id="+bob.ID.String(), "", nil)
+	if code != http.StatusOK {
+		t.Fatalf("GET /api/chirps = %d: %s", code, dat)
+	}
+	list := decodeJSON[[]Chirp](t, dat)
+	if len(list) != 2 {
+		t.Fatalf("bob has %d chirps, want 2", len(list))
+	}
+	for _, chirp := range list {
+		if chirp.ReferencedChirp == nil || !chirp.ReferencedChirp.Unavailable || chirp.ReferencedChirp.Chirp != nil {
+			t.Errorf("%s referenced_chirp = %+v, want unavailable", chirp.Kind, chirp.ReferencedChirp)
+		}
+	}
+}
+
+func TestExtractHashtags(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{name: "No tags", body: "just a chirp", want: nil},
+		{name: "Lowercased and deduplicated", body: "#Go is great, #go #GO #golang", want: []string{"go", "golang"}},
+		{name: "Unicode letters", body: "Привет #Мир", want: []string{"мир"}},
+		{name: "Stops at punctuation", body: "#go! #rust.", want: []string{"go", "rust"}},
+		{name: "Not at a word start", body: "a#b &#39; ##x", want: nil},
+		{name: "Numbers only", body: "#1 #2024", want: nil},
+		{name: "Too long", body: "#" + strings.Repeat("a", 51), want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractHashtags(tt.body); !slices.Equal(got, tt.want) {
+				t.Errorf("extractHashtags(%q) = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerTags(t *testing.T) {
+	_, srv := newTestServer(t)
+	alice := createUser(t, srv, "alice@example.com", "password")
+	bob := createUser(t, srv, "bob@example.com", "password")
+	first := createChirp(t, srv, alice.Token, "Hello #Go")
+	second := createChirp(t, srv, bob.Token, "#go and #rust")
+	deleted := createChirp(t, srv, bob.Token, "#rust #rust #zig")
+	if code, _ := doRequest(t, srv, "DELETE", "/api/chirps/"+deleted.ID.String(), "Bearer "+bob.Token, nil); code != http.StatusNoContent {
+		t.Fatalf("deleting chirp = %d, want %d", code, http.StatusNoContent)
+	}
+
+	feeds := []struct {
+		name     string
+		path     string
+		wantCode int
+		wantIDs  []uuid.UUID
+	}{
+		{name: "Newest first", path: "/api/tags/go/chirps", wantCode: http.StatusOK, wantIDs: []uuid.UUID{second.ID, first.ID}},
+		{name: "Case and # are ignored", path: "/api/tags/%23GO/chirps", wantCode: http.StatusOK, wantIDs: []uuid.UUID{second.ID, first.ID}},
+		{name: "Deleted chirps are left out", path: "/api/tags/zig/chirps", wantCode: http.StatusOK, wantIDs: []uuid.UUID{}},
+		{name: "Unknown tag", path: "/api/tags/python/chirps", wantCode: http.StatusOK, wantIDs: []uuid.UUID{}},
+		{name: "Invalid tag", path: "/api/tags/no-dashes/chirps", wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range feeds {
+		t.Run(tt.name, func(t *testing.T) {
+			code, dat := doRequest(t, srv, "GET", tt.path, "", nil)
+			if code != tt.wantCode {
+				t.Fatalf("GET %s = %d, want %d: %s", tt.path, code, tt.wantCode, dat)
+			}
+			if code != http.StatusOK {
+				return
+			}
+			got := []uuid.UUID{}
+			for _, chirp := range decodeJSON[[]Chirp](t, dat) {
+				got = append(got, chirp.ID)
+			}
+			if !slices.Equal(got, tt.wantIDs) {
+				t.Errorf("chirps = %v, want %v", got, tt.wantIDs)
+			}
+		})
+	}
+
+	trending := []struct {
+		name     string
+		query    string
+		wantCode int
+		wantTags []string
+	}{
+		{name: "Ranked by uses", query: "", wantCode: http.StatusOK, wantTags: []string{"go", "rust"}},
+		{name: "Limited", query: "?limit=1", wantCode: http.StatusOK, wantTags: []string{"go"}},
+		{name: "Custom window", query: "?window=1h", wantCode: http.StatusOK, wantTags: []string{"go", "rust"}},
+		{name: "Invalid window", query: "?window=soon", wantCode: http.StatusBadRequest},
+		{name: "Window too long", query: "?window=720h", wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range trending {
+		t.Run(tt.name, func(t *testing.T) {
+			code, dat := doRequest(t, srv, "GET", "/api/tags/trending"+tt.query, "", nil)
+			if code != tt.wantCode {
+				t.Fatalf("GET /api/tags/trending%s = %d, want %d: %s", tt.query, code, tt.wantCode, dat)
+			}
+			if code != http.StatusOK {
+				return
+			}
+			got := []string{}
+			for _, tag := range decodeJSON[[]TrendingTag](t, dat) {
+				got = append(got, tag.Tag)
+			}
+			if !slices.Equal(got, tt.wantTags) {
+				t.Errorf("trending tags = %q, want %q", got, tt.wantTags)
+			}
+		})
+	}
+}
+
+func TestExtractMentions(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{name: "No mentions", body: "hello world", want: nil},
+		{name: "Lowercased and deduplicated", body: "@Alice hi @alice and @bob_1", want: []string{"alice", "bob_1"}},
+		{name: "Email addresses", body: "mail alice@example.com", want: nil},
+		{name: "Stops at punctuation", body: "(@alice), @bob!", want: []string{"alice", "bob"}},
+		{name: "Not a handle", body: "@ab @" + strings.Repeat("a", 16), want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractMentions(tt.body); !slices.Equal(got, tt.want) {
+				t.Errorf("extractMentions(%q) = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerNotifications(t *testing.T) {
+	_, srv := newTestServer(t)
+	alice := createUser(t, srv, "alice@example.com", "password")
+	bob := createUser(t, srv, "bob@example.com", "password")
+	carol := createUser(t, srv, "carol@example.com", "password")
+
+	first := createChirp(t, srv, bob.Token, "hey @Alice and @nobody")
+	createChirp(t, srv, alice.Token, "talking to myself @alice")
+	second := createChirp(t, srv, carol.Token, "@alice @alice @bob")
+	deleted := createChirp(t, srv, carol.Token, "@alice never mind")
+	if code, _ := doRequest(t, srv, "DELETE", "/api/chirps/"+deleted.ID.String(), "Bearer "+carol.Token, nil); code != http.StatusNoContent {
+		t.Fatalf("deleting chirp = %d, want %d", code, http.StatusNoContent)
+	}
+
+	list := func(t *testing.T, token, query string) []Notification {
+		t.Helper()
+		code, dat := doRequest(t, srv, "GET", "/api/notifications"+query, "Bearer "+token, nil)
+		if code != http.StatusOK {
+			t.Fatalf("GET /api/notifications%s = %d: %s", query, code, dat)
+		}
+		return decodeJSON[[]Notification](t, dat)
+	}
+
+	if code, _ := doRequest(t, srv, "GET", "/api/notifications", "", nil); code != http.StatusUnauthorized {
+		t.Errorf("GET /api/notifications without a token = %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	got := list(t, alice.Token, "")
+	if len(got) != 2 || got[0].ChirpID != second.ID || got[1].ChirpID != first.ID {
+		t.Fatalf("alice's notifications = %+v, want mentions in %v and %v", got, second.ID, first.ID)
+	}
+	if got[0].Kind != "mention" || got[0].ActorID != carol.ID || got[0].ActorHandle != "carol" || got[0].Read {
+		t.Errorf("notification = %+v, want an unread mention by carol", got[0])
+	}
+	if got := list(t, bob.Token, ""); len(got) != 1 || got[0].ChirpID != second.ID {
+		t.Errorf("bob's notifications = %+v, want one mention in %v", got, second.ID)
+	}
+
+	tests := []struct {
+		name       string
+		body       any
+		wantCode   int
+		wantUnread int
+	}{
+		{name: "Malformed JSON", body: "{", wantCode: http.StatusBadRequest, wantUnread: 2},
+		{name: "Someone else's notification", body: map[string]any{"ids": []uuid.UUID{uuid.New()}}, wantCode: http.StatusNoContent, wantUnread: 2},
+		{name: "By ID", body: map[string]any{"ids": []uuid.UUID{got[1].ID}}, wantCode: http.StatusNoContent, wantUnread: 1},
+		{name: "All", body: nil, wantCode: http.StatusNoContent, wantUnread: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, dat := doRequest(t, srv, "POST", "/api/notifications/read", "Bearer "+alice.Token, tt.body)
+			if code != tt.wantCode {
+				t.Fatalf("POST /api/notifications/read = %d, want %d: %s", code, tt.wantCode, dat)
+			}
+			if unread := list(t, alice.Token, "?unread=true"); len(unread) != tt.wantUnread {
+				t.Errorf("unread notifications = %d, want %d", len(unread), tt.wantUnread)
+			}
+		})
+	}
+
+	if got := list(t, bob.Token, "?unread=true"); len(got) != 1 {
+		t.Errorf("bob's unread notifications = %d, want 1", len(got))
+	}
+}
+
+func TestHandlerSessions(t *testing.T) {
+	_, srv := newTestServer(t)
+	user := createUser(t, srv, "user@example.com", "password")
+	other := createUser(t, srv, "other@example.com", "password")
+	code, dat := doRequest(t, srv, "POST", "/api/login", "", map[string]string{"email": "user@example.com", "password": "password"})
+	if code != http.StatusOK {
+		t.Fatalf("second login = %d: %s", code, dat)
+	}
+	second := decodeJSON[loginResponse](t, dat)
+
+	listSessions := func(t *testing.T, token string) []Session {
+		t.Helper()
+		code, dat := doRequest(t, srv, "GET", "/api/sessions", "Bearer "+token, nil)
+		if code != http.StatusOK {
+			t.Fatalf("GET /api/sessions = %d: %s", code, dat)
+		}
+		return decodeJSON[[]Session](t, dat)
+	}
+	refresh := func(t *testing.T, token string) (int, string) {
+		t.Helper()
+		code, dat := doRequest(t, srv, "POST", "/api/refresh", "Bearer "+token, nil)
+		if code != http.StatusOK {
+			return code, ""
+		}
+		return code, decodeJSON[loginResponse](t, dat).RefreshToken
+	}
+
+	before := listSessions(t, user.Token)
+	code, refreshed := refresh(t, user.RefreshToken)
+	if code != http.StatusOK {
+		t.Fatalf("refresh = %d, want %d", code, http.StatusOK)
+	}
+	sessions := listSessions(t, user.Token)
+	if len(before) != 2 || len(sessions) != 2 {
+		t.Fatalf("sessions before and after a refresh = %d and %d, want 2", len(before), len(sessions))
+	}
+	ids := []uuid.UUID{sessions[0].ID, sessions[1].ID}
+	for _, session := range before {
+		if !slices.Contains(ids, session.ID) {
+			t.Errorf("session %v changed its ID on refresh", session.ID)
+		}
+		if session.IP != "127.0.0.1" || !strings.HasPrefix(session.UserAgent, "Go-http-client") {
+			t.Errorf("session device = %q from %q, want the test client", session.UserAgent, session.IP)
+		}
+	}
+	if sessions[0].LastUsedAt.Before(sessions[0].CreatedAt) || !sessions[0].ExpiresAt.After(sessions[0].LastUsedAt) {
+		t.Errorf("session times = %+v, want created <= last used < expires", sessions[0])
+	}
+
+	// The second login is the session that wasn't refreshed.
+	var secondID uuid.UUID
+	for _, session := range sessions {
+		if session.LastUsedAt.Equal(session.CreatedAt) {
+			secondID = session.ID
+		}
+	}
+
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		wantCode      int
+	}{
+		{name: "Missing token", path: "/api/sessions/" + secondID.String(), wantCode: http.StatusUnauthorized},
+		{name: "Invalid ID", path: "/api/sessions/nope", authorization: "Bearer " + user.Token, wantCode: http.StatusBadRequest},
+		{name: "Someone else's session", path: "/api/sessions/" + secondID.String(), authorization: "Bearer " + other.Token, wantCode: http.StatusNotFound},
+		{name: "Own session", path: "/api/sessions/" + secondID.String(), authorization: "Bearer " + user.Token, wantCode: http.StatusNoContent},
+		{name: "Already revoked", path: "/api/sessions/" + secondID.String(), authorization: "Bearer " + user.Token, wantCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, dat := doRequest(t, srv, "DELETE", tt.path, tt.authorization, nil)
+			if code != tt.wantCode {
+				t.Fatalf("DELETE %s = %d, want %d: %s", tt.path, code, tt.wantCode, dat)
+			}
+		})
+	}
+
+	if code, _ := refresh(t, second.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("refreshing a revoked session = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if got := listSessions(t, user.Token); len(got) != 1 {
+		t.Errorf("sessions after revoking one = %d, want 1", len(got))
+	}
+
+	if code, dat := doRequest(t, srv, "DELETE", "/api/sessions", "Bearer "+user.Token, nil); code != http.StatusNoContent {
+		t.Fatalf("DELETE /api/sessions = %d: %s", code, dat)
+	}
+	if code, _ := refresh(t, refreshed); code != http.StatusUnauthorized {
+		t.Errorf("refreshing after logging out everywhere = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if got := listSessions(t, user.Token); len(got) != 0 {
+		t.Errorf("sessions after logging out everywhere = %d, want 0", len(got))
+	}
+	if got := listSessions(t, other.Token); len(got) != 1 {
+		t.Errorf("other user's sessions = %d, want 1", len(got))
+	}
+}
+
+func TestHandlerJWKS(t *testing.T) {
+	_, srv := newTestServer(t)
+	user := createUser(t, srv, "user@example.com", "password")
+
+	code, dat := doRequest(t, srv, "GET", "/.well-known/jwks.json", "", nil)
+	if code != http.StatusOK {
+		t.Fatalf("GET /.well-known/jwks.json = %d: %s", code, dat)
+	}
+	jwks := decodeJSON[auth.JWKS](t, dat)
+	if len(jwks.Keys) != 1 || jwks.Keys[0].Kty != "OKP" || jwks.Keys[0].Alg != "EdDSA" {
+		t.Fatalf("JWKS = %s, want the Ed25519 signing key only", dat)
+	}
+
+	// Verify the access token the way another service would: with nothing
+	// but the published key.
+	x, err := base64.RawURLEncoding.DecodeString(jwks.Keys[0].X)
+	if err != nil {
+		t.Fatalf("decoding x: %v", err)
+	}
+	token, err := jwt.Parse(user.Token, func(token *jwt.Token) (any, error) {
+		if token.Header["kid"] != jwks.Keys[0].Kid {
+			return nil, fmt.Errorf("kid = %v, want %s", token.Header["kid"], jwks.Keys[0].Kid)
+		}
+		return ed25519.PublicKey(x), nil
+	}, jwt.WithValidMethods([]string{"EdDSA"}))
+	if err != nil || !token.Valid {
+		t.Errorf("verifying the access token with the JWKS: %v", err)
+	}
+
+	// Tokens signed with the old HMAC secret still work.
+	legacy, err := auth.MakeJWT(user.ID, auth.RoleUser, testSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT() error = %v", err)
+	}
+	if code, dat := doRequest(t, srv, "GET", "/api/sessions", "Bearer "+legacy, nil); code != http.StatusOK {
+		t.Errorf("request with an HMAC token = %d: %s", code, dat)
+	}
+}
+
+func TestNewKeyring(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key_file := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(key_file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	user_id := uuid.New()
+	hs256, err := auth.NewKeyring(auth.NewHMACKey(testSecret)).MakeJWT(user_id, auth.RoleAdmin, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		keyFiles   string
+		hs256Until string
+		wantHS256  bool
+	}{
+		{name: "HMAC only", wantHS256: true},
+		{name: "Key files without opt-in", keyFiles: key_file, wantHS256: false},
+		{name: "Opted in", keyFiles: key_file, hs256Until: time.Now().Add(time.Hour).Format(time.RFC3339), wantHS256: true},
+		{name: "Opt-in has passed", keyFiles: key_file, hs256Until: time.Now().Add(-time.Hour).Format(time.RFC3339), wantHS256: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyring, err := newKeyring(testSecret, tt.keyFiles, tt.hs256Until)
+			if err != nil {
+				t.Fatalf("newKeyring() error = %v", err)
+			}
+			if _, err := keyring.ValidateJWT(hs256); (err == nil) != tt.wantHS256 {
+				t.Errorf("ValidateJWT(HS256 token) error = %v, want accepted %v", err, tt.wantHS256)
+			}
+		})
+	}
+
+	if _, err := newKeyring(testSecret, key_file, "next week"); err == nil {
+		t.Errorf("newKeyring() accepted a malformed JWT_ACCEPT_HS256_UNTIL")
+	}
+}
+
+func TestHandlerVerifyEmail(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	verified := createUser(t, srv, "verified@example.com", "password")
+
+	params := map[string]string{"email": "new@example.com", "password": "password", "handle": "new"}
+	code, dat := doRequest(t, srv, "POST", "/api/users", "", params)
+	if code != http.StatusCreated {
+		t.Fatalf("POST /api/users = %d: %s", code, dat)
+	}
+	if decodeJSON[User](t, dat).EmailVerified {
+		t.Errorf("a new user is already verified")
+	}
+	code, dat = doRequest(t, srv, "POST", "/api/login", "", params)
+	if code != http.StatusOK {
+		t.Fatalf("POST /api/login = %d: %s", code, dat)
+	}
+	user := decodeJSON[loginResponse](t, dat)
+	firstToken := mailedToken(t, srv, "new@example.com")
+
+	postChirp := func(t *testing.T, want int) {
+		t.Helper()
+		code, dat := doRequest(t, srv, "POST", "/api/chirps", "Bearer "+user.Token, map[string]string{"body": "hello"})
+		if code != want {
+			t.Errorf("POST /api/chirps = %d, want %d: %s", code, want, dat)
+		}
+	}
+	postChirp(t, http.StatusForbidden)
+
+	if code, _ := doRequest(t, srv, "POST", "/api/users/verify/resend", "Bearer "+verified.Token, nil); code != http.StatusConflict {
+		t.Errorf("resending to a verified user = %d, want %d", code, http.StatusConflict)
+	}
+	if code, dat := doRequest(t, srv, "POST", "/api/users/verify/resend", "Bearer "+user.Token, nil); code != http.StatusNoContent {
+		t.Fatalf("resending = %d: %s", code, dat)
+	}
+	secondToken := mailedToken(t, srv, "new@example.com")
+
+	tests := []struct {
+		name     string
+		body     any
+		wantCode int
+	}{
+		{name: "Malformed JSON", body: "{", wantCode: http.StatusBadRequest},
+		{name: "Unknown token", body: map[string]string{"token": "nope"}, wantCode: http.StatusBadRequest},
+		{name: "First token", body: map[string]string{"token": firstToken}, wantCode: http.StatusOK},
+		{name: "Used token", body: map[string]string{"token": firstToken}, wantCode: http.StatusBadRequest},
+		{name: "Second token", body: map[string]string{"token": secondToken}, wantCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, dat := doRequest(t, srv, "POST", "/api/users/verify", "", tt.body)
+			if code != tt.wantCode {
+				t.Fatalf("POST /api/users/verify = %d, want %d: %s", code, tt.wantCode, dat)
+			}
+			if code == http.StatusOK && !decodeJSON[User](t, dat).EmailVerified {
+				t.Errorf("POST /api/users/verify returned an unverified user: %s", dat)
+			}
+		})
+	}
+	chirp := createChirp(t, srv, user.Token, "hello")
+	if _, err := cfg.db.UpdateSubscription(context.Background(), database.UpdateSubscriptionParams{ID: user.ID}); err != nil {
+		t.Fatal(err)
+	}
+	editChirp := func(t *testing.T, want int) {
+		t.Helper()
+		path := "/api/chirps/" + chirp.ID.String()
+		code, dat := doRequest(t, srv, "PUT", path, "Bearer "+user.Token, map[string]string{"body": "edited"})
+		if code != want {
+			t.Errorf("PUT %s = %d, want %d: %s", path, code, want, dat)
+		}
+	}
+
+	// Changing the address needs a new verification, and a token for the
+	// old address can't be used for it.
+	edit := map[string]string{"email": "changed@example.com", "password": "password"}
+	code, dat = doRequest(t, srv, "PUT", "/api/users", "Bearer "+user.Token, edit)
+	if code != http.StatusOK || decodeJSON[User](t, dat).EmailVerified {
+		t.Fatalf("changing email = %d %s, want an unverified user", code, dat)
+	}
+	postChirp(t, http.StatusForbidden)
+	editChirp(t, http.StatusForbidden)
+	if code, dat := doRequest(t, srv, "POST", "/api/users/verify", "", map[string]string{"token": mailedToken(t, srv, "changed@example.com")}); code != http.StatusOK {
+		t.Errorf("verifying the new address = %d: %s", code, dat)
+	}
+	postChirp(t, http.StatusCreated)
+	editChirp(t, http.StatusOK)
+}
+
+func TestHandlerPasswordReset(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	user := createUser(t, srv, "user@example.com", "password")
+	other := createUser(t, srv, "other@example.com", "password")
+
+	forgot := func(t *testing.T, email string) {
+		t.Helper()
+		code, dat := doRequest(t, srv, "POST", "/api/password/forgot", "", map[string]string{"email": email})
+		if code != http.StatusAccepted {
+			t.Fatalf("POST /api/password/forgot for %s = %d, want %d: %s", email, code, http.StatusAccepted, dat)
+		}
+		// The email is sent after the response.
+		cfg.mail_jobs.Wait()
+	}
+	forgot(t, "nobody@example.com")
+	forgot(t, "user@example.com")
+	first := mailedToken(t, srv, "user@example.com")
+	forgot(t, "user@example.com")
+	second := mailedToken(t, srv, "user@example.com")
+
+	tests := []struct {
+		name     string
+		body     any
+		wantCode int
+	}{
+		{name: "Malformed JSON", body: "{", wantCode: http.StatusBadRequest},
+		{name: "Unknown token", body: map[string]string{"token": "nope", "password": "new"}, wantCode: http.StatusBadRequest},
+		{name: "Empty password", body: map[string]string{"token": first, "password": ""}, wantCode: http.StatusBadRequest},
+		{name: "Valid token", body: map[string]string{"token": first, "password": "new"}, wantCode: http.StatusNoContent},
+		{name: "Used token", body: map[string]string{"token": first, "password": "newer"}, wantCode: http.StatusBadRequest},
+		{name: "Token requested before the reset", body: map[string]string{"token": second, "password": "newer"}, wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, dat := doRequest(t, srv, "POST", "/api/password/reset", "", tt.body)
+			if code != tt.wantCode {
+				t.Fatalf("POST /api/password/reset = %d, want %d: %s", code, tt.wantCode, dat)
+			}
+		})
+	}
+
+	logins := []struct {
+		email    string
+		password string
+		wantCode int
+	}{
+		{email: "user@example.com", password: "password", wantCode: http.StatusUnauthorized},
+		{email: "user@example.com", password: "new", wantCode: http.StatusOK},
+		{email: "other@example.com", password: "password", wantCode: http.StatusOK},
+	}
+	for _, login := range logins {
+		code, _ := doRequest(t, srv, "POST", "/api/login", "", map[string]string{"email": login.email, "password": login.password})
+		if code != login.wantCode {
+			t.Errorf("logging in as %s with %q = %d, want %d", login.email, login.password, code, login.wantCode)
+		}
+	}
+
+	refreshes := []struct {
+		token    string
+		wantCode int
+	}{
+		{token: user.RefreshToken, wantCode: http.StatusUnauthorized},
+		{token: other.RefreshToken, wantCode: http.StatusOK},
+	}
+	for _, refresh := range refreshes {
+		if code, _ := doRequest(t, srv, "POST", "/api/refresh", "Bearer "+refresh.token, nil); code != refresh.wantCode {
+			t.Errorf("refreshing after the reset = %d, want %d", code, refresh.wantCode)
+		}
+	}
+
+	// An address gets a few reset emails an hour, registered or not.
+	for _, email := range []string{"other@example.com", "someone@example.com"} {
+		for range passwordResetPolicy.FreeAttempts + 1 {
+			forgot(t, email)
+		}
+		upper := strings.ToUpper(email)
+		code, dat := doRequest(t, srv, "POST", "/api/password/forgot", "", map[string]string{"email": upper})
+		if code != http.StatusTooManyRequests {
+			t.Errorf("POST /api/password/forgot for %s over the limit = %d, want %d: %s", upper, code, http.StatusTooManyRequests, dat)
+		}
+	}
+}
+
+func TestHandlerTOTP(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	user := createUser(t, srv, "user@example.com", "password")
+	bearer := "Bearer " + user.Token
+
+	if code, _ := doRequest(t, srv, "POST", "/api/users/totp", "", nil); code != http.StatusUnauthorized {
+		t.Errorf("enrolling without a token = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := doRequest(t, srv, "POST", "/api/users/totp/confirm", bearer, map[string]string{"code": "000000"}); code != http.StatusBadRequest {
+		t.Errorf("confirming before enrolling = %d, want %d", code, http.StatusBadRequest)
+	}
+
+	code, dat := doRequest(t, srv, "POST", "/api/users/totp", bearer, nil)
+	if code != http.StatusCreated {
+		t.Fatalf("POST /api/users/totp = %d, want %d: %s", code, http.StatusCreated, dat)
+	}
+	enrollment := decodeJSON[struct {
+		Secret     string `json:"secret"`
+		OtpauthURI string `json:"otpauth_uri"`
+	}](t, dat)
+	if !strings.HasPrefix(enrollment.OtpauthURI, "otpauth://totp/Chirpy:user@example.com?") || !strings.Contains(enrollment.OtpauthURI, "secret="+enrollment.Secret) {
+		t.Errorf("otpauth_uri = %q, want a TOTP URI for user@example.com with the secret", enrollment.OtpauthURI)
+	}
+	totpCode := func(at time.Time) string {
+		t.Helper()
+		code, err := auth.TOTPCode(enrollment.Secret, at)
+		if err != nil {
+			t.Fatalf("TOTPCode() error = %v", err)
+		}
+		return code
+	}
+
+	// Until enrollment is confirmed, logging in takes only the password.
+	login := map[string]string{"email": "user@example.com", "password": "password"}
+	if code, dat := doRequest(t, srv, "POST", "/api/login", "", login); code != http.StatusOK || decodeJSON[loginResponse](t, dat).Token == "" {
+		t.Fatalf("logging in before confirming = %d, want %d with tokens: %s", code, http.StatusOK, dat)
+	}
+
+	if code, _ := doRequest(t, srv, "POST", "/api/users/totp/confirm", bearer, map[string]string{"code": "abcdef"}); code != http.StatusBadRequest {
+		t.Errorf("confirming with a wrong code = %d, want %d", code, http.StatusBadRequest)
+	}
+	confirmCode := totpCode(time.Now())
+	code, dat = doRequest(t, srv, "POST", "/api/users/totp/confirm", bearer, map[string]string{"code": confirmCode})
+	if code != http.StatusOK {
+		t.Fatalf("POST /api/users/totp/confirm = %d, want %d: %s", code, http.StatusOK, dat)
+	}
+	recoveryCodes := decodeJSON[struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}](t, dat).RecoveryCodes
+	if len(recoveryCodes) != 10 {
+		t.Fatalf("got %d recovery codes, want 10", len(recoveryCodes))
+	}
+	if code, _ := doRequest(t, srv, "POST", "/api/users/totp", bearer, nil); code != http.StatusConflict {
+		t.Errorf("enrolling again = %d, want %d", code, http.StatusConflict)
+	}
+
+	challenge := func(t *testing.T) string {
+		t.Helper()
+		code, dat := doRequest(t, srv, "POST", "/api/login", "", login)
+		resp := decodeJSON[struct {
+			MFARequired bool   `json:"mfa_required"`
+			MFAToken    string `json:"mfa_token"`
+			Token       string `json:"token"`
+		}](t, dat)
+		if code != http.StatusOK || !resp.MFARequired || resp.MFAToken == "" || resp.Token != "" {
+			t.Fatalf("POST /api/login = %d %s, want an MFA challenge and no access token", code, dat)
+		}
+		return resp.MFAToken
+	}
+	mfaToken := challenge(t)
+	if code, _ := doRequest(t, srv, "GET", "/api/sessions", "Bearer "+mfaToken, nil); code != http.StatusUnauthorized {
+		t.Errorf("using the MFA challenge as an access token = %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	nextCode := totpCode(time.Now().Add(30 * time.Second))
+	tests := []struct {
+		name     string
+		params   map[string]string
+		wantCode int
+	}{
+		{name: "No code", params: map[string]string{"mfa_token": mfaToken}, wantCode: http.StatusBadRequest},
+		{name: "Invalid challenge", params: map[string]string{"mfa_token": user.Token, "code": nextCode}, wantCode: http.StatusUnauthorized},
+		{name: "Wrong code", params: map[string]string{"mfa_token": mfaToken, "code": "abcdef"}, wantCode: http.StatusUnauthorized},
+		{name: "Code used to confirm", params: map[string]string{"mfa_token": mfaToken, "code": confirmCode}, wantCode: http.StatusUnauthorized},
+		{name: "Valid code", params: map[string]string{"mfa_token": mfaToken, "code": nextCode}, wantCode: http.StatusOK},
+		{name: "Replayed code", params: map[string]string{"mfa_token": challenge(t), "code": nextCode}, wantCode: http.StatusUnauthorized},
+		{name: "Wrong recovery code", params: map[string]string{"mfa_token": mfaToken, "recovery_code": "aaaaa-aaaaa"}, wantCode: http.StatusUnauthorized},
+		{name: "Recovery code", params: map[string]string{"mfa_token": mfaToken, "recovery_code": strings.ToUpper(recoveryCodes[0])}, wantCode: http.StatusOK},
+		{name: "Used recovery code", params: map[string]string{"mfa_token": mfaToken, "recovery_code": recoveryCodes[0]}, wantCode: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, dat := doRequest(t, srv, "POST", "/api/login/mfa", "", tt.params)
+			if code != tt.wantCode {
+				t.Fatalf("POST /api/login/mfa = %d, want %d: %s", code, tt.wantCode, dat)
+			}
+			if code == http.StatusOK {
+				resp := decodeJSON[loginResponse](t, dat)
+				if resp.Token == "" || resp.RefreshToken == "" || resp.ID != user.ID {
+					t.Errorf("POST /api/login/mfa = %s, want tokens for %s", dat, user.ID)
+				}
+			}
+		})
+	}
+
+	// With only the access token and the password, the password can't be
+	// changed to get around the second factor.
+	edit := map[string]string{"email": "user@example.com", "password": "new-password", "current_password": "password"}
+	if code, _ := doRequest(t, srv, "PUT", "/api/users", bearer, edit); code != http.StatusUnauthorized {
+		t.Errorf("changing the password without a code = %d, want %d", code, http.StatusUnauthorized)
+	}
+	edit["code"] = "abcdef"
+	if code, _ := doRequest(t, srv, "PUT", "/api/users", bearer, edit); code != http.StatusUnauthorized {
+		t.Errorf("changing the password with a wrong code = %d, want %d", code, http.StatusUnauthorized)
+	}
+	delete(edit, "code")
+	edit["recovery_code"] = recoveryCodes[1]
+	if code, dat := doRequest(t, srv, "PUT", "/api/users", bearer, edit); code != http.StatusOK {
+		t.Fatalf("changing the password with a recovery code = %d, want %d: %s", code, http.StatusOK, dat)
+	}
+	login["password"] = "new-password"
+
+	if code, _ := doRequest(t, srv, "DELETE", "/api/users/totp", bearer, map[string]string{"password": "wrong"}); code != http.StatusUnauthorized {
+		t.Errorf("disabling with the wrong password = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, dat := doRequest(t, srv, "DELETE", "/api/users/totp", bearer, map[string]string{"password": "new-password"}); code != http.StatusNoContent {
+		t.Fatalf("DELETE /api/users/totp = %d, want %d: %s", code, http.StatusNoContent, dat)
+	}
+	if code, dat := doRequest(t, srv, "POST", "/api/login", "", login); code != http.StatusOK || decodeJSON[loginResponse](t, dat).Token == "" {
+		t.Errorf("logging in after disabling = %d, want %d with tokens: %s", code, http.StatusOK, dat)
+	}
+
+	// Password guesses here count toward the login lockout.
+	cfg.account_lockout = lockout.NewLimiter(lockout.NewMemoryStore(), lockout.Policy{FreeAttempts: 2, BaseDelay: time.Minute, MaxDelay: time.Hour, ResetAfter: time.Hour})
+	for range 3 {
+		if code, _ := doRequest(t, srv, "DELETE", "/api/users/totp", bearer, map[string]string{"password": "wrong"}); code != http.StatusUnauthorized {
+			t.Fatalf("guessing the password = %d, want %d", code, http.StatusUnauthorized)
+		}
+	}
+	if code, _ := doRequest(t, srv, "DELETE", "/api/users/totp", bearer, map[string]string{"password": "new-password"}); code != http.StatusTooManyRequests {
+		t.Errorf("disabling after too many guesses = %d, want %d", code, http.StatusTooManyRequests)
+	}
+}
+
+func TestHandlerLoginLockout(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	createUser(t, srv, "user@example.com", "password")
+	createUser(t, srv, "other@example.com", "password")
+	lockouts := lockout.NewMemoryStore()
+	cfg.account_lockout = lockout.NewLimiter(lockouts, lockout.Policy{FreeAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour, ResetAfter: time.Hour})
+	cfg.ip_lockout = lockout.NewLimiter(lockouts, lockout.Policy{FreeAttempts: 12, BaseDelay: time.Minute, MaxDelay: time.Hour, ResetAfter: time.Hour})
+
+	login := func(t *testing.T, email, password string, wantCode int) *http.Response {
+		t.Helper()
+		dat, _ := json.Marshal(map[string]string{"email": email, "password": password})
+		resp, err := http.Post(srv.URL+"/api/login", "application/json", bytes.NewReader(dat))
+		if err != nil {
+			t.Fatalf("POST /api/login: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != wantCode {
+			t.Fatalf("logging in as %s with %q = %d, want %d: %s", email, password, resp.StatusCode, wantCode, body)
+		}
+		if wantCode == http.StatusUnauthorized && !bytes.Contains(body, []byte("Incorrect email or password")) {
+			t.Errorf("failed login body = %s, want the generic error", body)
+		}
+		return resp
+	}
+
+	// A successful login clears the account's failures.
+	for range 3 {
+		login(t, "user@example.com", "wrong", http.StatusUnauthorized)
+	}
+	login(t, "user@example.com", "password", http.StatusOK)
+	for range 4 {
+		login(t, "user@example.com", "wrong", http.StatusUnauthorized)
+	}
+
+	resp := login(t, "user@example.com", "password", http.StatusTooManyRequests)
+	if got := resp.Header.Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want %q", got, "60")
+	}
+	// Unknown emails are locked out the same way, so lockouts don't reveal
+	// which accounts exist.
+	for range 4 {
+		login(t, "nobody@example.com", "wrong", http.StatusUnauthorized)
+	}
+	login(t, "nobody@example.com", "wrong", http.StatusTooManyRequests)
+
+	// Other accounts are unaffected until the address runs out of attempts.
+	login(t, "other@example.com", "password", http.StatusOK)
+	for range 2 {
+		login(t, "other@example.com", "wrong", http.StatusUnauthorized)
+	}
+	resp = login(t, "other@example.com", "password", http.StatusTooManyRequests)
+	if got := resp.Header.Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After for a locked out address = %q, want %q", got, "60")
+	}
+}
+
+func TestHandlerLoginLockoutParallel(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	createUser(t, srv, "user@example.com", "password")
+	cfg.account_lockout = lockout.NewLimiter(lockout.NewMemoryStore(), lockout.Policy{FreeAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour, ResetAfter: time.Hour})
+
+	// Every guess is counted before its password is checked, so guesses
+	// sent at once can't all get past the lockout.
+	codes := make([]int, 20)
+	var wg sync.WaitGroup
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i], _ = doRequest(t, srv, "POST", "/api/login", "", map[string]string{"email": "user@example.com", "password": "wrong"})
+		}()
+	}
+	wg.Wait()
+
+	counts := map[int]int{}
+	for _, code := range codes {
+		counts[code]++
+	}
+	if counts[http.StatusUnauthorized] != 4 || counts[http.StatusTooManyRequests] != 16 {
+		t.Errorf("parallel guesses got %v, want 4 x 401 and 16 x 429", counts)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+	if err != nil {
+		t.Fatalf("parseTrustedProxies() error = %v", err)
+	}
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		trustProxies bool
+		want         string
+	}{
+		{
+			name:         "No trusted proxies",
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"203.0.113.7"},
+			want:         "10.0.0.1",
+		},
+		{
+			name:         "From a trusted proxy",
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"203.0.113.7"},
+			trustProxies: true,
+			want:         "203.0.113.7",
+		},
+		{
+			name:         "From an untrusted address",
+			remoteAddr:   "198.51.100.2:1234",
+			forwardedFor: []string{"203.0.113.7"},
+			trustProxies: true,
+			want:         "198.51.100.2",
+		},
+		{
+			name:         "Spoofed hops are skipped",
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"1.2.3.4, 203.0.113.7", "192.0.2.1"},
+			trustProxies: true,
+			want:         "203.0.113.7",
+		},
+		{
+			name:         "Malformed hop",
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"203.0.113.7, nonsense, 10.1.1.1"},
+			trustProxies: true,
+			want:         "10.1.1.1",
+		},
+		{
+			name:         "No header",
+			remoteAddr:   "192.0.2.1:1234",
+			trustProxies: true,
+			want:         "192.0.2.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &apiConfig{}
+			if tt.trustProxies {
+				cfg.trusted_proxies = proxies
+			}
+			r := httptest.NewRequest("POST", "/api/login", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := cfg.clientIP(r); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := parseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Errorf("parseTrustedProxies() with a bad range succeeded")
+	}
+}
+
+func TestHandlerLoginRehash(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	bcryptHash, err := auth.Bcrypt{Cost: bcrypt.MinCost}.Hash("password")
+	if err != nil {
+		t.Fatalf("hashing with bcrypt: %v", err)
+	}
+	user, err := cfg.db.CreateUser(context.Background(), database.CreateUserParams{
+		Email:          "legacy@example.com",
+		HashedPassword: bcryptHash,
+		Handle:         "legacy",
+	})
+	if err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+
+	login := map[string]string{"email": "legacy@example.com", "password": "password"}
+	for range 2 {
+		if code, dat := doRequest(t, srv, "POST", "/api/login", "", login); code != http.StatusOK {
+			t.Fatalf("logging in with a bcrypt hash = %d, want %d: %s", code, http.StatusOK, dat)
+		}
+		stored, err := cfg.db.GetUserByID(context.Background(), user.ID)
+		if err != nil {
+			t.Fatalf("GetUserByID() error = %v", err)
+		}
+		if !strings.HasPrefix(stored.HashedPassword, "$argon2id$") {
+			t.Errorf("stored hash after logging in = %q, want an argon2id hash", stored.HashedPassword)
+		}
+	}
+	if code, _ := doRequest(t, srv, "POST", "/api/login", "", map[string]string{"email": "legacy@example.com", "password": "wrong"}); code != http.StatusUnauthorized {
+		t.Errorf("logging in with the wrong password after rehashing = %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	// bcrypt ignored everything past 72 bytes; argon2id doesn't.
+	long := strings.Repeat("a", 72)
+	createUser(t, srv, "long@example.com", long+"1")
+	if code, _ := doRequest(t, srv, "POST", "/api/login", "", map[string]string{"email": "long@example.com", "password": long + "2"}); code != http.StatusUnauthorized {
+		t.Errorf("logging in with a password differing past 72 bytes = %d, want %d", code, http.StatusUnauthorized)
+	}
+}
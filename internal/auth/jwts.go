@@ -6,47 +6,22 @@ import (
 	"time"
 	"strings"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
 const (
-	TokenTypeAccess = "chirpy-access"
+	TokenTypeAccess       = "chirpy-access"
+	TokenTypeMFAChallenge = "chirpy-mfa-challenge"
 )
 
-func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
-	signingKey := []byte(tokenSecret)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer: "chirpy-access",
-		IssuedAt: jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn).UTC()),
-		Subject: userID.String(),
-	})
-	return token.SignedString(signingKey)
+// MakeJWT signs an HS256 access token with tokenSecret. Servers with
+// asymmetric keys use Keyring.MakeJWT instead.
+func MakeJWT(userID uuid.UUID, role Role, tokenSecret string, expiresIn time.Duration) (string, error) {
+	return NewKeyring(NewHMACKey(tokenSecret)).MakeJWT(userID, role, expiresIn)
 }
 
 func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claimsStruct := jwt.RegisteredClaims{}
-	token, err := jwt.ParseWithClaims(tokenString, &claimsStruct, func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil })
-	if err != nil {
-		return uuid.Nil, err
-	}
-	user_id_string, err := token.Claims.GetSubject()
-	if err != nil {
-		return uuid.Nil, err
-	}
-	issuer, err := token.Claims.GetIssuer()
-	if err != nil {
-		return uuid.Nil, err
-	}
-	if issuer != string(TokenTypeAccess) {
-		return uuid.Nil, fmt.Errorf("invalid issuer")
-	}
-	id, err := uuid.Parse(user_id_string)
-	if err != nil {
-		return uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
-	}
-	return id, nil
+	return NewKeyring(NewHMACKey(tokenSecret)).ValidateJWT(tokenString)
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
@@ -9,7 +9,7 @@ import (
 
 func TestValidateJWT(t *testing.T) {
 	userID := uuid.New()
-	validToken, _ := MakeJWT(userID, "secret", time.Hour)
+	validToken, _ := MakeJWT(userID, RoleUser, "secret", time.Hour)
 
 	tests := []struct {
 		name        string
@@ -0,0 +1,278 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// SigningKey is a key access tokens are signed or verified with. Asymmetric
+// keys are identified by the `kid` header of the tokens they sign; the
+// HMAC key predates key IDs, so its tokens have none.
+type SigningKey struct {
+	ID     string
+	method jwt.SigningMethod
+	signer any
+	public any
+	// until is when the key stops being accepted. Zero means never.
+	until time.Time
+}
+
+// AcceptedUntil returns key with an end date: from t on, tokens it signed
+// are rejected however long they were issued for. It is meant for retired
+// keys, above all the HMAC key, whose tokens anyone with the secret can
+// mint.
+func (key SigningKey) AcceptedUntil(t time.Time) SigningKey {
+	key.until = t
+	return key
+}
+
+// NewHMACKey returns an HS256 key. Its tokens can only be checked by
+// whoever holds the secret, so it is never published in the JWKS.
+func NewHMACKey(secret string) SigningKey {
+	return SigningKey{
+		method: jwt.SigningMethodHS256,
+		signer: []byte(secret),
+		public: []byte(secret),
+	}
+}
+
+// NewSigningKey returns an RS256 key for an RSA private key or an EdDSA key
+// for an Ed25519 one. Its ID is the RFC 7638 thumbprint of the public key,
+// so the same key always gets the same ID.
+func NewSigningKey(private crypto.Signer) (SigningKey, error) {
+	key := SigningKey{signer: private, public: private.Public()}
+	switch private.(type) {
+	case *rsa.PrivateKey:
+		key.method = jwt.SigningMethodRS256
+	case ed25519.PrivateKey:
+		key.method = jwt.SigningMethodEdDSA
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported key type %T", private)
+	}
+	dat, err := key.jwk().thumbprintInput()
+	if err != nil {
+		return SigningKey{}, err
+	}
+	sum := sha256.Sum256(dat)
+	key.ID = base64.RawURLEncoding.EncodeToString(sum[:])
+	return key, nil
+}
+
+// ParseSigningKeyPEM reads an RSA or Ed25519 private key in PKCS #8 or, for
+// RSA, PKCS #1 PEM form.
+func ParseSigningKeyPEM(data []byte) (SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return SigningKey{}, errors.New("no PEM block found")
+	}
+	var private any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return SigningKey{}, err
+	}
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return SigningKey{}, fmt.Errorf("unsupported key type %T", private)
+	}
+	return NewSigningKey(signer)
+}
+
+// Keyring signs access tokens with its current key and accepts tokens from
+// the current key and from retired ones. A retired key should stay in the
+// keyring for as long as the tokens it signed are valid, then be dropped.
+type Keyring struct {
+	current SigningKey
+	keys    map[string]SigningKey
+	order   []string
+}
+
+func NewKeyring(current SigningKey, retired ...SigningKey) *Keyring {
+	k := &Keyring{current: current, keys: map[string]SigningKey{}}
+	for _, key := range append([]SigningKey{current}, retired...) {
+		if _, ok := k.keys[key.ID]; ok {
+			continue
+		}
+		k.keys[key.ID] = key
+		k.order = append(k.order, key.ID)
+	}
+	return k
+}
+
+func (k *Keyring) MakeJWT(userID uuid.UUID, role Role, expiresIn time.Duration) (string, error) {
+	return k.makeJWT(TokenTypeAccess, userID, role, expiresIn)
+}
+
+func (k *Keyring) ValidateJWT(tokenString string) (uuid.UUID, error) {
+	claims, err := k.ValidateAccessToken(tokenString)
+	return claims.UserID, err
+}
+
+// ValidateAccessToken is ValidateJWT for callers that also need the role.
+func (k *Keyring) ValidateAccessToken(tokenString string) (AccessClaims, error) {
+	return k.validateJWT(TokenTypeAccess, tokenString)
+}
+
+// MakeMFAChallenge signs a token saying userID has given the right password
+// but still has to give a second factor. It is not an access token, so it
+// can't be used for anything but finishing the login.
+func (k *Keyring) MakeMFAChallenge(userID uuid.UUID, expiresIn time.Duration) (string, error) {
+	return k.makeJWT(TokenTypeMFAChallenge, userID, "", expiresIn)
+}
+
+func (k *Keyring) ValidateMFAChallenge(tokenString string) (uuid.UUID, error) {
+	claims, err := k.validateJWT(TokenTypeMFAChallenge, tokenString)
+	return claims.UserID, err
+}
+
+// AccessClaims is who an access token was issued to. The role is the one
+// the user had when the token was issued.
+type AccessClaims struct {
+	UserID uuid.UUID
+	Role   Role
+}
+
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Role Role `json:"role,omitempty"`
+}
+
+func (k *Keyring) makeJWT(tokenType string, userID uuid.UUID, role Role, expiresIn time.Duration) (string, error) {
+	token := jwt.NewWithClaims(k.current.method, tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenType,
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn).UTC()),
+			Subject:   userID.String(),
+		},
+		Role: role,
+	})
+	if k.current.ID != "" {
+		token.Header["kid"] = k.current.ID
+	}
+	return token.SignedString(k.current.signer)
+}
+
+func (k *Keyring) validateJWT(tokenType, tokenString string) (AccessClaims, error) {
+	claimsStruct := tokenClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, &claimsStruct, k.verificationKey)
+	if err != nil {
+		return AccessClaims{}, err
+	}
+	user_id_string, err := token.Claims.GetSubject()
+	if err != nil {
+		return AccessClaims{}, err
+	}
+	issuer, err := token.Claims.GetIssuer()
+	if err != nil {
+		return AccessClaims{}, err
+	}
+	if issuer != tokenType {
+		return AccessClaims{}, fmt.Errorf("invalid issuer")
+	}
+	id, err := uuid.Parse(user_id_string)
+	if err != nil {
+		return AccessClaims{}, fmt.Errorf("invalid user ID: %w", err)
+	}
+	// Tokens from before roles existed have none; their users were all
+	// plain users.
+	role := claimsStruct.Role
+	if role == "" {
+		role = RoleUser
+	}
+	return AccessClaims{UserID: id, Role: role}, nil
+}
+
+// verificationKey picks the key named by the token's kid. The token's alg
+// must be the key's, otherwise an RSA public key could be passed off as an
+// HMAC secret.
+func (k *Keyring) verificationKey(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("signing method %s doesn't match key %q", token.Method.Alg(), kid)
+	}
+	if !key.until.IsZero() && !time.Now().Before(key.until) {
+		return nil, fmt.Errorf("signing key %q was retired at %s", kid, key.until.Format(time.RFC3339))
+	}
+	return key.public, nil
+}
+
+// JWK is a public key in JSON Web Key form.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public half of every asymmetric key, current and
+// retired, so other services can verify access tokens themselves.
+func (k *Keyring) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	for _, id := range k.order {
+		if key := k.keys[id]; key.ID != "" {
+			jwks.Keys = append(jwks.Keys, key.jwk())
+		}
+	}
+	return jwks
+}
+
+func (key SigningKey) jwk() JWK {
+	jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.method.Alg()}
+	switch public := key.public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	}
+	return jwk
+}
+
+// thumbprintInput is the JSON RFC 7638 hashes: the required members only,
+// in lexicographic order, without whitespace.
+func (jwk JWK) thumbprintInput() ([]byte, error) {
+	switch jwk.Kty {
+	case "RSA":
+		return fmt.Appendf(nil, `{"e":%q,"kty":"RSA","n":%q}`, jwk.E, jwk.N), nil
+	case "OKP":
+		return fmt.Appendf(nil, `{"crv":%q,"kty":"OKP","x":%q}`, jwk.Crv, jwk.X), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+}
@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func newTestKeys(t *testing.T) (rsaKey, edKey SigningKey) {
+	t.Helper()
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating RSA key: %v", err)
+	}
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating Ed25519 key: %v", err)
+	}
+	rsaKey, err = NewSigningKey(rsaPrivate)
+	if err != nil {
+		t.Fatalf("NewSigningKey(RSA) error = %v", err)
+	}
+	edKey, err = NewSigningKey(edPrivate)
+	if err != nil {
+		t.Fatalf("NewSigningKey(Ed25519) error = %v", err)
+	}
+	return rsaKey, edKey
+}
+
+func TestKeyringValidateJWT(t *testing.T) {
+	userID := uuid.New()
+	rsaKey, edKey := newTestKeys(t)
+	legacy := NewHMACKey("secret").AcceptedUntil(time.Now().Add(time.Hour))
+
+	// The keyring was rotated from HMAC to RSA to Ed25519.
+	keyring := NewKeyring(edKey, rsaKey, legacy)
+
+	sign := func(key SigningKey) string {
+		t.Helper()
+		token, err := NewKeyring(key).MakeJWT(userID, RoleUser, time.Hour)
+		if err != nil {
+			t.Fatalf("MakeJWT() error = %v", err)
+		}
+		return token
+	}
+	current, err := keyring.MakeJWT(userID, RoleUser, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT() error = %v", err)
+	}
+	expired, _ := keyring.MakeJWT(userID, RoleUser, -time.Hour)
+	_, unknownKey := newTestKeys(t)
+
+	// An HS256 token whose secret is the RSA key's public half, claiming
+	// the RSA key's kid.
+	confused := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer:    TokenTypeAccess,
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		Subject:   userID.String(),
+	})
+	confused.Header["kid"] = rsaKey.ID
+	publicDER, _ := x509.MarshalPKIXPublicKey(rsaKey.public)
+	confusedToken, _ := confused.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+
+	tests := []struct {
+		name    string
+		token   string
+		keyring *Keyring
+		wantErr bool
+	}{
+		{name: "Current key", token: current, wantErr: false},
+		{name: "Retired RSA key", token: sign(rsaKey), wantErr: false},
+		{name: "Legacy HMAC key", token: sign(legacy), wantErr: false},
+		{name: "Unknown key", token: sign(unknownKey), wantErr: true},
+		{name: "Wrong HMAC secret", token: sign(NewHMACKey("other")), wantErr: true},
+		{name: "Key past its end date", token: sign(rsaKey), keyring: NewKeyring(edKey, rsaKey.AcceptedUntil(time.Now().Add(-time.Minute))), wantErr: true},
+		{name: "Expired token", token: expired, wantErr: true},
+		{name: "Algorithm confusion", token: confusedToken, wantErr: true},
+		{name: "Malformed token", token: "invalid.token.string", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.keyring == nil {
+				tt.keyring = keyring
+			}
+			gotUserID, err := tt.keyring.ValidateJWT(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateJWT() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && gotUserID != userID {
+				t.Errorf("ValidateJWT() gotUserID = %v, want %v", gotUserID, userID)
+			}
+		})
+	}
+}
+
+func TestKeyringJWKS(t *testing.T) {
+	rsaKey, edKey := newTestKeys(t)
+	keyring := NewKeyring(edKey, rsaKey, NewHMACKey("secret"))
+
+	jwks := keyring.JWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("JWKS() has %d keys, want 2 (the HMAC key stays private)", len(jwks.Keys))
+	}
+
+	tests := []struct {
+		name    string
+		jwk     JWK
+		wantKid string
+		wantKty string
+		wantAlg string
+	}{
+		{name: "Current Ed25519 key", jwk: jwks.Keys[0], wantKid: edKey.ID, wantKty: "OKP", wantAlg: "EdDSA"},
+		{name: "Retired RSA key", jwk: jwks.Keys[1], wantKid: rsaKey.ID, wantKty: "RSA", wantAlg: "RS256"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.jwk.Kid != tt.wantKid || tt.jwk.Kty != tt.wantKty || tt.jwk.Alg != tt.wantAlg || tt.jwk.Use != "sig" {
+				t.Errorf("JWK = %+v, want kid %s, kty %s, alg %s", tt.jwk, tt.wantKid, tt.wantKty, tt.wantAlg)
+			}
+		})
+	}
+	if jwks.Keys[1].E != "AQAB" {
+		t.Errorf("RSA exponent = %q, want %q", jwks.Keys[1].E, "AQAB")
+	}
+}
+
+func TestParseSigningKeyPEM(t *testing.T) {
+	rsaPrivate, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	edDER, _ := x509.MarshalPKCS8PrivateKey(edPrivate)
+	rsaDER, _ := x509.MarshalPKCS8PrivateKey(rsaPrivate)
+
+	tests := []struct {
+		name    string
+		pem     []byte
+		wantAlg string
+		wantErr bool
+	}{
+		{name: "PKCS #1 RSA", pem: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaPrivate)}), wantAlg: "RS256"},
+		{name: "PKCS #8 RSA", pem: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: rsaDER}), wantAlg: "RS256"},
+		{name: "PKCS #8 Ed25519", pem: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: edDER}), wantAlg: "EdDSA"},
+		{name: "Not PEM", pem: []byte("nope"), wantErr: true},
+		{name: "Public key", pem: pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte{1}}), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParseSigningKeyPEM(tt.pem)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSigningKeyPEM() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (key.method.Alg() != tt.wantAlg || key.ID == "") {
+				t.Errorf("ParseSigningKeyPEM() = %s key %q, want a %s key with an ID", key.method.Alg(), key.ID, tt.wantAlg)
+			}
+		})
+	}
+}
+
+func TestKeyringValidateAccessToken(t *testing.T) {
+	userID := uuid.New()
+	_, edKey := newTestKeys(t)
+	keyring := NewKeyring(edKey)
+
+	admin, _ := keyring.MakeJWT(userID, RoleAdmin, time.Hour)
+	// Tokens issued before roles were added carry no role claim.
+	legacy, _ := keyring.makeJWT(TokenTypeAccess, userID, "", time.Hour)
+	challenge, _ := keyring.MakeMFAChallenge(userID, time.Hour)
+
+	tests := []struct {
+		name     string
+		token    string
+		wantRole Role
+		wantErr  bool
+	}{
+		{name: "Admin token", token: admin, wantRole: RoleAdmin},
+		{name: "Token without a role", token: legacy, wantRole: RoleUser},
+		{name: "MFA challenge", token: challenge, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := keyring.ValidateAccessToken(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateAccessToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (claims.Role != tt.wantRole || claims.UserID != userID) {
+				t.Errorf("ValidateAccessToken() = %+v, want role %s for %s", claims, tt.wantRole, userID)
+			}
+		})
+	}
+}
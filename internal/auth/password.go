@@ -1,17 +1,236 @@
 package auth
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
 
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
 
+var (
+	ErrPasswordMismatch         = errors.New("password doesn't match hash")
+	ErrUnrecognizedPasswordHash = errors.New("unrecognized password hash")
+)
 
+// PasswordScheme is one way of hashing passwords.
+type PasswordScheme interface {
+	Hash(password string) (string, error)
+	// Verify returns ErrPasswordMismatch if password doesn't match hash.
+	Verify(password, hash string) error
+	// Recognizes reports whether hash was made by this scheme.
+	Recognizes(hash string) bool
+	// Outdated reports whether hash was made with other parameters than
+	// the scheme's current ones.
+	Outdated(hash string) bool
+}
+
+// PasswordHasher hashes new passwords with its current scheme and verifies
+// hashes made by the current scheme and by legacy ones, so users don't
+// have to reset their passwords when the scheme changes.
+type PasswordHasher struct {
+	current PasswordScheme
+	schemes []PasswordScheme
+
+	dummyOnce sync.Once
+	dummyHash string
+}
+
+func NewPasswordHasher(current PasswordScheme, legacy ...PasswordScheme) *PasswordHasher {
+	return &PasswordHasher{
+		current: current,
+		schemes: append([]PasswordScheme{current}, legacy...),
+	}
+}
+
+// DefaultPasswordHasher hashes with argon2id at DefaultArgon2idParams and
+// verifies the bcrypt hashes of older accounts.
+func DefaultPasswordHasher() *PasswordHasher {
+	return NewPasswordHasher(Argon2id{Params: DefaultArgon2idParams}, Bcrypt{Cost: bcrypt.DefaultCost})
+}
+
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	return h.current.Hash(password)
+}
+
+// Verify checks password against hash. It also reports whether the hash
+// should be replaced with h.Hash(password), because it was made by a legacy
+// scheme or with outdated parameters; only the caller can do that, since
+// the password is needed.
+func (h *PasswordHasher) Verify(password, hash string) (rehash bool, err error) {
+	for i, scheme := range h.schemes {
+		if !scheme.Recognizes(hash) {
+			continue
+		}
+		err := scheme.Verify(password, hash)
+		if err != nil {
+			return false, err
+		}
+		return i > 0 || scheme.Outdated(hash), nil
+	}
+	return false, ErrUnrecognizedPasswordHash
+}
+
+// DummyHash returns a hash of no one's password, made by the current
+// scheme. Verifying against it takes as long as verifying a real password,
+// so a login for an unknown email can't be told apart by its timing.
+func (h *PasswordHasher) DummyHash() string {
+	h.dummyOnce.Do(func() {
+		h.dummyHash, _ = h.current.Hash("dummy password")
+	})
+	return h.dummyHash
+}
+
+// HashPassword hashes password with the DefaultPasswordHasher.
 func HashPassword(password string) (string, error) {
-	hashed_password, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return DefaultPasswordHasher().Hash(password)
+}
+
+func CheckPasswordHash(password, hash string) error {
+	_, err := DefaultPasswordHasher().Verify(password, hash)
+	return err
+}
+
+// Argon2idParams are the cost parameters of argon2id. Memory is in KiB.
+type Argon2idParams struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2idParams are OWASP's recommended minimum for argon2id.
+var DefaultArgon2idParams = Argon2idParams{
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// ParseArgon2idParams reads the cost parameters in the form they take in a
+// PHC string, such as "m=19456,t=2,p=1". The salt and key lengths are the
+// defaults.
+func ParseArgon2idParams(s string) (Argon2idParams, error) {
+	params := DefaultArgon2idParams
+	_, err := fmt.Sscanf(s, "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil || params.phc() != s {
+		return Argon2idParams{}, fmt.Errorf("invalid argon2id parameters %q", s)
+	}
+	if params.Memory < 8*uint32(params.Parallelism) || params.Iterations == 0 || params.Parallelism == 0 {
+		return Argon2idParams{}, fmt.Errorf("argon2id parameters %q are out of range", s)
+	}
+	return params, nil
+}
+
+func (p Argon2idParams) phc() string {
+	return fmt.Sprintf("m=%d,t=%d,p=%d", p.Memory, p.Iterations, p.Parallelism)
+}
+
+// Argon2id hashes passwords with argon2id into PHC strings:
+//
+//	$argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>
+//
+// Unlike bcrypt, it uses every byte of a long password.
+type Argon2id struct {
+	Params Argon2idParams
+}
+
+func (a Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.Params.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Params.Iterations, a.Params.Memory, a.Params.Parallelism, a.Params.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$%s$%s$%s",
+		argon2.Version,
+		a.Params.phc(),
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (a Argon2id) Verify(password, hash string) error {
+	params, salt, key, err := parseArgon2idHash(hash)
+	if err != nil {
+		return err
+	}
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+func (a Argon2id) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+func (a Argon2id) Outdated(hash string) bool {
+	params, _, _, err := parseArgon2idHash(hash)
+	return err != nil || params != a.Params
+}
+
+func parseArgon2idHash(hash string) (params Argon2idParams, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return Argon2idParams{}, nil, nil, ErrUnrecognizedPasswordHash
+	}
+	if parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return Argon2idParams{}, nil, nil, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+	params, err = ParseArgon2idParams(parts[3])
+	if err != nil {
+		return Argon2idParams{}, nil, nil, err
+	}
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2idParams{}, nil, nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Argon2idParams{}, nil, nil, fmt.Errorf("invalid argon2id key: %w", err)
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
+
+// Bcrypt is the scheme passwords were hashed with before argon2id. It
+// ignores everything past a password's first 72 bytes, so it should only
+// be kept around to verify old hashes.
+type Bcrypt struct {
+	Cost int
+}
+
+func (b Bcrypt) Hash(password string) (string, error) {
+	hashed_password, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
 	if err != nil {
 		return "", err
 	}
 	return string(hashed_password), nil
 }
 
-func CheckPasswordHash(password, hash string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-}
\ No newline at end of file
+func (b Bcrypt) Verify(password, hash string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrPasswordMismatch
+	}
+	return err
+}
+
+func (b Bcrypt) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (b Bcrypt) Outdated(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < b.Cost
+}
@@ -1,7 +1,11 @@
 package auth
 
 import (
+    "errors"
+    "strings"
     "testing"
+
+    "golang.org/x/crypto/bcrypt"
 )
 
 // TestHelloName calls greetings.Hello with a name, checking
@@ -17,3 +21,100 @@ func TestHashPassword(t *testing.T) {
 		t.Fatalf(`Something has gone wrong when comparing passwords`)
 	}
 }
+
+func TestPasswordHasherVerify(t *testing.T) {
+	weak := Argon2idParams{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	current := Argon2id{Params: Argon2idParams{Memory: 2048, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}}
+	hasher := NewPasswordHasher(current, Bcrypt{Cost: bcrypt.MinCost + 1})
+
+	hash := func(scheme PasswordScheme, password string) string {
+		t.Helper()
+		hash, err := scheme.Hash(password)
+		if err != nil {
+			t.Fatalf("Hash() error = %v", err)
+		}
+		return hash
+	}
+	long := strings.Repeat("a", 72)
+
+	tests := []struct {
+		name       string
+		password   string
+		hash       string
+		wantRehash bool
+		wantErr    error
+	}{
+		{name: "Current argon2id", password: "password", hash: hash(current, "password"), wantRehash: false},
+		{name: "Weaker argon2id", password: "password", hash: hash(Argon2id{Params: weak}, "password"), wantRehash: true},
+		{name: "Bcrypt", password: "password", hash: hash(Bcrypt{Cost: bcrypt.MinCost + 1}, "password"), wantRehash: true},
+		{name: "Wrong password", password: "wrong", hash: hash(current, "password"), wantErr: ErrPasswordMismatch},
+		{name: "Wrong bcrypt password", password: "wrong", hash: hash(Bcrypt{Cost: bcrypt.MinCost}, "password"), wantErr: ErrPasswordMismatch},
+		{name: "Past 72 bytes", password: long + "b", hash: hash(current, long+"a"), wantErr: ErrPasswordMismatch},
+		{name: "Unknown scheme", password: "password", hash: "$1$salt$hash", wantErr: ErrUnrecognizedPasswordHash},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rehash, err := hasher.Verify(tt.password, tt.hash)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+			if rehash != tt.wantRehash {
+				t.Errorf("Verify() rehash = %v, want %v", rehash, tt.wantRehash)
+			}
+		})
+	}
+}
+
+func TestArgon2idHash(t *testing.T) {
+	hash, err := Argon2id{Params: DefaultArgon2idParams}.Hash("password")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Errorf("Hash() = %q, want a PHC string with the default parameters", hash)
+	}
+	other, _ := Argon2id{Params: DefaultArgon2idParams}.Hash("password")
+	if other == hash {
+		t.Errorf("Hash() gave the same hash twice, want a new salt each time")
+	}
+}
+
+func TestParseArgon2idParams(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Argon2idParams
+		wantErr bool
+	}{
+		{input: "m=65536,t=3,p=4", want: Argon2idParams{Memory: 65536, Iterations: 3, Parallelism: 4, SaltLength: 16, KeyLength: 32}},
+		{input: "m=65536,t=3", wantErr: true},
+		{input: "m=65536,t=3,p=4,x=1", wantErr: true},
+		{input: "m=1,t=3,p=4", wantErr: true},
+		{input: "m=65536,t=0,p=4", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseArgon2idParams(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseArgon2idParams(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseArgon2idParams(%q) = %+v, want %+v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestDummyHash(t *testing.T) {
+	hasher := DefaultPasswordHasher()
+	hash := hasher.DummyHash()
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		t.Errorf("DummyHash() = %q, want a hash made by the current scheme", hash)
+	}
+	if again := hasher.DummyHash(); again != hash {
+		t.Errorf("DummyHash() changed from %q to %q", hash, again)
+	}
+	if _, err := hasher.Verify("password", hash); !errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("Verify() against DummyHash() error = %v, want %v", err, ErrPasswordMismatch)
+	}
+}
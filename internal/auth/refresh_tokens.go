@@ -1,7 +1,9 @@
 package auth
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 )
 
@@ -12,4 +14,13 @@ func MakeRefreshToken() (string, error) {
 		return "", err
 	}
 	return hex.EncodeToString(data), nil
-}
\ No newline at end of file
+}
+
+// HashToken returns the HMAC-SHA256 of a refresh or one-time token keyed
+// with pepper, hex-encoded. Only the hash is stored, so reading the database
+// is not enough to use a token; the pepper never leaves the server's config.
+func HashToken(token, pepper string) string {
+	mac := hmac.New(sha256.New, []byte(pepper))
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,34 @@
+package auth
+
+import "testing"
+
+func TestHashToken(t *testing.T) {
+	token, err := MakeRefreshToken()
+	if err != nil {
+		t.Fatalf("MakeRefreshToken() error = %v", err)
+	}
+	hash := HashToken(token, "pepper")
+
+	tests := []struct {
+		name     string
+		token    string
+		pepper   string
+		wantSame bool
+	}{
+		{name: "Same token and pepper", token: token, pepper: "pepper", wantSame: true},
+		{name: "Different pepper", token: token, pepper: "other", wantSame: false},
+		{name: "Different token", token: token + "0", pepper: "pepper", wantSame: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := HashToken(tt.token, tt.pepper)
+			if (got == hash) != tt.wantSame {
+				t.Errorf("HashToken() = %s, hash of the original token = %s, want same = %v", got, hash, tt.wantSame)
+			}
+			if got == tt.token {
+				t.Errorf("HashToken() returned the token unchanged")
+			}
+		})
+	}
+}
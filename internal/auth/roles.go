@@ -0,0 +1,34 @@
+package auth
+
+import "fmt"
+
+// Role is what a user may do. Each role can do everything the ones below
+// it can.
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+var roleRanks = map[Role]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+func ParseRole(s string) (Role, error) {
+	role := Role(s)
+	if _, ok := roleRanks[role]; !ok {
+		return "", fmt.Errorf("unknown role %q", s)
+	}
+	return role, nil
+}
+
+// AtLeast reports whether r may do everything other may. An unknown role
+// may do nothing.
+func (r Role) AtLeast(other Role) bool {
+	rank, ok := roleRanks[r]
+	return ok && rank >= roleRanks[other]
+}
@@ -0,0 +1,24 @@
+package auth
+
+import "testing"
+
+func TestRoleAtLeast(t *testing.T) {
+	tests := []struct {
+		role  Role
+		other Role
+		want  bool
+	}{
+		{role: RoleAdmin, other: RoleModerator, want: true},
+		{role: RoleModerator, other: RoleModerator, want: true},
+		{role: RoleModerator, other: RoleAdmin, want: false},
+		{role: RoleUser, other: RoleModerator, want: false},
+		{role: Role("root"), other: RoleUser, want: false},
+		{role: Role(""), other: RoleUser, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.role.AtLeast(tt.other); got != tt.want {
+			t.Errorf("%q.AtLeast(%q) = %v, want %v", tt.role, tt.other, got, tt.want)
+		}
+	}
+}
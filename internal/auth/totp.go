@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters. They are the defaults of RFC 6238 and the only ones every
+// authenticator app supports.
+const (
+	totpDigits = 6
+	totpPeriod = 30 * time.Second
+	// totpSkew is how many steps either side of the current one are
+	// accepted, to allow for clock drift and slow typing.
+	totpSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+var ErrInvalidTOTP = errors.New("invalid TOTP code")
+
+// MakeTOTPSecret returns a random 160-bit secret in the unpadded base32
+// form authenticator apps expect.
+func MakeTOTPSecret() (string, error) {
+	data := make([]byte, 20)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(data), nil
+}
+
+// TOTPURI returns the otpauth:// URI authenticator apps import, usually
+// from a QR code.
+func TOTPURI(secret, issuer, account string) string {
+	u := url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/" + issuer + ":" + account,
+		RawQuery: url.Values{
+			"secret":    {secret},
+			"issuer":    {issuer},
+			"algorithm": {"SHA1"},
+			"digits":    {fmt.Sprint(totpDigits)},
+			"period":    {fmt.Sprint(int(totpPeriod.Seconds()))},
+		}.Encode(),
+	}
+	return u.String()
+}
+
+// TOTPCode returns the code for the time step t falls in.
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, totpStep(t)), nil
+}
+
+// ValidateTOTP checks code against the steps around t and returns the step
+// it matched. Callers should reject a step they have already accepted, so a
+// code can't be used twice.
+func ValidateTOTP(secret, code string, t time.Time) (int64, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, err
+	}
+	current := totpStep(t)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if hmac.Equal([]byte(hotp(key, step)), []byte(code)) {
+			return step, nil
+		}
+	}
+	return 0, ErrInvalidTOTP
+}
+
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod.Seconds())
+}
+
+// hotp is the HMAC-based one-time password of RFC 4226.
+func hotp(key []byte, counter int64) string {
+	mac := hmac.New(sha1.New, key)
+	binary.Write(mac, binary.BigEndian, counter)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	mod := uint32(1)
+	for range totpDigits {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// MakeRecoveryCodes returns n random single-use codes of the form
+// xxxxx-xxxxx for users who lose their authenticator.
+func MakeRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		data := make([]byte, 10)
+		_, err := rand.Read(data)
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(data))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode undoes the formatting a user might add or drop when
+// typing a recovery code, so it can be hashed and compared.
+func NormalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+}
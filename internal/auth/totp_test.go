@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of the RFC 6238 test vectors, base32-encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// The RFC's 8-digit codes, cut down to the 6 digits we use.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+	}
+
+	for _, tt := range tests {
+		got, err := TOTPCode(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("TOTPCode() error = %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("TOTPCode() at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := MakeTOTPSecret()
+	if err != nil {
+		t.Fatalf("MakeTOTPSecret() error = %v", err)
+	}
+	now := time.Now()
+	code := func(t *testing.T, at time.Time) string {
+		t.Helper()
+		code, err := TOTPCode(secret, at)
+		if err != nil {
+			t.Fatalf("TOTPCode() error = %v", err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		wantStep int64
+		wantErr  bool
+	}{
+		{name: "Current code", secret: secret, code: code(t, now), wantStep: totpStep(now)},
+		{name: "Previous code", secret: secret, code: code(t, now.Add(-totpPeriod)), wantStep: totpStep(now) - 1},
+		{name: "Next code", secret: secret, code: code(t, now.Add(totpPeriod)), wantStep: totpStep(now) + 1},
+		{name: "Stale code", secret: secret, code: code(t, now.Add(-3*totpPeriod)), wantErr: true},
+		{name: "Wrong code", secret: secret, code: "abcdef", wantErr: true},
+		{name: "Empty code", secret: secret, code: "", wantErr: true},
+		{name: "Malformed secret", secret: "not base32!", code: "000000", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, err := ValidateTOTP(tt.secret, tt.code, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateTOTP() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && step != tt.wantStep {
+				t.Errorf("ValidateTOTP() step = %d, want %d", step, tt.wantStep)
+			}
+		})
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := MakeRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("MakeRecoveryCodes() error = %v", err)
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("recovery code %q isn't of the form xxxxx-xxxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("recovery code %q was returned twice", code)
+		}
+		seen[code] = true
+	}
+
+	tests := []struct {
+		code string
+		want string
+	}{
+		{code: "abcde-fghij", want: "abcdefghij"},
+		{code: "ABCDE FGHIJ", want: "abcdefghij"},
+		{code: "abcdefghij", want: "abcdefghij"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeRecoveryCode(tt.code); got != tt.want {
+			t.Errorf("NormalizeRecoveryCode(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Package content checks chirp bodies before they are stored. A Pipeline
+// runs a body through ordered stages, each of which can rewrite it, reject
+// it or record what it found on the draft.
+package content
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Draft is a chirp body on its way through a pipeline, together with what
+// the stages learned about it.
+type Draft struct {
+	AuthorID uuid.UUID
+	Body     string
+	// MaxLength is the author's own length limit. Length falls back on its
+	// Max when it is zero.
+	MaxLength int
+
+	// Links are the URLs in the body, in order.
+	Links []string
+	// Mentions are the mentioned handles that belong to a user.
+	Mentions []Mention
+	// FlaggedWords are the banned words that put the chirp up for review.
+	FlaggedWords []string
+	// SpamScore runs from 0, nothing suspicious, to 1.
+	SpamScore float64
+}
+
+type Mention struct {
+	Handle string
+	UserID uuid.UUID
+}
+
+// Stage is a step of a pipeline. Process returns a *RejectError when the
+// body breaks the stage's rule; any other error means the stage couldn't
+// do its work.
+type Stage interface {
+	Name() string
+	Process(ctx context.Context, draft *Draft) error
+}
+
+// RejectError is returned for a body that a stage refuses. Reason is meant
+// for the author; Err, when set, carries the details.
+type RejectError struct {
+	Stage  string
+	Reason string
+	Err    error
+}
+
+func (e *RejectError) Error() string {
+	return e.Reason
+}
+
+func (e *RejectError) Unwrap() error {
+	return e.Err
+}
+
+// IsReject reports whether err is a rejection rather than a failure.
+func IsReject(err error) bool {
+	var reject *RejectError
+	return errors.As(err, &reject)
+}
+
+type Pipeline struct {
+	stages []Stage
+}
+
+func NewPipeline(stages ...Stage) *Pipeline {
+	return &Pipeline{stages: stages}
+}
+
+// Stages returns the names of the stages in the order they run.
+func (p *Pipeline) Stages() []string {
+	names := make([]string, len(p.stages))
+	for i, stage := range p.stages {
+		names[i] = stage.Name()
+	}
+	return names
+}
+
+// Run passes draft through every stage and returns the result. It stops at
+// the first stage that rejects the body or fails.
+func (p *Pipeline) Run(ctx context.Context, draft Draft) (Draft, error) {
+	for _, stage := range p.stages {
+		err := stage.Process(ctx, &draft)
+		if IsReject(err) {
+			return Draft{}, err
+		}
+		if err != nil {
+			return Draft{}, fmt.Errorf("%s: %w", stage.Name(), err)
+		}
+	}
+	return draft, nil
+}
@@ -0,0 +1,228 @@
+package content
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/YaguarEgor/chirpy_server/internal/filter"
+)
+
+// appendStage adds its name to the body, so tests can see the order stages
+// ran in.
+type appendStage string
+
+func (s appendStage) Name() string { return string(s) }
+
+func (s appendStage) Process(ctx context.Context, draft *Draft) error {
+	draft.Body += string(s)
+	return nil
+}
+
+type failStage struct {
+	err error
+}
+
+func (failStage) Name() string { return "fail" }
+
+func (s failStage) Process(ctx context.Context, draft *Draft) error {
+	return s.err
+}
+
+func TestPipelineRun(t *testing.T) {
+	ctx := context.Background()
+
+	p := NewPipeline(appendStage("a"), appendStage("b"), appendStage("c"))
+	draft, err := p.Run(ctx, Draft{Body: ">"})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if draft.Body != ">abc" {
+		t.Errorf("Run() body = %q, want stages in order", draft.Body)
+	}
+	if got := p.Stages(); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Errorf("Stages() = %v", got)
+	}
+
+	reject := &RejectError{Stage: "fail", Reason: "nope"}
+	_, err = NewPipeline(failStage{reject}, appendStage("never")).Run(ctx, Draft{})
+	if err != reject || !IsReject(err) {
+		t.Errorf("Run() error = %v, want the rejection unchanged", err)
+	}
+
+	broken := errors.New("database is down")
+	_, err = NewPipeline(failStage{broken}).Run(ctx, Draft{})
+	if !errors.Is(err, broken) || IsReject(err) {
+		t.Errorf("Run() error = %v, want a wrapped failure", err)
+	}
+}
+
+func TestStages(t *testing.T) {
+	words, err := filter.New([]filter.Word{
+		{Word: "kerfuffle", Policy: filter.PolicyMask},
+		{Word: "fornax", Policy: filter.PolicyReject},
+		{Word: "profane", Policy: filter.PolicyFlag},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewPipeline(Length{Max: 140}, Profanity{Filter: words}, Links{}, Spam{Threshold: 0.8})
+
+	tests := []struct {
+		name       string
+		body       string
+		wantBody   string
+		wantLinks  []string
+		wantFlags  []string
+		wantReject string
+	}{
+		{name: "Clean", body: "hello there", wantBody: "hello there"},
+		{name: "Too long", body: strings.Repeat("a ", 71), wantReject: "length"},
+		{name: "Masked", body: "what a kerfuffle!", wantBody: "what a ****!"},
+		{name: "Rejected word", body: "fornax", wantReject: "profanity"},
+		{name: "Flagged word", body: "so profane", wantBody: "so profane", wantFlags: []string{"profane"}},
+		{
+			name:      "Links",
+			body:      "read https://example.com/a?b=c, then (http://example.org).",
+			wantBody:  "read https://example.com/a?b=c, then (http://example.org).",
+			wantLinks: []string{"https://example.com/a?b=c", "http://example.org"},
+		},
+		{name: "Link farm", body: "http://a.example http://b.example http://c.example http://d.example", wantReject: "spam"},
+		{name: "Shouting and links", body: "BUY NOW!!!!!!!!!! HTTP://A.EXAMPLE HTTP://B.EXAMPLE", wantReject: "spam"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			draft, err := p.Run(context.Background(), Draft{Body: tt.body})
+			if tt.wantReject != "" {
+				var reject *RejectError
+				if !errors.As(err, &reject) || reject.Stage != tt.wantReject {
+					t.Fatalf("Run() error = %v, want a rejection by %s", err, tt.wantReject)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if draft.Body != tt.wantBody {
+				t.Errorf("Body = %q, want %q", draft.Body, tt.wantBody)
+			}
+			if !slices.Equal(draft.Links, tt.wantLinks) {
+				t.Errorf("Links = %v, want %v", draft.Links, tt.wantLinks)
+			}
+			if !slices.Equal(draft.FlaggedWords, tt.wantFlags) {
+				t.Errorf("FlaggedWords = %v, want %v", draft.FlaggedWords, tt.wantFlags)
+			}
+		})
+	}
+}
+
+func TestSpamScore(t *testing.T) {
+	mentions := make([]Mention, 6)
+
+	tests := []struct {
+		name  string
+		draft Draft
+		want  float64
+	}{
+		{name: "Conversation", draft: Draft{Body: "see you at the game tonight"}, want: 0},
+		{name: "One link", draft: Draft{Links: []string{"a"}}, want: 0},
+		{name: "Three links", draft: Draft{Links: []string{"a", "b", "c"}}, want: 0.6},
+		{name: "Six mentions", draft: Draft{Mentions: mentions}, want: 0.45},
+		{name: "Shouting", draft: Draft{Body: "THIS IS THE BEST DEAL EVER"}, want: 0.3},
+		{name: "Short caps", draft: Draft{Body: "LOL OK"}, want: 0},
+		{name: "Stretched", draft: Draft{Body: "nooooooooo"}, want: 0.2},
+		{name: "Spaces are not stretching", draft: Draft{Body: "a          b"}, want: 0},
+		{name: "Capped", draft: Draft{Links: make([]string, 10)}, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := spamScore(tt.draft)
+			if diff := got - tt.want; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("spamScore() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "Plain", body: "hello", want: "hello"},
+		{name: "Composes accents", body: "cafe\u0301", want: "caf\u00e9"},
+		{name: "Keeps newlines and tabs", body: "one\r\ntwo\tthree\n", want: "one\ntwo\tthree\n"},
+		{name: "Drops control characters", body: "bell\a null\x00 escape\x1b[31m", want: "bell null escape[31m"},
+		{name: "Drops bidi overrides", body: "abc\u202egnp.exe\u202c \u2067isolated\u2069", want: "abcgnp.exe isolated"},
+		{name: "Keeps direction marks", body: "\u200fשלום\u200e", want: "\u200fשלום\u200e"},
+		{name: "Keeps emoji joiners", body: "\U0001f469\u200d\U0001f469\u200d\U0001f467", want: "\U0001f469\u200d\U0001f469\u200d\U0001f467"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			draft := Draft{Body: tt.body}
+			if err := (Normalize{}).Process(context.Background(), &draft); err != nil {
+				t.Fatal(err)
+			}
+			if draft.Body != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.body, draft.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestLengthCount(t *testing.T) {
+	length := Length{Max: 140, LinkWeight: 23}
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{name: "ASCII", body: "hello", want: 5},
+		{name: "Cyrillic", body: "Привет, мир", want: 11},
+		{name: "Combining accent", body: "cafe\u0301", want: 4},
+		{name: "Emoji", body: "\U0001f44d\U0001f3fd\U0001f1f7\U0001f1fa", want: 2},
+		{name: "ZWJ sequence", body: "\U0001f469\u200d\U0001f469\u200d\U0001f467 family", want: 8},
+		{name: "Link", body: "see https://example.com/a/very/long/path/that/goes/on/and/on/and/on/and/on/and/on/forever", want: 27},
+		{name: "Link before punctuation", body: "(https://example.com).", want: 26},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := length.Count(tt.body); got != tt.want {
+				t.Errorf("Count(%q) = %d, want %d", tt.body, got, tt.want)
+			}
+		})
+	}
+
+	if got := (Length{Max: 140}).Count("https://example.com"); got != 19 {
+		t.Errorf("Count() without a link weight = %d, want 19", got)
+	}
+
+	_, err := NewPipeline(Length{Max: 3}).Run(context.Background(), Draft{Body: "Ёжик"})
+	var lengthErr *LengthError
+	if !errors.As(err, &lengthErr) || lengthErr.Limit != 3 || lengthErr.Used != 4 {
+		t.Errorf("Run() error = %v, want a LengthError with limit 3 and used 4", err)
+	}
+	if !IsReject(err) {
+		t.Errorf("Run() error = %v, want a rejection", err)
+	}
+}
+
+func TestLengthMaxLength(t *testing.T) {
+	p := NewPipeline(Length{Max: 5})
+	if _, err := p.Run(context.Background(), Draft{Body: "abcdefgh", MaxLength: 10}); err != nil {
+		t.Errorf("Run() with a higher MaxLength error = %v", err)
+	}
+	_, err := p.Run(context.Background(), Draft{Body: "abcdefgh", MaxLength: 7})
+	var lengthErr *LengthError
+	if !errors.As(err, &lengthErr) || lengthErr.Limit != 7 {
+		t.Errorf("Run() error = %v, want a LengthError with limit 7", err)
+	}
+}
@@ -0,0 +1,199 @@
+package content
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/YaguarEgor/chirpy_server/internal/filter"
+	"github.com/rivo/uniseg"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalize puts the body in NFC, so text that looks the same is stored
+// the same, and removes what has no business in a chirp: control
+// characters other than newlines and tabs, and the bidi overrides and
+// isolates that make text display in a different order than it reads.
+type Normalize struct{}
+
+func (Normalize) Name() string { return "normalize" }
+
+func (Normalize) Process(ctx context.Context, draft *Draft) error {
+	draft.Body = normalizeText(draft.Body)
+	return nil
+}
+
+func normalizeText(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return r
+		}
+		if unicode.IsControl(r) || isBidiOverride(r) {
+			return -1
+		}
+		return r
+	}, s)
+	return norm.NFC.String(s)
+}
+
+// isBidiOverride reports whether r is one of the explicit embedding,
+// override or isolate controls. The LRM and RLM marks are left alone: they
+// only nudge neutral characters and are needed to write mixed-direction
+// text properly.
+func isBidiOverride(r rune) bool {
+	return ('\u202A' <= r && r <= '\u202E') || ('\u2066' <= r && r <= '\u2069')
+}
+
+// Length rejects bodies longer than the draft's MaxLength, or Max when the
+// draft has none. Length is counted in grapheme clusters, what a reader
+// takes for one character, so an emoji built from several code points
+// counts once and Cyrillic counts the same as Latin. Every link counts as
+// LinkWeight characters however long it is; a LinkWeight of zero counts
+// links like any other text.
+type Length struct {
+	Max        int
+	LinkWeight int
+}
+
+// LengthError tells the author how far over the limit a body is.
+type LengthError struct {
+	Limit int
+	Used  int
+}
+
+func (e *LengthError) Error() string {
+	return fmt.Sprintf("Chirp is too long: %d characters used, %d allowed", e.Used, e.Limit)
+}
+
+func (Length) Name() string { return "length" }
+
+func (s Length) Process(ctx context.Context, draft *Draft) error {
+	limit := s.Max
+	if draft.MaxLength > 0 {
+		limit = draft.MaxLength
+	}
+	if used := s.Count(draft.Body); used > limit {
+		err := &LengthError{Limit: limit, Used: used}
+		return &RejectError{Stage: s.Name(), Reason: err.Error(), Err: err}
+	}
+	return nil
+}
+
+// Count returns the length of body as Length measures it.
+func (s Length) Count(body string) int {
+	if s.LinkWeight <= 0 {
+		return uniseg.GraphemeClusterCount(body)
+	}
+	count, last := 0, 0
+	for _, link := range findLinks(body) {
+		count += uniseg.GraphemeClusterCount(body[last:link[0]]) + s.LinkWeight
+		last = link[1]
+	}
+	return count + uniseg.GraphemeClusterCount(body[last:])
+}
+
+// Profanity checks the body against a word filter: masked words are
+// replaced, rejected words refuse the body and flagged words are recorded
+// in FlaggedWords.
+type Profanity struct {
+	Filter *filter.Filter
+}
+
+func (Profanity) Name() string { return "profanity" }
+
+func (s Profanity) Process(ctx context.Context, draft *Draft) error {
+	result := s.Filter.Check(draft.Body)
+	if result.Has(filter.PolicyReject) {
+		return &RejectError{Stage: s.Name(), Reason: "Chirp contains a banned word"}
+	}
+	draft.Body = result.Text
+	draft.FlaggedWords = result.Words(filter.PolicyFlag)
+	return nil
+}
+
+// linkPattern matches http and https URLs. Punctuation at the end is
+// trimmed off afterwards, since it usually ends the sentence rather than
+// the URL.
+var linkPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"]+`)
+
+// findLinks returns the start and end offsets of the links in body.
+func findLinks(body string) [][]int {
+	var links [][]int
+	for _, loc := range linkPattern.FindAllStringIndex(body, -1) {
+		end := loc[0] + len(strings.TrimRight(body[loc[0]:loc[1]], ".,;:!?)'"))
+		links = append(links, []int{loc[0], end})
+	}
+	return links
+}
+
+// Links records the URLs in the body.
+type Links struct{}
+
+func (Links) Name() string { return "links" }
+
+func (Links) Process(ctx context.Context, draft *Draft) error {
+	draft.Links = nil
+	for _, link := range findLinks(draft.Body) {
+		draft.Links = append(draft.Links, draft.Body[link[0]:link[1]])
+	}
+	return nil
+}
+
+// Spam scores the body and rejects it when the score reaches Threshold.
+// The score adds up signals that are rare in conversation: several links,
+// a crowd of mentions, shouting and stretched characters. It reads Links
+// and Mentions, so it belongs after the stages that fill them in.
+type Spam struct {
+	Threshold float64
+}
+
+func (Spam) Name() string { return "spam" }
+
+func (s Spam) Process(ctx context.Context, draft *Draft) error {
+	draft.SpamScore = spamScore(*draft)
+	if draft.SpamScore >= s.Threshold {
+		return &RejectError{Stage: s.Name(), Reason: "Chirp looks like spam"}
+	}
+	return nil
+}
+
+func spamScore(draft Draft) float64 {
+	score := 0.0
+	if len(draft.Links) > 1 {
+		score += 0.3 * float64(len(draft.Links)-1)
+	}
+	if len(draft.Mentions) > 3 {
+		score += 0.15 * float64(len(draft.Mentions)-3)
+	}
+
+	letters, upper := 0, 0
+	run, longest := 0, 0
+	var last rune
+	for _, r := range draft.Body {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+		if unicode.IsSpace(r) {
+			run, last = 0, 0
+		} else if r == last {
+			run++
+		} else {
+			run = 1
+			last = r
+		}
+		longest = max(longest, run)
+	}
+	if letters >= 20 && upper*10 >= letters*8 {
+		score += 0.3
+	}
+	if longest >= 8 {
+		score += 0.2
+	}
+	return min(score, 1)
+}
@@ -7,28 +7,43 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, in_reply_to, kind, reference_id)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
-) RETURNING id, created_at, updated_at, body, user_id
+    $2,
+    $3,
+    $4,
+    $5
+) RETURNING id, created_at, updated_at, body, user_id, in_reply_to, reply_count, deleted_at, like_count, kind, reference_id
 `
 
 type CreateChirpParams struct {
-	Body   string
-	UserID uuid.UUID
+	Body        string
+	UserID      uuid.UUID
+	InReplyTo   uuid.NullUUID
+	Kind        string
+	ReferenceID uuid.NullUUID
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID)
+	row := q.db.QueryRowContext(ctx, createChirp,
+		arg.Body,
+		arg.UserID,
+		arg.InReplyTo,
+		arg.Kind,
+		arg.ReferenceID,
+	)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -36,6 +51,12 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.InReplyTo,
+		&i.ReplyCount,
+		&i.DeletedAt,
+		&i.LikeCount,
+		&i.Kind,
+		&i.ReferenceID,
 	)
 	return i, err
 }
@@ -50,7 +71,7 @@ func (q *Queries) DeleteChirp(ctx context.Context, id uuid.UUID) error {
 }
 
 const getChirp = `-- name: GetChirp :one
-SELECT id, created_at, updated_at, body, user_id FROM chirps WHERE id = $1
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, reply_count, deleted_at, like_count, kind, reference_id FROM chirps WHERE id = $1
 `
 
 func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
@@ -62,12 +83,113 @@ func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.InReplyTo,
+		&i.ReplyCount,
+		&i.DeletedAt,
+		&i.LikeCount,
+		&i.Kind,
+		&i.ReferenceID,
 	)
 	return i, err
 }
 
+const getChirpAncestors = `-- name: GetChirpAncestors :many
+WITH RECURSIVE ancestors(id, in_reply_to, depth) AS (
+    SELECT c.id, c.in_reply_to, 0 FROM chirps c WHERE c.id = $1
+    UNION ALL
+    SELECT c.id, c.in_reply_to, a.depth + 1 FROM chirps c
+    JOIN ancestors a ON c.id = a.in_reply_to
+)
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.in_reply_to, chirps.reply_count, chirps.deleted_at, chirps.like_count, chirps.kind, chirps.reference_id FROM chirps
+JOIN ancestors ON chirps.id = ancestors.id
+WHERE ancestors.depth > 0
+ORDER BY ancestors.depth DESC
+`
+
+func (q *Queries) GetChirpAncestors(ctx context.Context, id uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpAncestors, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.ReplyCount,
+			&i.DeletedAt,
+			&i.LikeCount,
+			&i.Kind,
+			&i.ReferenceID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpDescendants = `-- name: GetChirpDescendants :many
+WITH RECURSIVE descendants(id) AS (
+    SELECT c.id FROM chirps c WHERE c.in_reply_to = $1
+    UNION ALL
+    SELECT c.id FROM chirps c
+    JOIN descendants d ON c.in_reply_to = d.id
+)
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.in_reply_to, chirps.reply_count, chirps.deleted_at, chirps.like_count, chirps.kind, chirps.reference_id FROM chirps
+JOIN descendants ON chirps.id = descendants.id
+ORDER BY chirps.created_at ASC, chirps.id ASC
+`
+
+func (q *Queries) GetChirpDescendants(ctx context.Context, id uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpDescendants, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.ReplyCount,
+			&i.DeletedAt,
+			&i.LikeCount,
+			&i.Kind,
+			&i.ReferenceID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getChirps = `-- name: GetChirps :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps ORDER BY created_at ASC
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, reply_count, deleted_at, like_count, kind, reference_id FROM chirps WHERE deleted_at IS NULL ORDER BY created_at ASC
 `
 
 func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
@@ -85,6 +207,12 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.InReplyTo,
+			&i.ReplyCount,
+			&i.DeletedAt,
+			&i.LikeCount,
+			&i.Kind,
+			&i.ReferenceID,
 		); err != nil {
 			return nil, err
 		}
@@ -100,7 +228,7 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 }
 
 const getChirpsByAuthor = `-- name: GetChirpsByAuthor :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps WHERE user_id = $1
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, reply_count, deleted_at, like_count, kind, reference_id FROM chirps WHERE user_id = $1 AND deleted_at IS NULL
 `
 
 func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
@@ -118,6 +246,203 @@ func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Ch
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.InReplyTo,
+			&i.ReplyCount,
+			&i.DeletedAt,
+			&i.LikeCount,
+			&i.Kind,
+			&i.ReferenceID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpsByIDs = `-- name: GetChirpsByIDs :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, reply_count, deleted_at, like_count, kind, reference_id FROM chirps WHERE id = ANY($1::uuid[])
+`
+
+func (q *Queries) GetChirpsByIDs(ctx context.Context, ids []uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsByIDs, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.ReplyCount,
+			&i.DeletedAt,
+			&i.LikeCount,
+			&i.Kind,
+			&i.ReferenceID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const importChirp = `-- name: ImportChirp :one
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, kind)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    NOW(),
+    $2,
+    $3,
+    'chirp'
+) RETURNING id, created_at, updated_at, body, user_id, in_reply_to, reply_count, deleted_at, like_count, kind, reference_id
+`
+
+type ImportChirpParams struct {
+	CreatedAt time.Time
+	Body      string
+	UserID    uuid.UUID
+}
+
+// Imported chirps keep the time they were originally posted.
+func (q *Queries) ImportChirp(ctx context.Context, arg ImportChirpParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, importChirp, arg.CreatedAt, arg.Body, arg.UserID)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.InReplyTo,
+		&i.ReplyCount,
+		&i.DeletedAt,
+		&i.LikeCount,
+		&i.Kind,
+		&i.ReferenceID,
+	)
+	return i, err
+}
+
+const listChirpsAsc = `-- name: ListChirpsAsc :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, reply_count, deleted_at, like_count, kind, reference_id FROM chirps
+WHERE deleted_at IS NULL
+AND ($1::uuid IS NULL OR user_id = $1)
+AND ($2::timestamp IS NULL
+    OR (created_at, id) > ($2::timestamp, $3::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT $4
+`
+
+type ListChirpsAscParams struct {
+	UserID         uuid.NullUUID
+	AfterCreatedAt sql.NullTime
+	AfterID        uuid.NullUUID
+	RowLimit       int32
+}
+
+func (q *Queries) ListChirpsAsc(ctx context.Context, arg ListChirpsAscParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsAsc,
+		arg.UserID,
+		arg.AfterCreatedAt,
+		arg.AfterID,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.ReplyCount,
+			&i.DeletedAt,
+			&i.LikeCount,
+			&i.Kind,
+			&i.ReferenceID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listChirpsDesc = `-- name: ListChirpsDesc :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, reply_count, deleted_at, like_count, kind, reference_id FROM chirps
+WHERE deleted_at IS NULL
+AND ($1::uuid IS NULL OR user_id = $1)
+AND ($2::timestamp IS NULL
+    OR (created_at, id) < ($2::timestamp, $3::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT $4
+`
+
+type ListChirpsDescParams struct {
+	UserID         uuid.NullUUID
+	AfterCreatedAt sql.NullTime
+	AfterID        uuid.NullUUID
+	RowLimit       int32
+}
+
+func (q *Queries) ListChirpsDesc(ctx context.Context, arg ListChirpsDescParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsDesc,
+		arg.UserID,
+		arg.AfterCreatedAt,
+		arg.AfterID,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.ReplyCount,
+			&i.DeletedAt,
+			&i.LikeCount,
+			&i.Kind,
+			&i.ReferenceID,
 		); err != nil {
 			return nil, err
 		}
@@ -131,3 +456,115 @@ func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Ch
 	}
 	return items, nil
 }
+
+const searchChirps = `-- name: SearchChirps :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.in_reply_to, chirps.reply_count, chirps.deleted_at, chirps.like_count, chirps.kind, chirps.reference_id,
+    ts_rank(to_tsvector('english', chirps.body), query)::real AS rank,
+    ts_headline('english', chirps.body, query,
+        'StartSel=' || $1::text || ', StopSel=' || $2::text || ', HighlightAll=true')::text AS headline
+FROM chirps, websearch_to_tsquery('english', $3) query
+WHERE to_tsvector('english', chirps.body) @@ query
+AND chirps.deleted_at IS NULL
+AND ($4::uuid IS NULL OR chirps.user_id = $4)
+ORDER BY rank DESC, chirps.created_at DESC, chirps.id DESC
+LIMIT $5 OFFSET GREATEST($6::int, 0)
+`
+
+type SearchChirpsParams struct {
+	StartSel  string
+	StopSel   string
+	Query     string
+	UserID    uuid.NullUUID
+	RowLimit  int32
+	RowOffset int32
+}
+
+type SearchChirpsRow struct {
+	Chirp    Chirp
+	Rank     float32
+	Headline string
+}
+
+func (q *Queries) SearchChirps(ctx context.Context, arg SearchChirpsParams) ([]SearchChirpsRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchChirps,
+		arg.StartSel,
+		arg.StopSel,
+		arg.Query,
+		arg.UserID,
+		arg.RowLimit,
+		arg.RowOffset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchChirpsRow
+	for rows.Next() {
+		var i SearchChirpsRow
+		if err := rows.Scan(
+			&i.Chirp.ID,
+			&i.Chirp.CreatedAt,
+			&i.Chirp.UpdatedAt,
+			&i.Chirp.Body,
+			&i.Chirp.UserID,
+			&i.Chirp.InReplyTo,
+			&i.Chirp.ReplyCount,
+			&i.Chirp.DeletedAt,
+			&i.Chirp.LikeCount,
+			&i.Chirp.Kind,
+			&i.Chirp.ReferenceID,
+			&i.Rank,
+			&i.Headline,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const tombstoneChirp = `-- name: TombstoneChirp :exec
+UPDATE chirps SET body = '', deleted_at = NOW(), updated_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) TombstoneChirp(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, tombstoneChirp, id)
+	return err
+}
+
+const updateChirpBody = `-- name: UpdateChirpBody :one
+UPDATE chirps SET body = $2, updated_at = NOW()
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING id, created_at, updated_at, body, user_id, in_reply_to, reply_count, deleted_at, like_count, kind, reference_id
+`
+
+type UpdateChirpBodyParams struct {
+	ID   uuid.UUID
+	Body string
+}
+
+func (q *Queries) UpdateChirpBody(ctx context.Context, arg UpdateChirpBodyParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, updateChirpBody, arg.ID, arg.Body)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.InReplyTo,
+		&i.ReplyCount,
+		&i.DeletedAt,
+		&i.LikeCount,
+		&i.Kind,
+		&i.ReferenceID,
+	)
+	return i, err
+}
@@ -0,0 +1,1616 @@
+package database
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+// ErrUniqueViolation is returned by MemoryStore where Postgres would reject
+// a row because of a UNIQUE or PRIMARY KEY constraint.
+var ErrUniqueViolation = errors.New("duplicate key value violates unique constraint")
+
+// MemoryStore is a thread-safe, in-process Store. It mirrors the behaviour of
+// the SQL queries (constraints, cascades, NOW()-based filters) closely enough
+// to run the HTTP API without Postgres. Lookups that find nothing return
+// sql.ErrNoRows, just like the generated queries do.
+type MemoryStore struct {
+	mu sync.RWMutex
+	// tx is held for the length of a transaction, so transactions run one
+	// at a time.
+	tx sync.Mutex
+	memoryTables
+}
+
+// memoryTables is the data of a MemoryStore, in a struct of its own so
+// that a transaction can save and restore it in one assignment.
+type memoryTables struct {
+	users         []User
+	chirps        []Chirp
+	refreshTokens []RefreshToken
+	follows       []Follow
+	likes         []ChirpLike
+	tags          []Tag
+	chirpTags     []ChirpTag
+	notifications []Notification
+	verifications []EmailVerificationToken
+	resets        []PasswordResetToken
+	totpSecrets   []TotpSecret
+	recoveryCodes []RecoveryCode
+	bannedWords   []BannedWord
+	chirpFlags    []ChirpFlag
+	chirpImports  []ChirpImport
+	chirpPosts    []ChirpPost
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// InTx runs fn against m, and puts the tables back as they were if it
+// fails. Writes made outside the transaction while it runs are undone
+// with it, which is good enough for tests but is why MemoryStore is no
+// substitute for Postgres.
+func (m *MemoryStore) InTx(ctx context.Context, fn func(Store) error) error {
+	m.tx.Lock()
+	defer m.tx.Unlock()
+
+	m.mu.RLock()
+	saved := m.memoryTables.clone()
+	m.mu.RUnlock()
+	if err := fn(m); err != nil {
+		m.mu.Lock()
+		m.memoryTables = saved
+		m.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// clone copies every table. Rows are values, so copying the slices is
+// enough for a write to one copy not to show in the other.
+func (t memoryTables) clone() memoryTables {
+	return memoryTables{
+		users:         slices.Clone(t.users),
+		chirps:        slices.Clone(t.chirps),
+		refreshTokens: slices.Clone(t.refreshTokens),
+		follows:       slices.Clone(t.follows),
+		likes:         slices.Clone(t.likes),
+		tags:          slices.Clone(t.tags),
+		chirpTags:     slices.Clone(t.chirpTags),
+		notifications: slices.Clone(t.notifications),
+		verifications: slices.Clone(t.verifications),
+		resets:        slices.Clone(t.resets),
+		totpSecrets:   slices.Clone(t.totpSecrets),
+		recoveryCodes: slices.Clone(t.recoveryCodes),
+		bannedWords:   slices.Clone(t.bannedWords),
+		chirpFlags:    slices.Clone(t.chirpFlags),
+		chirpImports:  slices.Clone(t.chirpImports),
+		chirpPosts:    slices.Clone(t.chirpPosts),
+	}
+}
+
+// NewMemoryStore returns an empty store holding only the banned words the
+// migrations seed.
+func NewMemoryStore() *MemoryStore {
+	m := &MemoryStore{}
+	for _, word := range []string{"fornax", "kerfuffle", "profane", "sharbert"} {
+		m.bannedWords = append(m.bannedWords, BannedWord{
+			Word:      word,
+			CreatedAt: now(),
+			UpdatedAt: now(),
+			Policy:    "mask",
+		})
+	}
+	return m
+}
+
+// now matches the precision of a Postgres TIMESTAMP column.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+func (m *MemoryStore) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	parent := -1
+	if arg.InReplyTo.Valid {
+		parent = m.chirpIndex(arg.InReplyTo.UUID)
+	}
+	if m.userIndex(arg.UserID) < 0 || (arg.InReplyTo.Valid && parent < 0) ||
+		(arg.ReferenceID.Valid && m.chirpIndex(arg.ReferenceID.UUID) < 0) {
+		return Chirp{}, errors.New("insert or update on table \"chirps\" violates foreign key constraint")
+	}
+	if !slices.Contains([]string{"chirp", "rechirp", "quote"}, arg.Kind) {
+		return Chirp{}, errors.New("new row for relation \"chirps\" violates check constraint")
+	}
+	if arg.Kind == "rechirp" && arg.ReferenceID.Valid {
+		for _, chirp := range m.chirps {
+			if chirp.Kind == "rechirp" && chirp.UserID == arg.UserID && chirp.ReferenceID == arg.ReferenceID {
+				return Chirp{}, ErrUniqueViolation
+			}
+		}
+	}
+	t := now()
+	chirp := Chirp{
+		ID:          uuid.New(),
+		CreatedAt:   t,
+		UpdatedAt:   t,
+		Body:        arg.Body,
+		UserID:      arg.UserID,
+		InReplyTo:   arg.InReplyTo,
+		Kind:        arg.Kind,
+		ReferenceID: arg.ReferenceID,
+	}
+	m.chirps = append(m.chirps, chirp)
+	if parent >= 0 {
+		m.chirps[parent].ReplyCount++
+	}
+	return chirp, nil
+}
+
+func (m *MemoryStore) RecordChirpPost(ctx context.Context, userID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.userIndex(userID) < 0 {
+		return errors.New("insert or update on table \"chirp_posts\" violates foreign key constraint")
+	}
+	m.chirpPosts = append(m.chirpPosts, ChirpPost{
+		ID:        uuid.New(),
+		UserID:    userID,
+		CreatedAt: now(),
+	})
+	return nil
+}
+
+func (m *MemoryStore) GetPostingActivity(ctx context.Context, arg GetPostingActivityParams) (GetPostingActivityRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	row := GetPostingActivityRow{Oldest: arg.Since}
+	for _, post := range m.chirpPosts {
+		if post.UserID != arg.UserID || !post.CreatedAt.After(arg.Since) {
+			continue
+		}
+		if row.Chirps == 0 || post.CreatedAt.Before(row.Oldest) {
+			row.Oldest = post.CreatedAt
+		}
+		row.Chirps++
+	}
+	return row, nil
+}
+
+func (m *MemoryStore) RecordChirpImport(ctx context.Context, arg RecordChirpImportParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.userIndex(arg.UserID) < 0 {
+		return errors.New("insert or update on table \"chirp_imports\" violates foreign key constraint")
+	}
+	m.chirpImports = append(m.chirpImports, ChirpImport{
+		ID:        uuid.New(),
+		UserID:    arg.UserID,
+		CreatedAt: now(),
+		Chirps:    arg.Chirps,
+	})
+	return nil
+}
+
+func (m *MemoryStore) GetImportActivity(ctx context.Context, arg GetImportActivityParams) (GetImportActivityRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	row := GetImportActivityRow{Oldest: arg.Since}
+	first := true
+	for _, imported := range m.chirpImports {
+		if imported.UserID != arg.UserID || !imported.CreatedAt.After(arg.Since) {
+			continue
+		}
+		if first || imported.CreatedAt.Before(row.Oldest) {
+			row.Oldest = imported.CreatedAt
+			first = false
+		}
+		row.Chirps += imported.Chirps
+	}
+	return row, nil
+}
+
+func (m *MemoryStore) ImportChirp(ctx context.Context, arg ImportChirpParams) (Chirp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.userIndex(arg.UserID) < 0 {
+		return Chirp{}, errors.New("insert or update on table \"chirps\" violates foreign key constraint")
+	}
+	chirp := Chirp{
+		ID:        uuid.New(),
+		CreatedAt: arg.CreatedAt.UTC().Truncate(time.Microsecond),
+		UpdatedAt: now(),
+		Body:      arg.Body,
+		UserID:    arg.UserID,
+		Kind:      "chirp",
+	}
+	// Keep m.chirps sorted by created_at, which GetChirps relies on.
+	i := slices.IndexFunc(m.chirps, func(c Chirp) bool { return c.CreatedAt.After(chirp.CreatedAt) })
+	if i < 0 {
+		i = len(m.chirps)
+	}
+	m.chirps = slices.Insert(m.chirps, i, chirp)
+	return chirp, nil
+}
+
+func (m *MemoryStore) UpdateChirpBody(ctx context.Context, arg UpdateChirpBodyParams) (Chirp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.chirpIndex(arg.ID)
+	if i < 0 || m.chirps[i].DeletedAt.Valid {
+		return Chirp{}, sql.ErrNoRows
+	}
+	m.chirps[i].Body = arg.Body
+	m.chirps[i].UpdatedAt = now()
+	return m.chirps[i], nil
+}
+
+func (m *MemoryStore) GetChirps(ctx context.Context) ([]Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// chirps are appended in creation order, so they are already sorted
+	// by created_at.
+	var items []Chirp
+	for _, chirp := range m.chirps {
+		if !chirp.DeletedAt.Valid {
+			items = append(items, chirp)
+		}
+	}
+	return items, nil
+}
+
+func (m *MemoryStore) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if i := m.chirpIndex(id); i >= 0 {
+		return m.chirps[i], nil
+	}
+	return Chirp{}, sql.ErrNoRows
+}
+
+func (m *MemoryStore) GetChirpsByIDs(ctx context.Context, ids []uuid.UUID) ([]Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []Chirp
+	for _, chirp := range m.chirps {
+		if slices.Contains(ids, chirp.ID) {
+			items = append(items, chirp)
+		}
+	}
+	return items, nil
+}
+
+// DeleteChirp also does what the reply_count trigger and the ON DELETE SET
+// NULL of in_reply_to and reference_id do in Postgres.
+func (m *MemoryStore) DeleteChirp(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deleteChirps(func(c Chirp) bool { return c.ID == id })
+	return nil
+}
+
+// deleteChirps removes every chirp matching del, keeping reply counts and
+// in_reply_to references consistent. The caller must hold m.mu.
+func (m *MemoryStore) deleteChirps(del func(Chirp) bool) {
+	deleted := map[uuid.UUID]bool{}
+	for _, chirp := range m.chirps {
+		if !del(chirp) {
+			continue
+		}
+		deleted[chirp.ID] = true
+		if chirp.InReplyTo.Valid {
+			if parent := m.chirpIndex(chirp.InReplyTo.UUID); parent >= 0 {
+				m.chirps[parent].ReplyCount--
+			}
+		}
+	}
+	m.chirps = filter(m.chirps, func(c Chirp) bool { return !deleted[c.ID] })
+	m.likes = filter(m.likes, func(l ChirpLike) bool { return !deleted[l.ChirpID] })
+	m.chirpTags = filter(m.chirpTags, func(ct ChirpTag) bool { return !deleted[ct.ChirpID] })
+	m.notifications = filter(m.notifications, func(n Notification) bool { return !deleted[n.ChirpID] })
+	m.chirpFlags = filter(m.chirpFlags, func(f ChirpFlag) bool { return !deleted[f.ChirpID] })
+	for i, chirp := range m.chirps {
+		if chirp.InReplyTo.Valid && deleted[chirp.InReplyTo.UUID] {
+			m.chirps[i].InReplyTo = uuid.NullUUID{}
+		}
+		if chirp.ReferenceID.Valid && deleted[chirp.ReferenceID.UUID] {
+			m.chirps[i].ReferenceID = uuid.NullUUID{}
+		}
+	}
+}
+
+func (m *MemoryStore) TombstoneChirp(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if i := m.chirpIndex(id); i >= 0 {
+		t := now()
+		m.chirps[i].Body = ""
+		m.chirps[i].DeletedAt = sql.NullTime{Time: t, Valid: true}
+		m.chirps[i].UpdatedAt = t
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetChirpAncestors(ctx context.Context, id uuid.UUID) ([]Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []Chirp
+	i := m.chirpIndex(id)
+	for i >= 0 && m.chirps[i].InReplyTo.Valid {
+		i = m.chirpIndex(m.chirps[i].InReplyTo.UUID)
+		items = append(items, m.chirps[i])
+	}
+	slices.Reverse(items)
+	return items, nil
+}
+
+func (m *MemoryStore) GetChirpDescendants(ctx context.Context, id uuid.UUID) ([]Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// A reply is always created after its parent, so one pass in creation
+	// order finds every descendant.
+	inThread := map[uuid.UUID]bool{id: true}
+	var items []Chirp
+	for _, chirp := range m.chirps {
+		if chirp.InReplyTo.Valid && inThread[chirp.InReplyTo.UUID] {
+			inThread[chirp.ID] = true
+			items = append(items, chirp)
+		}
+	}
+	sortChirps(items, false)
+	return items, nil
+}
+
+// chirpIndex returns the position of the chirp in m.chirps, or -1. The
+// caller must hold m.mu.
+func (m *MemoryStore) chirpIndex(id uuid.UUID) int {
+	for i, chirp := range m.chirps {
+		if chirp.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *MemoryStore) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []Chirp
+	for _, chirp := range m.chirps {
+		if chirp.UserID == userID && !chirp.DeletedAt.Valid {
+			items = append(items, chirp)
+		}
+	}
+	return items, nil
+}
+
+func (m *MemoryStore) ListChirpsAsc(ctx context.Context, arg ListChirpsAscParams) ([]Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return listChirps(m.chirps, byAuthor(arg.UserID), arg.AfterCreatedAt, arg.AfterID, arg.RowLimit, false), nil
+}
+
+func (m *MemoryStore) ListChirpsDesc(ctx context.Context, arg ListChirpsDescParams) ([]Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return listChirps(m.chirps, byAuthor(arg.UserID), arg.AfterCreatedAt, arg.AfterID, arg.RowLimit, true), nil
+}
+
+// byAuthor keeps the chirps of userID, or every chirp when it is NULL.
+func byAuthor(userID uuid.NullUUID) func(Chirp) bool {
+	return func(chirp Chirp) bool {
+		return !userID.Valid || chirp.UserID == userID.UUID
+	}
+}
+
+// listChirps implements keyset pagination over chirps: keep the matching
+// ones that aren't tombstones, skip everything up to and including (afterCreatedAt, afterID) and
+// return at most limit rows.
+func listChirps(chirps []Chirp, keep func(Chirp) bool, afterCreatedAt sql.NullTime, afterID uuid.NullUUID, limit int32, desc bool) []Chirp {
+	var items []Chirp
+	for _, chirp := range chirps {
+		if chirp.DeletedAt.Valid || !keep(chirp) {
+			continue
+		}
+		if afterCreatedAt.Valid {
+			c := compareChirpKey(chirp, afterCreatedAt.Time, afterID.UUID)
+			if (!desc && c <= 0) || (desc && c >= 0) {
+				continue
+			}
+		}
+		items = append(items, chirp)
+	}
+	sortChirps(items, desc)
+	if len(items) > int(limit) {
+		items = items[:limit]
+	}
+	return items
+}
+
+// compareChirpKey compares (chirp.CreatedAt, chirp.ID) with (createdAt, id)
+// the way Postgres compares the row values, UUIDs byte by byte.
+func compareChirpKey(chirp Chirp, createdAt time.Time, id uuid.UUID) int {
+	if c := chirp.CreatedAt.Compare(createdAt); c != 0 {
+		return c
+	}
+	return bytes.Compare(chirp.ID[:], id[:])
+}
+
+func sortChirps(chirps []Chirp, desc bool) {
+	slices.SortStableFunc(chirps, func(a, b Chirp) int {
+		c := compareChirpKey(a, b.CreatedAt, b.ID)
+		if desc {
+			return -c
+		}
+		return c
+	})
+}
+
+// SearchChirps approximates Postgres full-text search: every word of the
+// query must appear in the chirp (ignoring case and a plural "s"), and chirps
+// are ranked by the share of their words that matched.
+func (m *MemoryStore) SearchChirps(ctx context.Context, arg SearchChirpsParams) ([]SearchChirpsRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	terms := map[string]bool{}
+	for _, word := range searchWords(arg.Query) {
+		terms[word.term] = true
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	var items []SearchChirpsRow
+	for _, chirp := range m.chirps {
+		if chirp.DeletedAt.Valid || (arg.UserID.Valid && chirp.UserID != arg.UserID.UUID) {
+			continue
+		}
+		words := searchWords(chirp.Body)
+		found := map[string]bool{}
+		matches := 0
+		var headline strings.Builder
+		last := 0
+		for _, word := range words {
+			if !terms[word.term] {
+				continue
+			}
+			found[word.term] = true
+			matches++
+			headline.WriteString(chirp.Body[last:word.start])
+			headline.WriteString(arg.StartSel + chirp.Body[word.start:word.end] + arg.StopSel)
+			last = word.end
+		}
+		if len(found) < len(terms) {
+			continue
+		}
+		headline.WriteString(chirp.Body[last:])
+		items = append(items, SearchChirpsRow{
+			Chirp:    chirp,
+			Rank:     float32(matches) / float32(len(words)),
+			Headline: headline.String(),
+		})
+	}
+
+	slices.SortStableFunc(items, func(a, b SearchChirpsRow) int {
+		if c := cmp.Compare(b.Rank, a.Rank); c != 0 {
+			return c
+		}
+		return -compareChirpKey(a.Chirp, b.Chirp.CreatedAt, b.Chirp.ID)
+	})
+	// Postgres refuses a negative OFFSET; treat it as none rather than
+	// slicing out of range.
+	items = items[min(max(int(arg.RowOffset), 0), len(items)):]
+	if len(items) > int(arg.RowLimit) {
+		items = items[:arg.RowLimit]
+	}
+	return items, nil
+}
+
+type searchWord struct {
+	start, end int
+	term       string
+}
+
+// searchWords splits s into words made of letters and digits, remembering
+// where each one starts and ends.
+func searchWords(s string) []searchWord {
+	var words []searchWord
+	start := -1
+	for i, r := range s + " " {
+		isWordRune := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWordRune && start < 0 {
+			start = i
+		}
+		if !isWordRune && start >= 0 {
+			term := strings.TrimSuffix(strings.ToLower(s[start:i]), "s")
+			words = append(words, searchWord{start: start, end: i, term: term})
+			start = -1
+		}
+	}
+	return words
+}
+
+// LikeChirp and UnlikeChirp also keep like_count up to date, which a
+// trigger does in Postgres.
+func (m *MemoryStore) LikeChirp(ctx context.Context, arg LikeChirpParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chirp := m.chirpIndex(arg.ChirpID)
+	if m.userIndex(arg.UserID) < 0 || chirp < 0 {
+		return errors.New("insert or update on table \"chirp_likes\" violates foreign key constraint")
+	}
+	if m.likeIndex(arg.UserID, arg.ChirpID) >= 0 {
+		return nil
+	}
+	m.likes = append(m.likes, ChirpLike{
+		UserID:    arg.UserID,
+		ChirpID:   arg.ChirpID,
+		CreatedAt: now(),
+	})
+	m.chirps[chirp].LikeCount++
+	return nil
+}
+
+func (m *MemoryStore) UnlikeChirp(ctx context.Context, arg UnlikeChirpParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.likeIndex(arg.UserID, arg.ChirpID)
+	if i < 0 {
+		return nil
+	}
+	m.likes = slices.Delete(m.likes, i, i+1)
+	if chirp := m.chirpIndex(arg.ChirpID); chirp >= 0 {
+		m.chirps[chirp].LikeCount--
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetLikedChirpIDs(ctx context.Context, arg GetLikedChirpIDsParams) ([]uuid.UUID, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []uuid.UUID
+	for _, like := range m.likes {
+		if like.UserID == arg.UserID && slices.Contains(arg.ChirpIds, like.ChirpID) {
+			items = append(items, like.ChirpID)
+		}
+	}
+	return items, nil
+}
+
+// likeIndex returns the position of the like in m.likes, or -1. The caller
+// must hold m.mu.
+func (m *MemoryStore) likeIndex(userID, chirpID uuid.UUID) int {
+	for i, like := range m.likes {
+		if like.UserID == userID && like.ChirpID == chirpID {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *MemoryStore) UpsertTag(ctx context.Context, name string) (Tag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, tag := range m.tags {
+		if tag.Name == name {
+			return tag, nil
+		}
+	}
+	tag := Tag{
+		ID:        uuid.New(),
+		Name:      name,
+		CreatedAt: now(),
+	}
+	m.tags = append(m.tags, tag)
+	return tag, nil
+}
+
+func (m *MemoryStore) AddChirpTag(ctx context.Context, arg AddChirpTagParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.chirpIndex(arg.ChirpID) < 0 || m.tagIndex(arg.TagID) < 0 {
+		return errors.New("insert or update on table \"chirp_tags\" violates foreign key constraint")
+	}
+	for _, ct := range m.chirpTags {
+		if ct.ChirpID == arg.ChirpID && ct.TagID == arg.TagID {
+			return nil
+		}
+	}
+	m.chirpTags = append(m.chirpTags, ChirpTag{
+		ChirpID:   arg.ChirpID,
+		TagID:     arg.TagID,
+		CreatedAt: now(),
+	})
+	return nil
+}
+
+func (m *MemoryStore) PruneChirpTags(ctx context.Context, arg PruneChirpTagsParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.chirpTags = filter(m.chirpTags, func(ct ChirpTag) bool {
+		i := m.tagIndex(ct.TagID)
+		return ct.ChirpID != arg.ChirpID || slices.Contains(arg.Keep, m.tags[i].Name)
+	})
+	return nil
+}
+
+func (m *MemoryStore) GetChirpsByTag(ctx context.Context, arg GetChirpsByTagParams) ([]Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tagged := map[uuid.UUID]bool{}
+	for _, ct := range m.chirpTags {
+		if i := m.tagIndex(ct.TagID); i >= 0 && m.tags[i].Name == arg.Tag {
+			tagged[ct.ChirpID] = true
+		}
+	}
+	keep := func(c Chirp) bool { return tagged[c.ID] }
+	return listChirps(m.chirps, keep, arg.AfterCreatedAt, arg.AfterID, arg.RowLimit, true), nil
+}
+
+// GetTrendingTags computes the same time-decayed score as the SQL query.
+func (m *MemoryStore) GetTrendingTags(ctx context.Context, arg GetTrendingTagsParams) ([]GetTrendingTagsRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	t := now()
+	since := t.Add(-time.Duration(arg.WindowSeconds * float64(time.Second)))
+	rows := map[uuid.UUID]*GetTrendingTagsRow{}
+	for _, ct := range m.chirpTags {
+		if !ct.CreatedAt.After(since) {
+			continue
+		}
+		if c := m.chirpIndex(ct.ChirpID); c < 0 || m.chirps[c].DeletedAt.Valid {
+			continue
+		}
+		row, ok := rows[ct.TagID]
+		if !ok {
+			row = &GetTrendingTagsRow{Name: m.tags[m.tagIndex(ct.TagID)].Name}
+			rows[ct.TagID] = row
+		}
+		row.Uses++
+		row.Score += math.Pow(0.5, t.Sub(ct.CreatedAt).Seconds()/arg.HalfLifeSeconds)
+	}
+
+	items := make([]GetTrendingTagsRow, 0, len(rows))
+	for _, row := range rows {
+		items = append(items, *row)
+	}
+	slices.SortFunc(items, func(a, b GetTrendingTagsRow) int {
+		if a.Score != b.Score {
+			return cmp.Compare(b.Score, a.Score)
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	if len(items) > int(arg.RowLimit) {
+		items = items[:arg.RowLimit]
+	}
+	return items, nil
+}
+
+// tagIndex returns the position of the tag in m.tags, or -1. The caller must
+// hold m.mu.
+func (m *MemoryStore) tagIndex(id uuid.UUID) int {
+	for i, tag := range m.tags {
+		if tag.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *MemoryStore) FollowUser(ctx context.Context, arg FollowUserParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.userIndex(arg.FollowerID) < 0 || m.userIndex(arg.FolloweeID) < 0 {
+		return errors.New("insert or update on table \"follows\" violates foreign key constraint")
+	}
+	if arg.FollowerID == arg.FolloweeID {
+		return errors.New("new row for relation \"follows\" violates check constraint")
+	}
+	if m.followIndex(arg.FollowerID, arg.FolloweeID) >= 0 {
+		return nil
+	}
+	m.follows = append(m.follows, Follow{
+		FollowerID: arg.FollowerID,
+		FolloweeID: arg.FolloweeID,
+		CreatedAt:  now(),
+	})
+	return nil
+}
+
+func (m *MemoryStore) UnfollowUser(ctx context.Context, arg UnfollowUserParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.follows = filter(m.follows, func(f Follow) bool {
+		return f.FollowerID != arg.FollowerID || f.FolloweeID != arg.FolloweeID
+	})
+	return nil
+}
+
+func (m *MemoryStore) GetFollowers(ctx context.Context, followeeID uuid.UUID) ([]User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []User
+	for _, f := range slices.Backward(m.follows) {
+		if f.FolloweeID == followeeID {
+			items = append(items, m.users[m.userIndex(f.FollowerID)])
+		}
+	}
+	return items, nil
+}
+
+func (m *MemoryStore) GetFollowing(ctx context.Context, followerID uuid.UUID) ([]User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []User
+	for _, f := range slices.Backward(m.follows) {
+		if f.FollowerID == followerID {
+			items = append(items, m.users[m.userIndex(f.FolloweeID)])
+		}
+	}
+	return items, nil
+}
+
+func (m *MemoryStore) GetTimeline(ctx context.Context, arg GetTimelineParams) ([]Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	followed := func(chirp Chirp) bool {
+		return m.followIndex(arg.FollowerID, chirp.UserID) >= 0
+	}
+	return listChirps(m.chirps, followed, arg.AfterCreatedAt, arg.AfterID, arg.RowLimit, true), nil
+}
+
+// followIndex returns the position of the follow in m.follows, or -1. The
+// caller must hold m.mu.
+func (m *MemoryStore) followIndex(followerID, followeeID uuid.UUID) int {
+	for i, f := range m.follows {
+		if f.FollowerID == followerID && f.FolloweeID == followeeID {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *MemoryStore) CreateNotification(ctx context.Context, arg CreateNotificationParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.userIndex(arg.UserID) < 0 || m.userIndex(arg.ActorID) < 0 || m.chirpIndex(arg.ChirpID) < 0 {
+		return errors.New("insert or update on table \"notifications\" violates foreign key constraint")
+	}
+	if arg.Kind != "mention" {
+		return errors.New("new row for relation \"notifications\" violates check constraint")
+	}
+	for _, n := range m.notifications {
+		if n.UserID == arg.UserID && n.ChirpID == arg.ChirpID && n.Kind == arg.Kind {
+			return nil
+		}
+	}
+	m.notifications = append(m.notifications, Notification{
+		ID:        uuid.New(),
+		CreatedAt: now(),
+		UserID:    arg.UserID,
+		ActorID:   arg.ActorID,
+		ChirpID:   arg.ChirpID,
+		Kind:      arg.Kind,
+	})
+	return nil
+}
+
+func (m *MemoryStore) ListNotifications(ctx context.Context, arg ListNotificationsParams) ([]ListNotificationsRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []ListNotificationsRow
+	for _, n := range m.notifications {
+		if n.UserID != arg.UserID || (arg.UnreadOnly && n.ReadAt.Valid) {
+			continue
+		}
+		if c := m.chirpIndex(n.ChirpID); c < 0 || m.chirps[c].DeletedAt.Valid {
+			continue
+		}
+		if arg.AfterCreatedAt.Valid && compareNotificationKey(n, arg.AfterCreatedAt.Time, arg.AfterID.UUID) >= 0 {
+			continue
+		}
+		items = append(items, ListNotificationsRow{
+			Notification: n,
+			ActorHandle:  m.users[m.userIndex(n.ActorID)].Handle,
+		})
+	}
+	slices.SortFunc(items, func(a, b ListNotificationsRow) int {
+		return -compareNotificationKey(a.Notification, b.Notification.CreatedAt, b.Notification.ID)
+	})
+	if len(items) > int(arg.RowLimit) {
+		items = items[:arg.RowLimit]
+	}
+	return items, nil
+}
+
+// compareNotificationKey orders notifications by (created_at, id), like
+// compareChirpKey does for chirps.
+func compareNotificationKey(n Notification, createdAt time.Time, id uuid.UUID) int {
+	if c := n.CreatedAt.Compare(createdAt); c != 0 {
+		return c
+	}
+	return bytes.Compare(n.ID[:], id[:])
+}
+
+func (m *MemoryStore) MarkAllNotificationsRead(ctx context.Context, userID uuid.UUID) error {
+	return m.markNotificationsRead(func(n Notification) bool { return n.UserID == userID })
+}
+
+func (m *MemoryStore) MarkNotificationsRead(ctx context.Context, arg MarkNotificationsReadParams) error {
+	return m.markNotificationsRead(func(n Notification) bool {
+		return n.UserID == arg.UserID && slices.Contains(arg.Ids, n.ID)
+	})
+}
+
+func (m *MemoryStore) markNotificationsRead(match func(Notification) bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := now()
+	for i, n := range m.notifications {
+		if match(n) && !n.ReadAt.Valid {
+			m.notifications[i].ReadAt = sql.NullTime{Time: t, Valid: true}
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.userIndex(arg.UserID) < 0 {
+		return RefreshToken{}, errors.New("insert or update on table \"refresh_tokens\" violates foreign key constraint")
+	}
+	for _, rt := range m.refreshTokens {
+		if rt.TokenHash == arg.TokenHash {
+			return RefreshToken{}, ErrUniqueViolation
+		}
+	}
+	t := now()
+	rt := RefreshToken{
+		TokenHash: arg.TokenHash,
+		CreatedAt: t,
+		UpdatedAt: t,
+		UserID:    arg.UserID,
+		ExpiresAt: arg.ExpiresAt,
+		FamilyID:  arg.FamilyID,
+		UserAgent: arg.UserAgent,
+		Ip:        arg.Ip,
+	}
+	m.refreshTokens = append(m.refreshTokens, rt)
+	return rt, nil
+}
+
+func (m *MemoryStore) GetUserFromRefreshToken(ctx context.Context, tokenHash string) (User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, rt := range m.refreshTokens {
+		if rt.TokenHash != tokenHash || rt.RevokedAt.Valid || !rt.ExpiresAt.After(time.Now()) {
+			continue
+		}
+		if i := m.userIndex(rt.UserID); i >= 0 {
+			return m.users[i], nil
+		}
+	}
+	return User{}, sql.ErrNoRows
+}
+
+func (m *MemoryStore) GetRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, rt := range m.refreshTokens {
+		if rt.TokenHash == tokenHash {
+			return rt, nil
+		}
+	}
+	return RefreshToken{}, sql.ErrNoRows
+}
+
+func (m *MemoryStore) RevokeRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, rt := range m.refreshTokens {
+		if rt.TokenHash == tokenHash {
+			t := now()
+			m.refreshTokens[i].RevokedAt = sql.NullTime{Time: t, Valid: true}
+			m.refreshTokens[i].UpdatedAt = t
+			return m.refreshTokens[i], nil
+		}
+	}
+	return RefreshToken{}, sql.ErrNoRows
+}
+
+func (m *MemoryStore) RotateRefreshToken(ctx context.Context, arg RotateRefreshTokenParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, rt := range m.refreshTokens {
+		if rt.TokenHash == arg.TokenHash && !rt.RevokedAt.Valid {
+			t := now()
+			m.refreshTokens[i].RevokedAt = sql.NullTime{Time: t, Valid: true}
+			m.refreshTokens[i].UpdatedAt = t
+			m.refreshTokens[i].ReplacedBy = arg.ReplacedBy
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *MemoryStore) RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	m.revokeRefreshTokens(func(rt RefreshToken) bool { return rt.FamilyID == familyID })
+	return nil
+}
+
+func (m *MemoryStore) ListSessions(ctx context.Context, userID uuid.UUID) ([]ListSessionsRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	started := map[uuid.UUID]time.Time{}
+	for _, rt := range m.refreshTokens {
+		if t, ok := started[rt.FamilyID]; !ok || rt.CreatedAt.Before(t) {
+			started[rt.FamilyID] = rt.CreatedAt
+		}
+	}
+	var items []ListSessionsRow
+	for _, rt := range m.refreshTokens {
+		if rt.UserID != userID || rt.RevokedAt.Valid || !rt.ExpiresAt.After(time.Now()) {
+			continue
+		}
+		items = append(items, ListSessionsRow{
+			FamilyID:   rt.FamilyID,
+			CreatedAt:  started[rt.FamilyID],
+			LastUsedAt: rt.CreatedAt,
+			ExpiresAt:  rt.ExpiresAt,
+			UserAgent:  rt.UserAgent,
+			Ip:         rt.Ip,
+		})
+	}
+	slices.SortStableFunc(items, func(a, b ListSessionsRow) int {
+		return b.LastUsedAt.Compare(a.LastUsedAt)
+	})
+	return items, nil
+}
+
+func (m *MemoryStore) RevokeSession(ctx context.Context, arg RevokeSessionParams) (int64, error) {
+	return m.revokeRefreshTokens(func(rt RefreshToken) bool {
+		return rt.FamilyID == arg.FamilyID && rt.UserID == arg.UserID && rt.ExpiresAt.After(time.Now())
+	}), nil
+}
+
+func (m *MemoryStore) RevokeUserRefreshTokens(ctx context.Context, userID uuid.UUID) error {
+	m.revokeRefreshTokens(func(rt RefreshToken) bool { return rt.UserID == userID })
+	return nil
+}
+
+// revokeRefreshTokens revokes every unrevoked token matching match and
+// returns how many there were.
+func (m *MemoryStore) revokeRefreshTokens(match func(RefreshToken) bool) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	t := now()
+	for i, rt := range m.refreshTokens {
+		if match(rt) && !rt.RevokedAt.Valid {
+			m.refreshTokens[i].RevokedAt = sql.NullTime{Time: t, Valid: true}
+			m.refreshTokens[i].UpdatedAt = t
+			n++
+		}
+	}
+	return n
+}
+
+func (m *MemoryStore) CreateEmailVerificationToken(ctx context.Context, arg CreateEmailVerificationTokenParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.userIndex(arg.UserID) < 0 {
+		return errors.New("insert or update on table \"email_verification_tokens\" violates foreign key constraint")
+	}
+	for _, v := range m.verifications {
+		if v.TokenHash == arg.TokenHash {
+			return ErrUniqueViolation
+		}
+	}
+	m.verifications = append(m.verifications, EmailVerificationToken{
+		TokenHash: arg.TokenHash,
+		CreatedAt: now(),
+		UserID:    arg.UserID,
+		Email:     arg.Email,
+		ExpiresAt: arg.ExpiresAt,
+	})
+	return nil
+}
+
+func (m *MemoryStore) UseEmailVerificationToken(ctx context.Context, tokenHash string) (EmailVerificationToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, v := range m.verifications {
+		if v.TokenHash == tokenHash && !v.UsedAt.Valid && v.ExpiresAt.After(time.Now()) {
+			m.verifications[i].UsedAt = sql.NullTime{Time: now(), Valid: true}
+			return m.verifications[i], nil
+		}
+	}
+	return EmailVerificationToken{}, sql.ErrNoRows
+}
+
+func (m *MemoryStore) CreatePasswordResetToken(ctx context.Context, arg CreatePasswordResetTokenParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.userIndex(arg.UserID) < 0 {
+		return errors.New("insert or update on table \"password_reset_tokens\" violates foreign key constraint")
+	}
+	for _, reset := range m.resets {
+		if reset.TokenHash == arg.TokenHash {
+			return ErrUniqueViolation
+		}
+	}
+	m.resets = append(m.resets, PasswordResetToken{
+		TokenHash: arg.TokenHash,
+		CreatedAt: now(),
+		UserID:    arg.UserID,
+		Email:     arg.Email,
+		ExpiresAt: arg.ExpiresAt,
+	})
+	return nil
+}
+
+func (m *MemoryStore) UsePasswordResetToken(ctx context.Context, tokenHash string) (PasswordResetToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, reset := range m.resets {
+		if reset.TokenHash == tokenHash && !reset.UsedAt.Valid && reset.ExpiresAt.After(time.Now()) {
+			m.resets[i].UsedAt = sql.NullTime{Time: now(), Valid: true}
+			return m.resets[i], nil
+		}
+	}
+	return PasswordResetToken{}, sql.ErrNoRows
+}
+
+func (m *MemoryStore) ExpirePasswordResetTokens(ctx context.Context, userID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := now()
+	for i, reset := range m.resets {
+		if reset.UserID == userID && !reset.UsedAt.Valid {
+			m.resets[i].UsedAt = sql.NullTime{Time: t, Valid: true}
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) CreateTOTPSecret(ctx context.Context, arg CreateTOTPSecretParams) (TotpSecret, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.userIndex(arg.UserID) < 0 {
+		return TotpSecret{}, errors.New("insert or update on table \"totp_secrets\" violates foreign key constraint")
+	}
+	secret := TotpSecret{UserID: arg.UserID, CreatedAt: now(), Secret: arg.Secret}
+	for i, existing := range m.totpSecrets {
+		if existing.UserID != arg.UserID {
+			continue
+		}
+		if existing.ConfirmedAt.Valid {
+			return TotpSecret{}, sql.ErrNoRows
+		}
+		secret.LastUsedStep = existing.LastUsedStep
+		m.totpSecrets[i] = secret
+		return secret, nil
+	}
+	m.totpSecrets = append(m.totpSecrets, secret)
+	return secret, nil
+}
+
+func (m *MemoryStore) GetTOTPSecret(ctx context.Context, userID uuid.UUID) (TotpSecret, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, secret := range m.totpSecrets {
+		if secret.UserID == userID {
+			return secret, nil
+		}
+	}
+	return TotpSecret{}, sql.ErrNoRows
+}
+
+func (m *MemoryStore) ConfirmTOTPSecret(ctx context.Context, arg ConfirmTOTPSecretParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, secret := range m.totpSecrets {
+		if secret.UserID == arg.UserID && !secret.ConfirmedAt.Valid && secret.LastUsedStep < arg.LastUsedStep {
+			m.totpSecrets[i].ConfirmedAt = sql.NullTime{Time: now(), Valid: true}
+			m.totpSecrets[i].LastUsedStep = arg.LastUsedStep
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *MemoryStore) UseTOTPStep(ctx context.Context, arg UseTOTPStepParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, secret := range m.totpSecrets {
+		if secret.UserID == arg.UserID && secret.ConfirmedAt.Valid && secret.LastUsedStep < arg.LastUsedStep {
+			m.totpSecrets[i].LastUsedStep = arg.LastUsedStep
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *MemoryStore) DeleteTOTPSecret(ctx context.Context, userID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.totpSecrets = filter(m.totpSecrets, func(secret TotpSecret) bool { return secret.UserID != userID })
+	return nil
+}
+
+func (m *MemoryStore) CreateRecoveryCodes(ctx context.Context, arg CreateRecoveryCodesParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.userIndex(arg.UserID) < 0 {
+		return errors.New("insert or update on table \"recovery_codes\" violates foreign key constraint")
+	}
+	for i, hash := range arg.CodeHashes {
+		if slices.Contains(arg.CodeHashes[:i], hash) || slices.ContainsFunc(m.recoveryCodes, func(code RecoveryCode) bool { return code.CodeHash == hash }) {
+			return ErrUniqueViolation
+		}
+	}
+	t := now()
+	for _, hash := range arg.CodeHashes {
+		m.recoveryCodes = append(m.recoveryCodes, RecoveryCode{CodeHash: hash, CreatedAt: t, UserID: arg.UserID})
+	}
+	return nil
+}
+
+func (m *MemoryStore) UseRecoveryCode(ctx context.Context, arg UseRecoveryCodeParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, code := range m.recoveryCodes {
+		if code.UserID == arg.UserID && code.CodeHash == arg.CodeHash && !code.UsedAt.Valid {
+			m.recoveryCodes[i].UsedAt = sql.NullTime{Time: now(), Valid: true}
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *MemoryStore) DeleteRecoveryCodes(ctx context.Context, userID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.recoveryCodes = filter(m.recoveryCodes, func(code RecoveryCode) bool { return code.UserID != userID })
+	return nil
+}
+
+func (m *MemoryStore) ListBannedWords(ctx context.Context) ([]BannedWord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	words := slices.Clone(m.bannedWords)
+	slices.SortFunc(words, func(a, b BannedWord) int { return strings.Compare(a.Word, b.Word) })
+	return words, nil
+}
+
+func (m *MemoryStore) UpsertBannedWord(ctx context.Context, arg UpsertBannedWordParams) (BannedWord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !slices.Contains([]string{"mask", "reject", "flag"}, arg.Policy) {
+		return BannedWord{}, errors.New("new row for relation \"banned_words\" violates check constraint")
+	}
+	for i, word := range m.bannedWords {
+		if word.Word == arg.Word {
+			m.bannedWords[i].Policy = arg.Policy
+			m.bannedWords[i].UpdatedAt = now()
+			return m.bannedWords[i], nil
+		}
+	}
+	word := BannedWord{
+		Word:      arg.Word,
+		CreatedAt: now(),
+		UpdatedAt: now(),
+		Policy:    arg.Policy,
+	}
+	m.bannedWords = append(m.bannedWords, word)
+	return word, nil
+}
+
+func (m *MemoryStore) DeleteBannedWord(ctx context.Context, word string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	before := len(m.bannedWords)
+	m.bannedWords = filter(m.bannedWords, func(w BannedWord) bool { return w.Word != word })
+	return int64(before - len(m.bannedWords)), nil
+}
+
+func (m *MemoryStore) FlagChirp(ctx context.Context, arg FlagChirpParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.chirpIndex(arg.ChirpID) < 0 {
+		return errors.New("insert or update on table \"chirp_flags\" violates foreign key constraint")
+	}
+	flag := ChirpFlag{
+		ChirpID:   arg.ChirpID,
+		CreatedAt: now(),
+		Words:     slices.Clone(arg.Words),
+	}
+	for i := range m.chirpFlags {
+		if m.chirpFlags[i].ChirpID == arg.ChirpID {
+			m.chirpFlags[i] = flag
+			return nil
+		}
+	}
+	m.chirpFlags = append(m.chirpFlags, flag)
+	return nil
+}
+
+func (m *MemoryStore) ListFlaggedChirps(ctx context.Context, arg ListFlaggedChirpsParams) ([]ListFlaggedChirpsRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	flagged := map[uuid.UUID][]string{}
+	for _, flag := range m.chirpFlags {
+		if !flag.ReviewedAt.Valid {
+			flagged[flag.ChirpID] = flag.Words
+		}
+	}
+	chirps := listChirps(m.chirps, func(c Chirp) bool {
+		_, ok := flagged[c.ID]
+		return ok
+	}, arg.AfterCreatedAt, arg.AfterID, arg.RowLimit, false)
+
+	var items []ListFlaggedChirpsRow
+	for _, chirp := range chirps {
+		items = append(items, ListFlaggedChirpsRow{
+			Chirp:        chirp,
+			FlaggedWords: slices.Clone(flagged[chirp.ID]),
+		})
+	}
+	return items, nil
+}
+
+func (m *MemoryStore) ReviewChirpFlag(ctx context.Context, chirpID uuid.UUID) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, flag := range m.chirpFlags {
+		if flag.ChirpID == chirpID && !flag.ReviewedAt.Valid {
+			m.chirpFlags[i].ReviewedAt = sql.NullTime{Time: now(), Valid: true}
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *MemoryStore) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.emailTaken(arg.Email, uuid.Nil) || m.handleTaken(arg.Handle, uuid.Nil) {
+		return User{}, ErrUniqueViolation
+	}
+	t := now()
+	user := User{
+		ID:             uuid.New(),
+		CreatedAt:      t,
+		UpdatedAt:      t,
+		Email:          arg.Email,
+		HashedPassword: arg.HashedPassword,
+		Handle:         arg.Handle,
+		Role:           "user",
+	}
+	m.users = append(m.users, user)
+	return user, nil
+}
+
+// DeleteUsers removes every user along with the rows that reference them
+// through ON DELETE CASCADE foreign keys.
+func (m *MemoryStore) DeleteUsers(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.users = nil
+	m.chirps = nil
+	m.refreshTokens = nil
+	m.follows = nil
+	m.likes = nil
+	m.chirpTags = nil
+	m.notifications = nil
+	m.verifications = nil
+	m.resets = nil
+	m.totpSecrets = nil
+	m.recoveryCodes = nil
+	m.chirpFlags = nil
+	m.chirpImports = nil
+	m.chirpPosts = nil
+	return nil
+}
+
+func (m *MemoryStore) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, user := range m.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return User{}, sql.ErrNoRows
+}
+
+func (m *MemoryStore) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if i := m.userIndex(id); i >= 0 {
+		return m.users[i], nil
+	}
+	return User{}, sql.ErrNoRows
+}
+
+func (m *MemoryStore) GetUsersByHandles(ctx context.Context, handles []string) ([]User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []User
+	for _, user := range m.users {
+		if slices.Contains(handles, user.Handle) {
+			items = append(items, user)
+		}
+	}
+	return items, nil
+}
+
+func (m *MemoryStore) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.userIndex(arg.ID)
+	if i < 0 {
+		return User{}, sql.ErrNoRows
+	}
+	if m.emailTaken(arg.Email, arg.ID) || (arg.Handle.Valid && m.handleTaken(arg.Handle.String, arg.ID)) {
+		return User{}, ErrUniqueViolation
+	}
+	if m.users[i].Email != arg.Email {
+		m.users[i].EmailVerified = false
+	}
+	m.users[i].Email = arg.Email
+	m.users[i].HashedPassword = arg.HashedPassword
+	if arg.Handle.Valid {
+		m.users[i].Handle = arg.Handle.String
+	}
+	m.users[i].UpdatedAt = now()
+	return m.users[i], nil
+}
+
+func (m *MemoryStore) UpdatePassword(ctx context.Context, arg UpdatePasswordParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if i := m.userIndex(arg.ID); i >= 0 {
+		m.users[i].HashedPassword = arg.HashedPassword
+		m.users[i].UpdatedAt = now()
+	}
+	return nil
+}
+
+// LockUser has nothing to do: InTx already runs one transaction at a time.
+func (m *MemoryStore) LockUser(ctx context.Context, id uuid.UUID) error {
+	return nil
+}
+
+func (m *MemoryStore) SetUserRole(ctx context.Context, arg SetUserRoleParams) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !slices.Contains([]string{"user", "moderator", "admin"}, arg.Role) {
+		return User{}, errors.New("new row for relation \"users\" violates check constraint")
+	}
+	i := m.userIndex(arg.ID)
+	if i < 0 {
+		return User{}, sql.ErrNoRows
+	}
+	m.users[i].Role = arg.Role
+	m.users[i].UpdatedAt = now()
+	return m.users[i], nil
+}
+
+func (m *MemoryStore) UpdateSubscription(ctx context.Context, arg UpdateSubscriptionParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.userIndex(arg.ID)
+	if i < 0 || !m.applySubscriptionEvent(i, arg.EventAt) {
+		return 0, nil
+	}
+	m.users[i].IsChirpyRed = true
+	m.users[i].ChirpyRedExpiresAt = arg.ChirpyRedExpiresAt
+	m.users[i].PaymentFailedAt = sql.NullTime{}
+	m.users[i].UpdatedAt = now()
+	return 1, nil
+}
+
+func (m *MemoryStore) CancelSubscription(ctx context.Context, arg CancelSubscriptionParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.userIndex(arg.ID)
+	if i < 0 || !m.applySubscriptionEvent(i, arg.EventAt) {
+		return 0, nil
+	}
+	m.endSubscription(i)
+	return 1, nil
+}
+
+func (m *MemoryStore) RecordPaymentFailure(ctx context.Context, arg RecordPaymentFailureParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.userIndex(arg.ID)
+	if i < 0 || !m.users[i].IsChirpyRed || !m.applySubscriptionEvent(i, arg.EventAt) {
+		return 0, nil
+	}
+	if !m.users[i].PaymentFailedAt.Valid {
+		// GREATEST skips NULL, so a subscription with no end gets the grace
+		// period too.
+		expires := m.users[i].ChirpyRedExpiresAt
+		if !expires.Valid || expires.Time.Before(arg.GraceUntil) {
+			m.users[i].ChirpyRedExpiresAt = sql.NullTime{Time: arg.GraceUntil, Valid: true}
+		}
+		m.users[i].PaymentFailedAt = sql.NullTime{Time: now(), Valid: true}
+	}
+	m.users[i].UpdatedAt = now()
+	return 1, nil
+}
+
+func (m *MemoryStore) ExpireSubscriptions(ctx context.Context, asOf time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var expired int64
+	for i, user := range m.users {
+		if user.ChirpyRedExpiresAt.Valid && !user.ChirpyRedExpiresAt.Time.After(asOf) {
+			m.endSubscription(i)
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+// applySubscriptionEvent reports whether an event that happened at t is
+// no older than the last one applied to the subscription of the user at
+// index i, and if so records it as the last one. The caller must hold m.mu.
+func (m *MemoryStore) applySubscriptionEvent(i int, t time.Time) bool {
+	t = t.UTC().Truncate(time.Microsecond)
+	if last := m.users[i].SubscriptionEventAt; last.Valid && last.Time.After(t) {
+		return false
+	}
+	m.users[i].SubscriptionEventAt = sql.NullTime{Time: t, Valid: true}
+	return true
+}
+
+// endSubscription takes Chirpy Red away from the user at index i. The
+// caller must hold m.mu.
+func (m *MemoryStore) endSubscription(i int) {
+	m.users[i].IsChirpyRed = false
+	m.users[i].ChirpyRedExpiresAt = sql.NullTime{}
+	m.users[i].PaymentFailedAt = sql.NullTime{}
+	m.users[i].UpdatedAt = now()
+}
+
+func (m *MemoryStore) VerifyEmail(ctx context.Context, arg VerifyEmailParams) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.userIndex(arg.ID)
+	if i < 0 || m.users[i].Email != arg.Email {
+		return User{}, sql.ErrNoRows
+	}
+	m.users[i].EmailVerified = true
+	m.users[i].UpdatedAt = now()
+	return m.users[i], nil
+}
+
+// userIndex returns the position of the user in m.users, or -1. The caller
+// must hold m.mu.
+func (m *MemoryStore) userIndex(id uuid.UUID) int {
+	for i, user := range m.users {
+		if user.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// emailTaken reports whether a user other than except already has email.
+// The caller must hold m.mu.
+func (m *MemoryStore) emailTaken(email string, except uuid.UUID) bool {
+	for _, user := range m.users {
+		if user.Email == email && user.ID != except {
+			return true
+		}
+	}
+	return false
+}
+
+// handleTaken reports whether a user other than except already has handle.
+// The caller must hold m.mu.
+func (m *MemoryStore) handleTaken(handle string, except uuid.UUID) bool {
+	for _, user := range m.users {
+		if user.Handle == handle && user.ID != except {
+			return true
+		}
+	}
+	return false
+}
+
+func filter[T any](items []T, keep func(T) bool) []T {
+	var kept []T
+	for _, item := range items {
+		if keep(item) {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
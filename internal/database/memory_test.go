@@ -0,0 +1,132 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreUniqueEmail(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	_, err := store.CreateUser(ctx, CreateUserParams{Email: "a@example.com", HashedPassword: "x"})
+	if err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+	_, err = store.CreateUser(ctx, CreateUserParams{Email: "a@example.com", HashedPassword: "y"})
+	if !errors.Is(err, ErrUniqueViolation) {
+		t.Fatalf("CreateUser() with duplicate email error = %v, want %v", err, ErrUniqueViolation)
+	}
+}
+
+func TestMemoryStoreRefreshTokens(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	user, _ := store.CreateUser(ctx, CreateUserParams{Email: "a@example.com", HashedPassword: "x"})
+
+	store.CreateRefreshToken(ctx, CreateRefreshTokenParams{TokenHash: "valid", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)})
+	store.CreateRefreshToken(ctx, CreateRefreshTokenParams{TokenHash: "expired", UserID: user.ID, ExpiresAt: time.Now().Add(-time.Hour)})
+	store.CreateRefreshToken(ctx, CreateRefreshTokenParams{TokenHash: "revoked", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)})
+	if _, err := store.RevokeRefreshToken(ctx, "revoked"); err != nil {
+		t.Fatalf("RevokeRefreshToken() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{name: "Valid token", token: "valid", wantErr: nil},
+		{name: "Expired token", token: "expired", wantErr: sql.ErrNoRows},
+		{name: "Revoked token", token: "revoked", wantErr: sql.ErrNoRows},
+		{name: "Unknown token", token: "unknown", wantErr: sql.ErrNoRows},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.GetUserFromRefreshToken(ctx, tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GetUserFromRefreshToken() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got.ID != user.ID {
+				t.Errorf("GetUserFromRefreshToken() user = %v, want %v", got.ID, user.ID)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreDeleteUsersCascades(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	user, _ := store.CreateUser(ctx, CreateUserParams{Email: "a@example.com", HashedPassword: "x"})
+	store.CreateChirp(ctx, CreateChirpParams{Body: "hello", UserID: user.ID, Kind: "chirp"})
+	store.CreateRefreshToken(ctx, CreateRefreshTokenParams{TokenHash: "t", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)})
+
+	if err := store.DeleteUsers(ctx); err != nil {
+		t.Fatalf("DeleteUsers() error = %v", err)
+	}
+	chirps, _ := store.GetChirps(ctx)
+	if len(chirps) != 0 {
+		t.Errorf("GetChirps() after DeleteUsers() returned %d chirps, want 0", len(chirps))
+	}
+	if _, err := store.RevokeRefreshToken(ctx, "t"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("RevokeRefreshToken() after DeleteUsers() error = %v, want %v", err, sql.ErrNoRows)
+	}
+}
+
+func TestMemoryStoreSearchNegativeOffset(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	user, _ := store.CreateUser(ctx, CreateUserParams{Email: "a@example.com", HashedPassword: "x", Handle: "a"})
+	if _, err := store.CreateChirp(ctx, CreateChirpParams{Body: "learning go", UserID: user.ID, Kind: "chirp"}); err != nil {
+		t.Fatalf("CreateChirp() error = %v", err)
+	}
+
+	rows, err := store.SearchChirps(ctx, SearchChirpsParams{Query: "go", RowLimit: 10, RowOffset: -5})
+	if err != nil {
+		t.Fatalf("SearchChirps() error = %v", err)
+	}
+	if len(rows) != 1 {
+		t.Errorf("SearchChirps() with a negative offset = %d rows, want 1", len(rows))
+	}
+}
+
+func TestMemoryStoreInTx(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	user, _ := store.CreateUser(ctx, CreateUserParams{Email: "a@example.com", HashedPassword: "x", Handle: "a"})
+
+	failed := errors.New("tagging failed")
+	err := store.InTx(ctx, func(db Store) error {
+		if _, err := db.CreateChirp(ctx, CreateChirpParams{Body: "learning #go", UserID: user.ID, Kind: "chirp"}); err != nil {
+			return err
+		}
+		if _, err := db.UpsertTag(ctx, "go"); err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("InTx() error = %v, want %v", err, failed)
+	}
+	if chirps, _ := store.GetChirps(ctx); len(chirps) != 0 {
+		t.Errorf("chirps after a rolled back transaction = %d, want 0", len(chirps))
+	}
+	if len(store.tags) != 0 {
+		t.Errorf("tags after a rolled back transaction = %d, want 0", len(store.tags))
+	}
+
+	err = store.InTx(ctx, func(db Store) error {
+		_, err := db.CreateChirp(ctx, CreateChirpParams{Body: "learning #go", UserID: user.ID, Kind: "chirp"})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("InTx() error = %v", err)
+	}
+	if chirps, _ := store.GetChirps(ctx); len(chirps) != 1 {
+		t.Errorf("chirps after a committed transaction = %d, want 1", len(chirps))
+	}
+}
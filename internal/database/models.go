@@ -11,28 +11,144 @@ import (
 	"github.com/google/uuid"
 )
 
+type BannedWord struct {
+	Word      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Policy    string
+}
+
 type Chirp struct {
+	ID          uuid.UUID
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Body        string
+	UserID      uuid.UUID
+	InReplyTo   uuid.NullUUID
+	ReplyCount  int32
+	DeletedAt   sql.NullTime
+	LikeCount   int32
+	Kind        string
+	ReferenceID uuid.NullUUID
+}
+
+type ChirpFlag struct {
+	ChirpID    uuid.UUID
+	CreatedAt  time.Time
+	Words      []string
+	ReviewedAt sql.NullTime
+}
+
+type ChirpImport struct {
 	ID        uuid.UUID
+	UserID    uuid.UUID
 	CreatedAt time.Time
-	UpdatedAt time.Time
-	Body      string
+	Chirps    int32
+}
+
+type ChirpLike struct {
 	UserID    uuid.UUID
+	ChirpID   uuid.UUID
+	CreatedAt time.Time
 }
 
-type RefreshToken struct {
-	Token     string
+type ChirpPost struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	CreatedAt time.Time
+}
+
+type ChirpTag struct {
+	ChirpID   uuid.UUID
+	TagID     uuid.UUID
+	CreatedAt time.Time
+}
+
+type EmailVerificationToken struct {
+	TokenHash string
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	Email     string
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type Follow struct {
+	FollowerID uuid.UUID
+	FolloweeID uuid.UUID
+	CreatedAt  time.Time
+}
+
+type LoginFailure struct {
+	Key           string
+	Failures      int32
+	LastFailureAt time.Time
+}
+
+type Notification struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	ActorID   uuid.UUID
+	ChirpID   uuid.UUID
+	Kind      string
+	ReadAt    sql.NullTime
+}
+
+type PasswordResetToken struct {
+	TokenHash string
 	CreatedAt time.Time
-	UpdatedAt time.Time
 	UserID    uuid.UUID
+	Email     string
 	ExpiresAt time.Time
-	RevokedAt sql.NullTime
+	UsedAt    sql.NullTime
+}
+
+type RecoveryCode struct {
+	CodeHash  string
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	UsedAt    sql.NullTime
+}
+
+type RefreshToken struct {
+	TokenHash  string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	UserID     uuid.UUID
+	ExpiresAt  time.Time
+	RevokedAt  sql.NullTime
+	FamilyID   uuid.UUID
+	ReplacedBy sql.NullString
+	UserAgent  string
+	Ip         string
+}
+
+type Tag struct {
+	ID        uuid.UUID
+	Name      string
+	CreatedAt time.Time
+}
+
+type TotpSecret struct {
+	UserID       uuid.UUID
+	CreatedAt    time.Time
+	Secret       string
+	ConfirmedAt  sql.NullTime
+	LastUsedStep int64
 }
 
 type User struct {
-	ID             uuid.UUID
-	CreatedAt      time.Time
-	UpdatedAt      time.Time
-	Email          string
-	HashedPassword string
-	IsChirpyRed    bool
+	ID                  uuid.UUID
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+	Email               string
+	HashedPassword      string
+	IsChirpyRed         bool
+	Handle              string
+	EmailVerified       bool
+	Role                string
+	ChirpyRedExpiresAt  sql.NullTime
+	PaymentFailedAt     sql.NullTime
+	SubscriptionEventAt sql.NullTime
 }
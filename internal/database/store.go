@@ -0,0 +1,140 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Store is the set of queries the HTTP handlers depend on. *Queries
+// implements it on top of Postgres and MemoryStore implements it in-process.
+type Store interface {
+	// InTx runs fn against a Store whose writes all happen or none do: they
+	// are kept when fn returns nil and undone when it returns an error.
+	InTx(ctx context.Context, fn func(Store) error) error
+
+	CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error)
+	ImportChirp(ctx context.Context, arg ImportChirpParams) (Chirp, error)
+	UpdateChirpBody(ctx context.Context, arg UpdateChirpBodyParams) (Chirp, error)
+	GetChirps(ctx context.Context) ([]Chirp, error)
+	GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error)
+	GetChirpsByIDs(ctx context.Context, ids []uuid.UUID) ([]Chirp, error)
+	DeleteChirp(ctx context.Context, id uuid.UUID) error
+	TombstoneChirp(ctx context.Context, id uuid.UUID) error
+	GetChirpAncestors(ctx context.Context, id uuid.UUID) ([]Chirp, error)
+	GetChirpDescendants(ctx context.Context, id uuid.UUID) ([]Chirp, error)
+	GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Chirp, error)
+	ListChirpsAsc(ctx context.Context, arg ListChirpsAscParams) ([]Chirp, error)
+	ListChirpsDesc(ctx context.Context, arg ListChirpsDescParams) ([]Chirp, error)
+	SearchChirps(ctx context.Context, arg SearchChirpsParams) ([]SearchChirpsRow, error)
+	RecordChirpPost(ctx context.Context, userID uuid.UUID) error
+	GetPostingActivity(ctx context.Context, arg GetPostingActivityParams) (GetPostingActivityRow, error)
+	RecordChirpImport(ctx context.Context, arg RecordChirpImportParams) error
+	GetImportActivity(ctx context.Context, arg GetImportActivityParams) (GetImportActivityRow, error)
+
+	LikeChirp(ctx context.Context, arg LikeChirpParams) error
+	UnlikeChirp(ctx context.Context, arg UnlikeChirpParams) error
+	GetLikedChirpIDs(ctx context.Context, arg GetLikedChirpIDsParams) ([]uuid.UUID, error)
+
+	UpsertTag(ctx context.Context, name string) (Tag, error)
+	AddChirpTag(ctx context.Context, arg AddChirpTagParams) error
+	PruneChirpTags(ctx context.Context, arg PruneChirpTagsParams) error
+	GetChirpsByTag(ctx context.Context, arg GetChirpsByTagParams) ([]Chirp, error)
+	GetTrendingTags(ctx context.Context, arg GetTrendingTagsParams) ([]GetTrendingTagsRow, error)
+
+	FollowUser(ctx context.Context, arg FollowUserParams) error
+	UnfollowUser(ctx context.Context, arg UnfollowUserParams) error
+	GetFollowers(ctx context.Context, followeeID uuid.UUID) ([]User, error)
+	GetFollowing(ctx context.Context, followerID uuid.UUID) ([]User, error)
+	GetTimeline(ctx context.Context, arg GetTimelineParams) ([]Chirp, error)
+
+	CreateNotification(ctx context.Context, arg CreateNotificationParams) error
+	ListNotifications(ctx context.Context, arg ListNotificationsParams) ([]ListNotificationsRow, error)
+	MarkAllNotificationsRead(ctx context.Context, userID uuid.UUID) error
+	MarkNotificationsRead(ctx context.Context, arg MarkNotificationsReadParams) error
+
+	CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error)
+	GetUserFromRefreshToken(ctx context.Context, tokenHash string) (User, error)
+	GetRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error)
+	RevokeRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, arg RotateRefreshTokenParams) (int64, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error
+	ListSessions(ctx context.Context, userID uuid.UUID) ([]ListSessionsRow, error)
+	RevokeSession(ctx context.Context, arg RevokeSessionParams) (int64, error)
+	RevokeUserRefreshTokens(ctx context.Context, userID uuid.UUID) error
+
+	CreateEmailVerificationToken(ctx context.Context, arg CreateEmailVerificationTokenParams) error
+	UseEmailVerificationToken(ctx context.Context, tokenHash string) (EmailVerificationToken, error)
+
+	CreatePasswordResetToken(ctx context.Context, arg CreatePasswordResetTokenParams) error
+	UsePasswordResetToken(ctx context.Context, tokenHash string) (PasswordResetToken, error)
+	ExpirePasswordResetTokens(ctx context.Context, userID uuid.UUID) error
+
+	CreateTOTPSecret(ctx context.Context, arg CreateTOTPSecretParams) (TotpSecret, error)
+	GetTOTPSecret(ctx context.Context, userID uuid.UUID) (TotpSecret, error)
+	ConfirmTOTPSecret(ctx context.Context, arg ConfirmTOTPSecretParams) (int64, error)
+	UseTOTPStep(ctx context.Context, arg UseTOTPStepParams) (int64, error)
+	DeleteTOTPSecret(ctx context.Context, userID uuid.UUID) error
+
+	CreateRecoveryCodes(ctx context.Context, arg CreateRecoveryCodesParams) error
+	UseRecoveryCode(ctx context.Context, arg UseRecoveryCodeParams) (int64, error)
+	DeleteRecoveryCodes(ctx context.Context, userID uuid.UUID) error
+
+	ListBannedWords(ctx context.Context) ([]BannedWord, error)
+	UpsertBannedWord(ctx context.Context, arg UpsertBannedWordParams) (BannedWord, error)
+	DeleteBannedWord(ctx context.Context, word string) (int64, error)
+
+	FlagChirp(ctx context.Context, arg FlagChirpParams) error
+	ListFlaggedChirps(ctx context.Context, arg ListFlaggedChirpsParams) ([]ListFlaggedChirpsRow, error)
+	ReviewChirpFlag(ctx context.Context, chirpID uuid.UUID) (int64, error)
+
+	CreateUser(ctx context.Context, arg CreateUserParams) (User, error)
+	DeleteUsers(ctx context.Context) error
+	GetUserByEmail(ctx context.Context, email string) (User, error)
+	GetUserByID(ctx context.Context, id uuid.UUID) (User, error)
+	GetUsersByHandles(ctx context.Context, handles []string) ([]User, error)
+	UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error)
+	UpdatePassword(ctx context.Context, arg UpdatePasswordParams) error
+	SetUserRole(ctx context.Context, arg SetUserRoleParams) (User, error)
+	LockUser(ctx context.Context, id uuid.UUID) error
+	UpdateSubscription(ctx context.Context, arg UpdateSubscriptionParams) (int64, error)
+	CancelSubscription(ctx context.Context, arg CancelSubscriptionParams) (int64, error)
+	RecordPaymentFailure(ctx context.Context, arg RecordPaymentFailureParams) (int64, error)
+	ExpireSubscriptions(ctx context.Context, asOf time.Time) (int64, error)
+	VerifyEmail(ctx context.Context, arg VerifyEmailParams) (User, error)
+}
+
+var _ Store = (*Queries)(nil)
+
+// InTx runs fn in a transaction. Queries that are already bound to one have
+// nothing to begin, since Postgres doesn't nest transactions, and run fn
+// in it as they are.
+func (q *Queries) InTx(ctx context.Context, fn func(Store) error) error {
+	db, ok := q.db.(*sql.DB)
+	if !ok {
+		return fn(q)
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(q.WithTx(tx)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// IsUniqueViolation reports whether err comes from a UNIQUE or PRIMARY KEY
+// constraint, in Postgres or in MemoryStore.
+func IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	return errors.Is(err, ErrUniqueViolation)
+}
@@ -7,28 +7,53 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
+const cancelSubscription = `-- name: CancelSubscription :execrows
+UPDATE users SET is_chirpy_red = false, chirpy_red_expires_at = NULL,
+    payment_failed_at = NULL, subscription_event_at = $1::timestamp, updated_at = NOW()
+WHERE id = $2
+AND (subscription_event_at IS NULL OR subscription_event_at <= $1::timestamp)
+`
+
+type CancelSubscriptionParams struct {
+	EventAt time.Time
+	ID      uuid.UUID
+}
+
+func (q *Queries) CancelSubscription(ctx context.Context, arg CancelSubscriptionParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, cancelSubscription, arg.EventAt, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const createUser = `-- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password) 
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, handle) 
 VALUES (
     gen_random_uuid (),
     NOW(),
     NOW(),
     $1,
-    $2
-) RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+    $2,
+    $3
+) RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, handle, email_verified, role, chirpy_red_expires_at, payment_failed_at, subscription_event_at
 `
 
 type CreateUserParams struct {
 	Email          string
 	HashedPassword string
+	Handle         string
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, createUser, arg.Email, arg.HashedPassword)
+	row := q.db.QueryRowContext(ctx, createUser, arg.Email, arg.HashedPassword, arg.Handle)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -37,6 +62,12 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.Handle,
+		&i.EmailVerified,
+		&i.Role,
+		&i.ChirpyRedExpiresAt,
+		&i.PaymentFailedAt,
+		&i.SubscriptionEventAt,
 	)
 	return i, err
 }
@@ -50,8 +81,22 @@ func (q *Queries) DeleteUsers(ctx context.Context) error {
 	return err
 }
 
+const expireSubscriptions = `-- name: ExpireSubscriptions :execrows
+UPDATE users SET is_chirpy_red = false, chirpy_red_expires_at = NULL,
+    payment_failed_at = NULL, updated_at = NOW()
+WHERE chirpy_red_expires_at <= $1
+`
+
+func (q *Queries) ExpireSubscriptions(ctx context.Context, asOf time.Time) (int64, error) {
+	result, err := q.db.ExecContext(ctx, expireSubscriptions, asOf)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users WHERE email = $1
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, handle, email_verified, role, chirpy_red_expires_at, payment_failed_at, subscription_event_at FROM users WHERE email = $1
 `
 
 func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
@@ -64,32 +109,241 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.Handle,
+		&i.EmailVerified,
+		&i.Role,
+		&i.ChirpyRedExpiresAt,
+		&i.PaymentFailedAt,
+		&i.SubscriptionEventAt,
+	)
+	return i, err
+}
+
+const getUserByID = `-- name: GetUserByID :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, handle, email_verified, role, chirpy_red_expires_at, payment_failed_at, subscription_event_at FROM users WHERE id = $1
+`
+
+func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByID, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.Handle,
+		&i.EmailVerified,
+		&i.Role,
+		&i.ChirpyRedExpiresAt,
+		&i.PaymentFailedAt,
+		&i.SubscriptionEventAt,
+	)
+	return i, err
+}
+
+const getUsersByHandles = `-- name: GetUsersByHandles :many
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, handle, email_verified, role, chirpy_red_expires_at, payment_failed_at, subscription_event_at FROM users WHERE handle = ANY($1::text[])
+`
+
+func (q *Queries) GetUsersByHandles(ctx context.Context, handles []string) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, getUsersByHandles, pq.Array(handles))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Email,
+			&i.HashedPassword,
+			&i.IsChirpyRed,
+			&i.Handle,
+			&i.EmailVerified,
+			&i.Role,
+			&i.ChirpyRedExpiresAt,
+			&i.PaymentFailedAt,
+			&i.SubscriptionEventAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockUser = `-- name: LockUser :exec
+SELECT id FROM users WHERE id = $1 FOR UPDATE
+`
+
+// Holds the user's row until the transaction ends, so checks that count
+// the user's rows and then insert one run one at a time.
+func (q *Queries) LockUser(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, lockUser, id)
+	return err
+}
+
+const recordPaymentFailure = `-- name: RecordPaymentFailure :execrows
+UPDATE users SET chirpy_red_expires_at = CASE
+        WHEN payment_failed_at IS NULL THEN GREATEST(chirpy_red_expires_at, $1::timestamp)
+        ELSE chirpy_red_expires_at
+    END,
+    payment_failed_at = COALESCE(payment_failed_at, NOW()),
+    subscription_event_at = $2::timestamp, updated_at = NOW()
+WHERE id = $3
+AND is_chirpy_red
+AND (subscription_event_at IS NULL OR subscription_event_at <= $2::timestamp)
+`
+
+type RecordPaymentFailureParams struct {
+	GraceUntil time.Time
+	EventAt    time.Time
+	ID         uuid.UUID
+}
+
+// Only the first failure starts a grace period, so retries that fail too
+// don't stretch it, and it never cuts short a period already paid for.
+// Users without Chirpy Red have nothing to lose.
+func (q *Queries) RecordPaymentFailure(ctx context.Context, arg RecordPaymentFailureParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, recordPaymentFailure, arg.GraceUntil, arg.EventAt, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setUserRole = `-- name: SetUserRole :one
+UPDATE users SET role = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, handle, email_verified, role, chirpy_red_expires_at, payment_failed_at, subscription_event_at
+`
+
+type SetUserRoleParams struct {
+	ID   uuid.UUID
+	Role string
+}
+
+func (q *Queries) SetUserRole(ctx context.Context, arg SetUserRoleParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setUserRole, arg.ID, arg.Role)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.Handle,
+		&i.EmailVerified,
+		&i.Role,
+		&i.ChirpyRedExpiresAt,
+		&i.PaymentFailedAt,
+		&i.SubscriptionEventAt,
 	)
 	return i, err
 }
 
-const updateSubscription = `-- name: UpdateSubscription :exec
-UPDATE users SET is_chirpy_red = true WHERE id = $1
+const updatePassword = `-- name: UpdatePassword :exec
+UPDATE users SET hashed_password = $2, updated_at = NOW()
+WHERE id = $1
 `
 
-func (q *Queries) UpdateSubscription(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.ExecContext(ctx, updateSubscription, id)
+type UpdatePasswordParams struct {
+	ID             uuid.UUID
+	HashedPassword string
+}
+
+func (q *Queries) UpdatePassword(ctx context.Context, arg UpdatePasswordParams) error {
+	_, err := q.db.ExecContext(ctx, updatePassword, arg.ID, arg.HashedPassword)
 	return err
 }
 
+const updateSubscription = `-- name: UpdateSubscription :execrows
+UPDATE users SET is_chirpy_red = true, chirpy_red_expires_at = $1,
+    payment_failed_at = NULL, subscription_event_at = $2::timestamp, updated_at = NOW()
+WHERE id = $3
+AND (subscription_event_at IS NULL OR subscription_event_at <= $2::timestamp)
+`
+
+type UpdateSubscriptionParams struct {
+	ChirpyRedExpiresAt sql.NullTime
+	EventAt            time.Time
+	ID                 uuid.UUID
+}
+
+// Upgrading ends any grace period left by a failed payment. Like the other
+// subscription events, it is skipped if a newer event was applied already.
+func (q *Queries) UpdateSubscription(ctx context.Context, arg UpdateSubscriptionParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateSubscription, arg.ChirpyRedExpiresAt, arg.EventAt, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const updateUser = `-- name: UpdateUser :one
-UPDATE users SET email = $2, hashed_password = $3, updated_at = NOW()
-WHERE id = $1 RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+UPDATE users SET email = $2, hashed_password = $3, handle = COALESCE($4, handle),
+    email_verified = email_verified AND email = $2, updated_at = NOW()
+WHERE id = $1 RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, handle, email_verified, role, chirpy_red_expires_at, payment_failed_at, subscription_event_at
 `
 
 type UpdateUserParams struct {
 	ID             uuid.UUID
 	Email          string
 	HashedPassword string
+	Handle         sql.NullString
 }
 
+// A new email address has to be verified again.
 func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, updateUser, arg.ID, arg.Email, arg.HashedPassword)
+	row := q.db.QueryRowContext(ctx, updateUser,
+		arg.ID,
+		arg.Email,
+		arg.HashedPassword,
+		arg.Handle,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.Handle,
+		&i.EmailVerified,
+		&i.Role,
+		&i.ChirpyRedExpiresAt,
+		&i.PaymentFailedAt,
+		&i.SubscriptionEventAt,
+	)
+	return i, err
+}
+
+const verifyEmail = `-- name: VerifyEmail :one
+UPDATE users SET email_verified = true, updated_at = NOW()
+WHERE id = $1 AND email = $2
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, handle, email_verified, role, chirpy_red_expires_at, payment_failed_at, subscription_event_at
+`
+
+type VerifyEmailParams struct {
+	ID    uuid.UUID
+	Email string
+}
+
+func (q *Queries) VerifyEmail(ctx context.Context, arg VerifyEmailParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, verifyEmail, arg.ID, arg.Email)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -98,6 +352,12 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.Handle,
+		&i.EmailVerified,
+		&i.Role,
+		&i.ChirpyRedExpiresAt,
+		&i.PaymentFailedAt,
+		&i.SubscriptionEventAt,
 	)
 	return i, err
 }
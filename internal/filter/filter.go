@@ -0,0 +1,195 @@
+// Package filter finds banned words in text. Words are compared after
+// Unicode normalization and confusable folding, so accents, homoglyphs,
+// leetspeak and stretched letters don't get a word past the list.
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Policy says what happens to text that contains a word.
+type Policy string
+
+const (
+	// PolicyMask replaces the word with asterisks.
+	PolicyMask Policy = "mask"
+	// PolicyReject refuses the text.
+	PolicyReject Policy = "reject"
+	// PolicyFlag accepts the text as it is and holds it for review.
+	PolicyFlag Policy = "flag"
+)
+
+// Mask is what a masked word is replaced with.
+const Mask = "****"
+
+var ErrInvalidWord = errors.New("word must be a single word")
+
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(s); p {
+	case PolicyMask, PolicyReject, PolicyFlag:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown policy %q", s)
+}
+
+// Word is an entry in the list.
+type Word struct {
+	Word   string
+	Policy Policy
+}
+
+// NormalizeWord returns the form a word is stored in: trimmed, lower case
+// and NFC. It fails unless s is exactly one word.
+func NormalizeWord(s string) (string, error) {
+	s = norm.NFC.String(strings.ToLower(strings.TrimSpace(s)))
+	spans := tokenize(s)
+	if len(spans) != 1 || spans[0] != (span{0, len(s)}) || fold(s) == "" {
+		return "", ErrInvalidWord
+	}
+	return s, nil
+}
+
+// Match is a word found in the text.
+type Match struct {
+	// Word is the entry in the list, Text is how it was written.
+	Word   string
+	Text   string
+	Policy Policy
+}
+
+// Result is the outcome of checking a text.
+type Result struct {
+	// Text is the checked text with the masked words replaced.
+	Text    string
+	Matches []Match
+}
+
+// Has reports whether a word with the policy was found.
+func (r Result) Has(policy Policy) bool {
+	return slices.ContainsFunc(r.Matches, func(m Match) bool {
+		return m.Policy == policy
+	})
+}
+
+// Words returns the list entries found with the policy, without duplicates.
+func (r Result) Words(policy Policy) []string {
+	var words []string
+	for _, m := range r.Matches {
+		if m.Policy == policy && !slices.Contains(words, m.Word) {
+			words = append(words, m.Word)
+		}
+	}
+	return words
+}
+
+// Filter checks text against a list of words. The list can be replaced
+// while the filter is in use.
+type Filter struct {
+	mu    sync.RWMutex
+	words map[skeleton]Word
+}
+
+func New(words []Word) (*Filter, error) {
+	f := &Filter{}
+	if err := f.Replace(words); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Replace swaps the list for words. The list is left as it was if any word
+// is invalid.
+func (f *Filter) Replace(words []Word) error {
+	keys := make(map[skeleton]Word, 4*len(words))
+	for _, word := range words {
+		normalized, err := NormalizeWord(word.Word)
+		if err != nil {
+			return fmt.Errorf("%q: %w", word.Word, err)
+		}
+		if _, err := ParsePolicy(string(word.Policy)); err != nil {
+			return fmt.Errorf("%q: %w", word.Word, err)
+		}
+		for _, key := range skeletons(fold(normalized), true, true) {
+			keys[key] = Word{Word: normalized, Policy: word.Policy}
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.words = keys
+	return nil
+}
+
+// Words returns the list sorted by word.
+func (f *Filter) Words() []Word {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	words := make([]Word, 0, len(f.words))
+	for key, word := range f.words {
+		if key.leet || key.stretched {
+			continue
+		}
+		words = append(words, word)
+	}
+	slices.SortFunc(words, func(a, b Word) int {
+		return strings.Compare(a.Word, b.Word)
+	})
+	return words
+}
+
+// Check finds the listed words in text and masks the ones whose policy is
+// PolicyMask.
+func (f *Filter) Check(text string) Result {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	result := Result{}
+	var b strings.Builder
+	last := 0
+	for _, sp := range tokenize(text) {
+		word, ok := f.lookup(text[sp.start:sp.end])
+		if !ok {
+			sp = trimLeet(text, sp)
+			if sp.start == sp.end {
+				continue
+			}
+			word, ok = f.lookup(text[sp.start:sp.end])
+		}
+		if !ok {
+			continue
+		}
+
+		result.Matches = append(result.Matches, Match{
+			Word:   word.Word,
+			Text:   text[sp.start:sp.end],
+			Policy: word.Policy,
+		})
+		if word.Policy == PolicyMask {
+			b.WriteString(text[last:sp.start])
+			b.WriteString(Mask)
+			last = sp.end
+		}
+	}
+	b.WriteString(text[last:])
+	result.Text = b.String()
+	return result
+}
+
+// lookup finds the listed word s reads as. The looser skeletons are only
+// tried when s is written in leetspeak or stretched.
+func (f *Filter) lookup(s string) (Word, bool) {
+	folded := fold(s)
+	for _, key := range skeletons(folded, hasLeet(s), squash(folded) != folded) {
+		if word, ok := f.words[key]; ok {
+			return word, true
+		}
+	}
+	return Word{}, false
+}
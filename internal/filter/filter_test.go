@@ -0,0 +1,197 @@
+package filter
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func newTestFilter(t *testing.T) *Filter {
+	t.Helper()
+	f, err := New([]Word{
+		{Word: "kerfuffle", Policy: PolicyMask},
+		{Word: "sharbert", Policy: PolicyMask},
+		{Word: "fornax", Policy: PolicyReject},
+		{Word: "profane", Policy: PolicyFlag},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestCheckMask(t *testing.T) {
+	f := newTestFilter(t)
+
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "Plain", text: "what a kerfuffle it was", want: "what a **** it was"},
+		{name: "Upper case", text: "What a KERFUFFLE", want: "What a ****"},
+		{name: "Trailing punctuation", text: "Kerfuffle! Sharbert, again.", want: "****! ****, again."},
+		{name: "Quoted", text: `"kerfuffle"`, want: `"****"`},
+		{name: "Leetspeak", text: "k3rfuff1e and $h4rb3rt", want: "**** and ****"},
+		{name: "Accents", text: "kérfüffle", want: "****"},
+		{name: "Decomposed accents", text: "ke\u0301rfuffle", want: "****"},
+		{name: "Fullwidth", text: "ｋｅｒｆｕｆｆｌｅ", want: "****"},
+		{name: "Cyrillic homoglyphs", text: "s\u04bb\u0430rb\u0435rt", want: "****"},
+		{name: "Zero width space", text: "kerf\u200buffle", want: "****"},
+		{name: "Stretched", text: "kerrrfuuuffle", want: "****"},
+		{name: "Part of a longer word", text: "kerfuffles sharbertine", want: "kerfuffles sharbertine"},
+		{name: "Clean", text: "nothing to see here", want: "nothing to see here"},
+		{name: "Empty", text: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := f.Check(tt.text).Text; got != tt.want {
+				t.Errorf("Check(%q).Text = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+// The looser readings of leetspeak and stretched letters must not make
+// ordinary words match.
+func TestCheckLooseMatches(t *testing.T) {
+	f, err := New([]Word{
+		{Word: "ass", Policy: PolicyReject},
+		{Word: "hell", Policy: PolicyReject},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{text: "as good as it gets", want: false},
+		{text: "aaasss", want: true},
+		{text: "a$$", want: true},
+		{text: "a$", want: false},
+		{text: "heil", want: false},
+		{text: "hill", want: false},
+		{text: "he11", want: true},
+		{text: "h3il", want: true},
+		{text: "heeeii", want: false},
+		{text: "heeel1", want: true},
+	}
+
+	for _, tt := range tests {
+		if got := f.Check(tt.text).Has(PolicyReject); got != tt.want {
+			t.Errorf("Check(%q) rejected = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPolicies(t *testing.T) {
+	f := newTestFilter(t)
+
+	result := f.Check("Fornax! so pr0fane, what a kerfuffle")
+	if want := "Fornax! so pr0fane, what a ****"; result.Text != want {
+		t.Errorf("Text = %q, want %q", result.Text, want)
+	}
+	want := []Match{
+		{Word: "fornax", Text: "Fornax", Policy: PolicyReject},
+		{Word: "profane", Text: "pr0fane", Policy: PolicyFlag},
+		{Word: "kerfuffle", Text: "kerfuffle", Policy: PolicyMask},
+	}
+	if !slices.Equal(result.Matches, want) {
+		t.Errorf("Matches = %v, want %v", result.Matches, want)
+	}
+	for _, policy := range []Policy{PolicyMask, PolicyReject, PolicyFlag} {
+		if !result.Has(policy) {
+			t.Errorf("Has(%q) = false, want true", policy)
+		}
+	}
+	if got := result.Words(PolicyFlag); !slices.Equal(got, []string{"profane"}) {
+		t.Errorf("Words(flag) = %v, want [profane]", got)
+	}
+
+	if result := f.Check("all clear"); result.Has(PolicyReject) || len(result.Matches) != 0 {
+		t.Errorf("clean text matched %v", result.Matches)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	f := newTestFilter(t)
+
+	err := f.Replace([]Word{{Word: "Gadzooks", Policy: PolicyReject}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Check("kerfuffle").Text; got != "kerfuffle" {
+		t.Errorf("removed word still masked: %q", got)
+	}
+	if !f.Check("gadzooks!").Has(PolicyReject) {
+		t.Error("added word not rejected")
+	}
+	if got := f.Words(); !slices.Equal(got, []Word{{Word: "gadzooks", Policy: PolicyReject}}) {
+		t.Errorf("Words() = %v", got)
+	}
+
+	if err := f.Replace([]Word{{Word: "two words", Policy: PolicyMask}}); err == nil {
+		t.Error("Replace accepted two words")
+	}
+	if err := f.Replace([]Word{{Word: "fine", Policy: "ignore"}}); err == nil {
+		t.Error("Replace accepted an unknown policy")
+	}
+	if len(f.Words()) != 1 {
+		t.Errorf("failed Replace changed the list: %v", f.Words())
+	}
+}
+
+func TestNormalizeWord(t *testing.T) {
+	tests := []struct {
+		word    string
+		want    string
+		wantErr bool
+	}{
+		{word: "  Kerfuffle ", want: "kerfuffle"},
+		{word: "$harbert", want: "$harbert"},
+		{word: "two words", wantErr: true},
+		{word: "kerfuffle,", wantErr: true},
+		{word: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := NormalizeWord(tt.word)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NormalizeWord(%q) error = %v, wantErr %v", tt.word, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("NormalizeWord(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestParseWords(t *testing.T) {
+	words, err := ParseWords(strings.NewReader(`
+# house rules
+Kerfuffle
+fornax   reject # no exceptions
+
+profane flag
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Word{
+		{Word: "kerfuffle", Policy: PolicyMask},
+		{Word: "fornax", Policy: PolicyReject},
+		{Word: "profane", Policy: PolicyFlag},
+	}
+	if !slices.Equal(words, want) {
+		t.Errorf("ParseWords() = %v, want %v", words, want)
+	}
+
+	for _, input := range []string{"fornax ban", "fornax reject now", "two-words"} {
+		if _, err := ParseWords(strings.NewReader(input)); err == nil {
+			t.Errorf("ParseWords(%q) succeeded", input)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package filter
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// leet holds the symbols that stand in for letters inside a word. They are
+// part of a token even though they are not letters, so "$harbert" is one
+// word rather than "harbert" after a separator.
+const leet = "@$!|+€"
+
+// confusables maps characters that look like a Latin letter to that letter.
+// It covers leetspeak digits and symbols and the Cyrillic and Greek letters
+// that are usually swapped in for Latin ones. It is applied after case
+// folding, so only lower case forms are listed.
+var confusables = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b', '9': 'g',
+	'@': 'a', '$': 's', '!': 'i', '|': 'i', '+': 't', '€': 'e',
+
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'к': 'k', 'м': 'm', 'н': 'h',
+	'о': 'o', 'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'ѕ': 's',
+	'і': 'i', 'ї': 'i', 'ј': 'j', 'һ': 'h', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w',
+
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v',
+	'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x', 'ω': 'w',
+}
+
+var folder = cases.Fold()
+
+// fold reduces s to a skeleton: two strings with the same skeleton read as
+// the same word. Compatibility forms and accents are normalized away,
+// invisible characters are dropped, case is folded and confusable
+// characters become the Latin letter they imitate, so "Kérfuffle",
+// "KERFUFFLE" and "k3rfuffle" all fold alike.
+func fold(s string) string {
+	s = norm.NFKD.String(s)
+	s = strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		return r
+	}, s)
+	s = folder.String(s)
+	return strings.Map(func(r rune) rune {
+		if c, ok := confusables[r]; ok {
+			return c
+		}
+		return r
+	}, s)
+}
+
+// squash collapses runs of a repeated letter in a skeleton into one, so
+// "kerrrfuuuffle" reads as "kerfufle".
+func squash(s string) string {
+	var b strings.Builder
+	var last rune
+	for _, r := range s {
+		if r != last {
+			b.WriteRune(r)
+		}
+		last = r
+	}
+	return b.String()
+}
+
+// hasLeet reports whether s has characters that stand in for letters in
+// leetspeak.
+func hasLeet(s string) bool {
+	return strings.ContainsAny(s, "01345789"+leet)
+}
+
+// skeleton is a key in a Filter. Besides the plain fold of a word there
+// are looser ones, kept apart so that only text written the same loose way
+// is looked up under them: "l" read as "i", which only makes sense next
+// to other leetspeak ("k3rfuff1e"), and repeated letters squashed, which
+// only makes sense for stretched text ("kerrrfuuuffle"). Otherwise "heil"
+// would match "hell" and "as" would match "ass".
+type skeleton struct {
+	folded    string
+	leet      bool
+	stretched bool
+}
+
+// skeletons returns the keys a folded word is looked up under, strictest
+// first.
+func skeletons(folded string, leet, stretched bool) []skeleton {
+	keys := []skeleton{{folded: folded}}
+	if leet {
+		keys = append(keys, skeleton{folded: strings.ReplaceAll(folded, "l", "i"), leet: true})
+	}
+	if stretched {
+		for _, key := range keys {
+			keys = append(keys, skeleton{folded: squash(key.folded), leet: key.leet, stretched: true})
+		}
+	}
+	return keys
+}
+
+// isWordRune reports whether r can be part of a word.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) ||
+		unicode.Is(unicode.Cf, r) || strings.ContainsRune(leet, r)
+}
+
+// span is a word's byte offsets in the text it was found in.
+type span struct {
+	start, end int
+}
+
+// tokenize splits s into words. Punctuation other than the leetspeak
+// symbols, spaces and everything else separate words.
+func tokenize(s string) []span {
+	var spans []span
+	start := -1
+	for i, r := range s {
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			spans = append(spans, span{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, span{start, len(s)})
+	}
+	return spans
+}
+
+// trimLeet returns sp without the leetspeak symbols at either end, so the
+// "!" in "kerfuffle!" can be read as punctuation.
+func trimLeet(s string, sp span) span {
+	word := s[sp.start:sp.end]
+	trimmed := strings.TrimLeft(word, leet)
+	sp.start += len(word) - len(trimmed)
+	sp.end = sp.start + len(strings.TrimRight(trimmed, leet))
+	return sp
+}
@@ -0,0 +1,57 @@
+package filter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ParseWords reads a word list. Each line holds a word and, optionally, its
+// policy, which defaults to PolicyMask:
+//
+//	# comments and blank lines are skipped
+//	kerfuffle
+//	fornax reject
+func ParseWords(r io.Reader) ([]Word, error) {
+	var words []Word
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("line %d: expected a word and a policy", line)
+		}
+
+		word, err := NormalizeWord(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		policy := PolicyMask
+		if len(fields) == 2 {
+			policy, err = ParsePolicy(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		words = append(words, Word{Word: word, Policy: policy})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return words, nil
+}
+
+// LoadFile reads a word list from a file in the format ParseWords reads.
+func LoadFile(path string) ([]Word, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseWords(f)
+}
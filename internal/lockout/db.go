@@ -0,0 +1,60 @@
+package lockout
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/YaguarEgor/chirpy_server/internal/database"
+)
+
+// DBStore keeps failure counts in the login_failures table.
+type DBStore struct {
+	db *database.Queries
+}
+
+var _ Store = (*DBStore)(nil)
+
+func NewDBStore(db *database.Queries) *DBStore {
+	return &DBStore{db: db}
+}
+
+func (s *DBStore) Get(ctx context.Context, key string) (Record, error) {
+	row, err := s.db.GetLoginFailures(ctx, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Record{}, nil
+	}
+	if err != nil {
+		return Record{}, err
+	}
+	return Record{Failures: int(row.Failures), LastFailure: row.LastFailureAt}, nil
+}
+
+func (s *DBStore) Attempt(ctx context.Context, key string, t time.Time, policy Policy) (Record, bool, error) {
+	row, err := s.db.TakeLoginAttempt(ctx, database.TakeLoginAttemptParams{
+		Since:            t.Add(-policy.ResetAfter).UTC(),
+		Key:              key,
+		AttemptedAt:      t.UTC(),
+		FreeAttempts:     int32(policy.FreeAttempts),
+		BaseDelaySeconds: policy.BaseDelay.Seconds(),
+		MaxDelaySeconds:  policy.MaxDelay.Seconds(),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		// The key has to wait, so the update skipped its row.
+		record, err := s.Get(ctx, key)
+		return record, false, err
+	}
+	if err != nil {
+		return Record{}, false, err
+	}
+	return Record{Failures: int(row.Failures), LastFailure: row.LastFailureAt}, true, nil
+}
+
+func (s *DBStore) Forgive(ctx context.Context, key string) error {
+	return s.db.ForgiveLoginAttempt(ctx, key)
+}
+
+func (s *DBStore) Reset(ctx context.Context, key string) error {
+	return s.db.DeleteLoginFailures(ctx, key)
+}
@@ -0,0 +1,103 @@
+// Package lockout slows down password guessing. Every failed attempt under
+// a key, such as an account or a client address, makes the next attempt
+// wait longer, until the key is locked out for a while.
+package lockout
+
+import (
+	"context"
+	"time"
+)
+
+// Record is what a Store keeps per key.
+type Record struct {
+	Failures    int
+	LastFailure time.Time
+}
+
+// Store keeps failure counts. MemoryStore keeps them in the process; DBStore
+// keeps them in Postgres so every instance of the server sees the same
+// counts.
+type Store interface {
+	// Get returns the key's record, or a zero Record if it has none.
+	Get(ctx context.Context, key string) (Record, error)
+	// Attempt counts an attempt at t as a failure and returns the updated
+	// record, unless policy makes the key wait. Then nothing is counted
+	// and Attempt returns the record as it is and false. Checking and
+	// counting are one step, so parallel attempts can't all pass the check
+	// before any of them is counted. Failures older than
+	// policy.ResetAfter are forgotten, so the count starts over at one.
+	Attempt(ctx context.Context, key string, t time.Time, policy Policy) (Record, bool, error)
+	// Forgive takes back one counted attempt that turned out not to fail.
+	Forgive(ctx context.Context, key string) error
+	Reset(ctx context.Context, key string) error
+}
+
+// Policy says how long a key waits after its failures.
+type Policy struct {
+	// FreeAttempts is how many failures are allowed before any waiting.
+	FreeAttempts int
+	// BaseDelay is the wait after the first failure past the free ones. It
+	// doubles with every failure after that.
+	BaseDelay time.Duration
+	// MaxDelay caps the wait. A key that reaches it is locked out for
+	// MaxDelay after each further failure.
+	MaxDelay time.Duration
+	// ResetAfter is how long a key has to go without failing for its
+	// failures to be forgotten.
+	ResetAfter time.Duration
+}
+
+func (p Policy) delay(failures int) time.Duration {
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// wait returns how long a key with record has to wait at now before its
+// next attempt.
+func (p Policy) wait(record Record, now time.Time) time.Duration {
+	if record.Failures == 0 || now.Sub(record.LastFailure) >= p.ResetAfter {
+		return 0
+	}
+	return max(record.LastFailure.Add(p.delay(record.Failures)).Sub(now), 0)
+}
+
+type Limiter struct {
+	store  Store
+	policy Policy
+	now    func() time.Time
+}
+
+func NewLimiter(store Store, policy Policy) *Limiter {
+	return &Limiter{store: store, policy: policy, now: time.Now}
+}
+
+// Attempt counts an attempt under key before it is made, as if it will
+// fail, and returns zero. If key has to wait, nothing is counted and
+// Attempt returns how long. An attempt that succeeds is taken back with
+// Forgive or Reset.
+func (l *Limiter) Attempt(ctx context.Context, key string) (time.Duration, error) {
+	now := l.now()
+	record, ok, err := l.store.Attempt(ctx, key, now, l.policy)
+	if err != nil || ok {
+		return 0, err
+	}
+	// Postgres may round a wait that is just ending differently; a second
+	// is the least a Retry-After header can say anyway.
+	return max(l.policy.wait(record, now), time.Second), nil
+}
+
+// Forgive takes back one attempt under key, after it succeeded.
+func (l *Limiter) Forgive(ctx context.Context, key string) error {
+	return l.store.Forgive(ctx, key)
+}
+
+// Reset forgets key's failures, after a successful attempt.
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	return l.store.Reset(ctx, key)
+}
@@ -0,0 +1,166 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testPolicy = Policy{
+	FreeAttempts: 3,
+	BaseDelay:    time.Second,
+	MaxDelay:     10 * time.Second,
+	ResetAfter:   time.Hour,
+}
+
+func TestPolicyDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 0, want: 0},
+		{failures: 3, want: 0},
+		{failures: 4, want: time.Second},
+		{failures: 5, want: 2 * time.Second},
+		{failures: 7, want: 8 * time.Second},
+		{failures: 8, want: 10 * time.Second},
+		{failures: 1000, want: 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := testPolicy.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewLimiter(NewMemoryStore(), testPolicy)
+	limiter.now = func() time.Time { return clock }
+
+	retryAfter := func(t *testing.T, key string) time.Duration {
+		t.Helper()
+		record, err := limiter.store.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		return testPolicy.wait(record, clock)
+	}
+	attempt := func(t *testing.T, key string) time.Duration {
+		t.Helper()
+		wait, err := limiter.Attempt(ctx, key)
+		if err != nil {
+			t.Fatalf("Attempt() error = %v", err)
+		}
+		return wait
+	}
+	fail := func(t *testing.T, key string, times int) {
+		t.Helper()
+		for range times {
+			clock = clock.Add(retryAfter(t, key))
+			if wait := attempt(t, key); wait != 0 {
+				t.Fatalf("Attempt() = %s after waiting, want 0", wait)
+			}
+		}
+	}
+
+	fail(t, "a", 3)
+	if wait := retryAfter(t, "a"); wait != 0 {
+		t.Errorf("after the free attempts wait = %s, want 0", wait)
+	}
+	fail(t, "a", 1)
+	if wait := attempt(t, "a"); wait != time.Second {
+		t.Errorf("after 4 failures Attempt() = %s, want 1s", wait)
+	}
+	if record, _ := limiter.store.Get(ctx, "a"); record.Failures != 4 {
+		t.Errorf("refused attempt was counted: %d failures, want 4", record.Failures)
+	}
+	if wait := retryAfter(t, "b"); wait != 0 {
+		t.Errorf("another key's wait = %s, want 0", wait)
+	}
+
+	clock = clock.Add(500 * time.Millisecond)
+	if wait := retryAfter(t, "a"); wait != 500*time.Millisecond {
+		t.Errorf("500ms later wait = %s, want 500ms", wait)
+	}
+	clock = clock.Add(500 * time.Millisecond)
+	if wait := attempt(t, "a"); wait != 0 {
+		t.Errorf("after waiting Attempt() = %s, want 0", wait)
+	}
+	if wait := retryAfter(t, "a"); wait != 2*time.Second {
+		t.Errorf("after 5 failures wait = %s, want 2s", wait)
+	}
+	if err := limiter.Forgive(ctx, "a"); err != nil {
+		t.Fatalf("Forgive() error = %v", err)
+	}
+	if wait := retryAfter(t, "a"); wait != time.Second {
+		t.Errorf("after Forgive() wait = %s, want 1s", wait)
+	}
+
+	fail(t, "a", 10)
+	if wait := retryAfter(t, "a"); wait != testPolicy.MaxDelay {
+		t.Errorf("when locked out wait = %s, want %s", wait, testPolicy.MaxDelay)
+	}
+	if err := limiter.Reset(ctx, "a"); err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+	if wait := retryAfter(t, "a"); wait != 0 {
+		t.Errorf("after Reset() wait = %s, want 0", wait)
+	}
+
+	// Failures are forgotten after ResetAfter without another one.
+	fail(t, "a", 5)
+	clock = clock.Add(testPolicy.ResetAfter)
+	fail(t, "a", 1)
+	if wait := retryAfter(t, "a"); wait != 0 {
+		t.Errorf("after failures were forgotten wait = %s, want 0", wait)
+	}
+}
+
+func TestLimiterParallelAttempts(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewLimiter(NewMemoryStore(), testPolicy)
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait, err := limiter.Attempt(ctx, "a")
+			if err != nil {
+				t.Errorf("Attempt() error = %v", err)
+			}
+			if wait == 0 {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The free attempts and the first delayed one, which is counted
+	// before anything has to wait.
+	if got, want := allowed.Load(), int32(testPolicy.FreeAttempts+1); got != want {
+		t.Errorf("%d parallel attempts were allowed, want %d", got, want)
+	}
+}
+
+func TestMemoryStorePrunes(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	store.Attempt(ctx, "old", start, testPolicy)
+	store.Attempt(ctx, "new", start.Add(2*time.Hour), testPolicy)
+
+	if _, ok := store.records["old"]; ok {
+		t.Errorf("forgotten record is still stored")
+	}
+	if record, _ := store.Get(ctx, "new"); record.Failures != 1 {
+		t.Errorf("new record has %d failures, want 1", record.Failures)
+	}
+}
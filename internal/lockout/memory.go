@@ -0,0 +1,82 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// pruneInterval is how often MemoryStore drops forgotten records, so keys
+// that stop failing don't stay in memory.
+const pruneInterval = time.Minute
+
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+	pruned  time.Time
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: map[string]Record{}}
+}
+
+func (m *MemoryStore) Get(ctx context.Context, key string) (Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.records[key], nil
+}
+
+func (m *MemoryStore) Attempt(ctx context.Context, key string, t time.Time, policy Policy) (Record, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	since := t.Add(-policy.ResetAfter)
+	if t.Sub(m.pruned) >= pruneInterval {
+		for k, record := range m.records {
+			if !record.LastFailure.After(since) {
+				delete(m.records, k)
+			}
+		}
+		m.pruned = t
+	}
+
+	record := m.records[key]
+	if policy.wait(record, t) > 0 {
+		return record, false, nil
+	}
+	if !record.LastFailure.After(since) {
+		record.Failures = 0
+	}
+	record.Failures++
+	record.LastFailure = t
+	m.records[key] = record
+	return record, true, nil
+}
+
+func (m *MemoryStore) Forgive(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record, ok := m.records[key]
+	if !ok {
+		return nil
+	}
+	record.Failures--
+	if record.Failures <= 0 {
+		delete(m.records, key)
+		return nil
+	}
+	m.records[key] = record
+	return nil
+}
+
+func (m *MemoryStore) Reset(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.records, key)
+	return nil
+}
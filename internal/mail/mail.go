@@ -0,0 +1,36 @@
+// Package mail sends the emails Chirpy needs, such as address
+// verification.
+package mail
+
+import (
+	"context"
+	"io"
+	"log"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages. Send returning nil means the message was
+// handed off, not that it arrived.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// LogMailer writes messages to a log instead of sending them, for local
+// development. The log can be a file or stderr.
+type LogMailer struct {
+	logger *log.Logger
+}
+
+func NewLogMailer(w io.Writer) *LogMailer {
+	return &LogMailer{logger: log.New(w, "", log.LstdFlags)}
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	m.logger.Printf("To: %s\nSubject: %s\n\n%s\n", msg.To, msg.Subject, msg.Body)
+	return nil
+}
@@ -0,0 +1,28 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestLogMailer(t *testing.T) {
+	var buf bytes.Buffer
+	mailer := NewLogMailer(&buf)
+
+	err := mailer.Send(context.Background(), Message{
+		To:      "user@example.com",
+		Subject: "Hello",
+		Body:    "token: abc",
+	})
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	for _, want := range []string{"To: user@example.com", "Subject: Hello", "token: abc"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("log = %q, want it to contain %q", buf.String(), want)
+		}
+	}
+}
@@ -1,23 +1,50 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/netip"
 	"os"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/YaguarEgor/chirpy_server/internal/auth"
+	"github.com/YaguarEgor/chirpy_server/internal/content"
 	"github.com/YaguarEgor/chirpy_server/internal/database"
+	"github.com/YaguarEgor/chirpy_server/internal/filter"
+	"github.com/YaguarEgor/chirpy_server/internal/lockout"
+	"github.com/YaguarEgor/chirpy_server/internal/mail"
+	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type apiConfig struct {
 	fileserverHits  atomic.Int32
-	db              *database.Queries
-	secret_key		string
+	db              database.Store
+	keyring			*auth.Keyring
 	platform 		string
 	polka_key		string
+	token_pepper	string
+	mailer			mail.Mailer
+	passwords		*auth.PasswordHasher
+	account_lockout	*lockout.Limiter
+	ip_lockout		*lockout.Limiter
+	reset_lockout	*lockout.Limiter
+	word_filter		*filter.Filter
+	pipeline		*content.Pipeline
+	trusted_proxies	[]netip.Prefix
+	admin_email		string
+	admin_password	string
+	// mail_jobs tracks emails sent after the response.
+	mail_jobs		sync.WaitGroup
 }
 
 func main() {
@@ -29,41 +56,168 @@ func main() {
 	platform := os.Getenv("PLATFORM")
 	secret_key := os.Getenv("TOKEN")
 	polka_key := os.Getenv("POLKA_KEY")
-	db, err := sql.Open("postgres", db_url)
+	// The pepper was introduced for refresh tokens, hence the name, but it
+	// keys the hash of every token the server stores.
+	token_pepper := os.Getenv("REFRESH_TOKEN_PEPPER")
+	if token_pepper == "" && db_url != "" {
+		// Stored hashes have to outlive the process and be checked by every
+		// instance, so a database needs a pepper that stays the same.
+		log.Fatal("REFRESH_TOKEN_PEPPER must be set when DB_URL is")
+	}
+	if token_pepper == "" {
+		// The in-memory store forgets the tokens on restart anyway.
+		log.Println("REFRESH_TOKEN_PEPPER is not set, using a random pepper")
+		pepper, err := auth.MakeRefreshToken()
+		if err != nil {
+			log.Fatalf("error when generating token pepper: %v", err)
+		}
+		token_pepper = pepper
+	}
+	keyring, err := newKeyring(secret_key, os.Getenv("JWT_SIGNING_KEYS"), os.Getenv("JWT_ACCEPT_HS256_UNTIL"))
+	if err != nil {
+		log.Fatalf("error when loading JWT signing keys: %v", err)
+	}
+	passwords, err := newPasswordHasher(os.Getenv("ARGON2ID_PARAMS"))
+	if err != nil {
+		log.Fatalf("error when configuring password hashing: %v", err)
+	}
+	// Until a real mail provider is wired in, emails go to MAIL_LOG, or to
+	// stderr when it is not set.
+	mail_log := os.Stderr
+	if path := os.Getenv("MAIL_LOG"); path != "" {
+		mail_log, err = os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			log.Fatalf("error when opening mail log: %v", err)
+		}
+	}
+	trusted_proxies, err := parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
 	if err != nil {
-		log.Fatalf("error when opening db")
+		log.Fatalf("error when parsing TRUSTED_PROXIES: %v", err)
+	}
+	var store database.Store
+	// Failed logins are counted in memory unless LOCKOUT_STORE=postgres,
+	// which lets several instances share the counts.
+	var lockouts lockout.Store = lockout.NewMemoryStore()
+	if db_url == "" {
+		// Everything is lost on restart, so the in-memory store is only for
+		// tests and CI, and has to be asked for.
+		if os.Getenv("STORAGE") != "memory" {
+			log.Fatal("DB_URL is not set; set STORAGE=memory to run without a database")
+		}
+		log.Println("using in-memory storage")
+		store = database.NewMemoryStore()
+	} else {
+		db, err := sql.Open("postgres", db_url)
+		if err != nil {
+			log.Fatalf("error when opening db")
+		}
+		queries := database.New(db)
+		store = queries
+		if os.Getenv("LOCKOUT_STORE") == "postgres" {
+			lockouts = lockout.NewDBStore(queries)
+		}
 	}
-	dbQueries := database.New(db)
 
 	//serverMux := http.ServeMux{}
 	apiCfg := apiConfig{
 		fileserverHits: atomic.Int32{},
-		db:             dbQueries,
-		secret_key: 	secret_key,
+		db:             store,
+		keyring: 		keyring,
 		platform: 		platform,
 		polka_key: 		polka_key,
+		token_pepper: token_pepper,
+		mailer: 		mail.NewLogMailer(mail_log),
+		passwords: 		passwords,
+		account_lockout: lockout.NewLimiter(lockouts, accountLockoutPolicy),
+		ip_lockout: 	lockout.NewLimiter(lockouts, ipLockoutPolicy),
+		reset_lockout: lockout.NewLimiter(lockouts, passwordResetPolicy),
+		trusted_proxies: trusted_proxies,
+	}
+	apiCfg.word_filter, err = newWordFilter(context.Background(), store, os.Getenv("FILTER_WORDS_FILE"))
+	if err != nil {
+		log.Fatalf("error when loading banned words: %v", err)
 	}
+	apiCfg.pipeline, err = newChirpPipeline(&apiCfg, os.Getenv("CHIRP_PIPELINE"))
+	if err != nil {
+		log.Fatalf("error when configuring CHIRP_PIPELINE: %v", err)
+	}
+	if db_url != "" {
+		// Other instances edit the list too, so pick up their changes.
+		go apiCfg.refreshWordFilter(context.Background(), time.Minute)
+	}
+	go apiCfg.expireSubscriptions(context.Background(), time.Minute)
+	// /admin/reset deletes the admin too, so it runs the bootstrap again.
+	apiCfg.admin_email = os.Getenv("ADMIN_EMAIL")
+	apiCfg.admin_password = os.Getenv("ADMIN_PASSWORD")
+	if apiCfg.admin_email != "" {
+		err = bootstrapAdmin(context.Background(), &apiCfg, apiCfg.admin_email, apiCfg.admin_password)
+		if err != nil {
+			log.Fatalf("error when creating admin: %v", err)
+		}
+	}
+	mux := newServeMux(&apiCfg, filepathRoot)
+	server := &http.Server{
+		Handler: mux,
+		Addr:    ":" + port,
+	}
+	server.ListenAndServe()
+
+}
+
+// newServeMux registers every Chirpy route on a fresh mux. filepathRoot is
+// the directory served under /app/.
+func newServeMux(apiCfg *apiConfig, filepathRoot string) *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.Handle("/app/", http.StripPrefix("/app", apiCfg.middlewareMetricsInc(http.FileServer(http.Dir(filepathRoot)))))
 	mux.HandleFunc("GET /api/healthz", handlerReadiness)
-	mux.HandleFunc("GET /admin/metrics", apiCfg.handlerCounts)
-	mux.HandleFunc("POST /admin/reset", apiCfg.handlerReset)
+	mux.HandleFunc("GET /.well-known/jwks.json", apiCfg.handlerJWKS)
+	mux.Handle("GET /admin/metrics", apiCfg.middlewareRequireRole(auth.RoleAdmin, http.HandlerFunc(apiCfg.handlerCounts)))
+	mux.Handle("POST /admin/reset", apiCfg.middlewareRequireRole(auth.RoleAdmin, http.HandlerFunc(apiCfg.handlerReset)))
+	mux.Handle("PUT /admin/users/{userID}/role", apiCfg.middlewareRequireRole(auth.RoleAdmin, http.HandlerFunc(apiCfg.handlerSetUserRole)))
+	mux.Handle("GET /admin/filter/words", apiCfg.middlewareRequireRole(auth.RoleAdmin, http.HandlerFunc(apiCfg.handlerGetBannedWords)))
+	mux.Handle("PUT /admin/filter/words/{word}", apiCfg.middlewareRequireRole(auth.RoleAdmin, http.HandlerFunc(apiCfg.handlerPutBannedWord)))
+	mux.Handle("DELETE /admin/filter/words/{word}", apiCfg.middlewareRequireRole(auth.RoleAdmin, http.HandlerFunc(apiCfg.handlerDeleteBannedWord)))
+	mux.Handle("GET /api/moderation/flags", apiCfg.middlewareRequireRole(auth.RoleModerator, http.HandlerFunc(apiCfg.handlerGetFlaggedChirps)))
+	mux.Handle("DELETE /api/moderation/flags/{chirpID}", apiCfg.middlewareRequireRole(auth.RoleModerator, http.HandlerFunc(apiCfg.handlerReviewChirpFlag)))
 	mux.HandleFunc("POST /api/users", apiCfg.handlerCreateUser)
 	mux.HandleFunc("POST /api/chirps", apiCfg.handlerChirps)
 	mux.HandleFunc("GET /api/chirps", apiCfg.handlerGetChirps)
+	mux.HandleFunc("POST /api/chirps/import", apiCfg.handlerImportChirps)
+	mux.HandleFunc("GET /api/chirps/search", apiCfg.handlerSearchChirps)
 	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.handlerGetChirp)
+	mux.HandleFunc("PUT /api/chirps/{chirpID}", apiCfg.handlerEditChirp)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/thread", apiCfg.handlerGetThread)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/likes", apiCfg.handlerLikeChirp)
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/likes", apiCfg.handlerUnlikeChirp)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/rechirps", apiCfg.handlerRechirp)
+	mux.HandleFunc("GET /api/limits", apiCfg.handlerGetLimits)
 	mux.HandleFunc("POST /api/login", apiCfg.handlerLogin)
+	mux.HandleFunc("POST /api/login/mfa", apiCfg.handlerLoginMFA)
+	mux.HandleFunc("POST /api/password/forgot", apiCfg.handlerForgotPassword)
+	mux.HandleFunc("POST /api/password/reset", apiCfg.handlerResetPassword)
 	mux.HandleFunc("POST /api/refresh", apiCfg.handlerRefreshToken)
 	mux.HandleFunc("POST /api/revoke", apiCfg.handlerRevokeToken)
 	mux.HandleFunc("PUT /api/users", apiCfg.handlerEditUser)
+	mux.HandleFunc("POST /api/users/verify", apiCfg.handlerVerifyEmail)
+	mux.HandleFunc("POST /api/users/verify/resend", apiCfg.handlerResendVerification)
+	mux.HandleFunc("POST /api/users/totp", apiCfg.handlerEnrollTOTP)
+	mux.HandleFunc("POST /api/users/totp/confirm", apiCfg.handlerConfirmTOTP)
+	mux.HandleFunc("DELETE /api/users/totp", apiCfg.handlerDisableTOTP)
+	mux.HandleFunc("GET /api/sessions", apiCfg.handlerGetSessions)
+	mux.HandleFunc("DELETE /api/sessions", apiCfg.handlerRevokeSessions)
+	mux.HandleFunc("DELETE /api/sessions/{sessionID}", apiCfg.handlerRevokeSession)
 	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.handlerDeleteChirp)
 	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.handlerWebhook)
-	server := &http.Server{
-		Handler: mux,
-		Addr:    ":" + port,
-	}
-	server.ListenAndServe()
-
+	mux.HandleFunc("POST /api/users/{userID}/follow", apiCfg.handlerFollow)
+	mux.HandleFunc("DELETE /api/users/{userID}/follow", apiCfg.handlerUnfollow)
+	mux.HandleFunc("GET /api/users/{userID}/followers", apiCfg.handlerGetFollowers)
+	mux.HandleFunc("GET /api/users/{userID}/following", apiCfg.handlerGetFollowing)
+	mux.HandleFunc("GET /api/timeline", apiCfg.handlerTimeline)
+	mux.HandleFunc("GET /api/notifications", apiCfg.handlerGetNotifications)
+	mux.HandleFunc("POST /api/notifications/read", apiCfg.handlerMarkNotificationsRead)
+	mux.HandleFunc("GET /api/tags/trending", apiCfg.handlerTrendingTags)
+	mux.HandleFunc("GET /api/tags/{tag}/chirps", apiCfg.handlerGetTagChirps)
+	return mux
 }
 
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
@@ -72,3 +226,218 @@ func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// middlewareRequireRole only lets through requests whose access token has
+// at least role. The role is read from the token, not the database, so
+// promoting or demoting a user takes effect once their access token is
+// refreshed.
+func (cfg *apiConfig) middlewareRequireRole(role auth.Role, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't get JWT token", err)
+			return
+		}
+		claims, err := cfg.keyring.ValidateAccessToken(token)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT token", err)
+			return
+		}
+		if !claims.Role.AtLeast(role) {
+			respondWithError(w, http.StatusForbidden, fmt.Sprintf("Requires the %s role", role), nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate returns the ID of the user whose access token is in the
+// request's Authorization header.
+func (cfg *apiConfig) authenticate(r *http.Request) (uuid.UUID, error) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return cfg.keyring.ValidateJWT(token)
+}
+
+// bootstrapAdmin makes the user with email an admin, so there is someone to
+// promote everyone else. If there is no such user and password is set, the
+// admin is created, with the address taken as verified.
+func bootstrapAdmin(ctx context.Context, cfg *apiConfig, email, password string) error {
+	user, err := cfg.db.GetUserByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		if password == "" {
+			return fmt.Errorf("there is no user %s to make admin, set ADMIN_PASSWORD to create one", email)
+		}
+		user, err = createAdmin(ctx, cfg, email, password)
+	}
+	if err != nil {
+		return err
+	}
+	if user.Role == string(auth.RoleAdmin) {
+		return nil
+	}
+	_, err = cfg.db.SetUserRole(ctx, database.SetUserRoleParams{
+		ID:   user.ID,
+		Role: string(auth.RoleAdmin),
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Made %s an admin", email)
+	return nil
+}
+
+func createAdmin(ctx context.Context, cfg *apiConfig, email, password string) (database.User, error) {
+	hashed_passwd, err := cfg.passwords.Hash(password)
+	if err != nil {
+		return database.User{}, err
+	}
+	user, err := cfg.db.CreateUser(ctx, database.CreateUserParams{
+		Email:          email,
+		HashedPassword: hashed_passwd,
+		Handle:         "admin",
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+	return cfg.db.VerifyEmail(ctx, database.VerifyEmailParams{ID: user.ID, Email: email})
+}
+
+// newPasswordHasher hashes new passwords with argon2id. params overrides the
+// default cost in PHC form, such as "m=65536,t=3,p=4"; hashes made with
+// other parameters, and bcrypt hashes, are replaced as users log in.
+func newPasswordHasher(params string) (*auth.PasswordHasher, error) {
+	if params == "" {
+		return auth.DefaultPasswordHasher(), nil
+	}
+	argon2id_params, err := auth.ParseArgon2idParams(params)
+	if err != nil {
+		return nil, err
+	}
+	return auth.NewPasswordHasher(auth.Argon2id{Params: argon2id_params}, auth.Bcrypt{Cost: bcrypt.DefaultCost}), nil
+}
+
+// newWordFilter builds the chirp filter from the banned words in store.
+// The words in path, if it is set, are added to the store first, so a word
+// list can be shipped with a deployment and still be edited at runtime.
+func newWordFilter(ctx context.Context, store database.Store, path string) (*filter.Filter, error) {
+	if path != "" {
+		words, err := filter.LoadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, word := range words {
+			_, err := store.UpsertBannedWord(ctx, database.UpsertBannedWordParams{
+				Word:   word.Word,
+				Policy: string(word.Policy),
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	f, err := filter.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	return f, loadBannedWords(ctx, store, f)
+}
+
+// loadBannedWords replaces the words f checks with the ones in store.
+func loadBannedWords(ctx context.Context, store database.Store, f *filter.Filter) error {
+	banned_words, err := store.ListBannedWords(ctx)
+	if err != nil {
+		return err
+	}
+	words := make([]filter.Word, len(banned_words))
+	for i, word := range banned_words {
+		words[i] = filter.Word{Word: word.Word, Policy: filter.Policy(word.Policy)}
+	}
+	return f.Replace(words)
+}
+
+// refreshWordFilter reloads the banned words every interval until ctx is
+// done.
+func (cfg *apiConfig) refreshWordFilter(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := loadBannedWords(ctx, cfg.db, cfg.word_filter); err != nil {
+				log.Printf("error when reloading banned words: %v", err)
+			}
+		}
+	}
+}
+
+// newKeyring builds the access token keyring. keyFiles is a comma-separated
+// list of PEM private keys: the first signs new tokens, the rest are retired
+// keys whose tokens are still accepted. Without key files the HMAC secret
+// signs new tokens. With them, anyone holding the secret could still mint
+// tokens, so HS256 tokens are only accepted when hs256Until, an RFC 3339
+// time, says until when.
+func newKeyring(secret, keyFiles, hs256Until string) (*auth.Keyring, error) {
+	var keys []auth.SigningKey
+	for _, path := range strings.Split(keyFiles, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		dat, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := auth.ParseSigningKeyPEM(dat)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	switch {
+	case secret == "":
+	case len(keys) == 0:
+		keys = append(keys, auth.NewHMACKey(secret))
+	case hs256Until != "":
+		until, err := time.Parse(time.RFC3339, hs256Until)
+		if err != nil {
+			return nil, fmt.Errorf("JWT_ACCEPT_HS256_UNTIL: %w", err)
+		}
+		keys = append(keys, auth.NewHMACKey(secret).AcceptedUntil(until))
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("neither JWT_SIGNING_KEYS nor TOKEN is set")
+	}
+	return auth.NewKeyring(keys[0], keys[1:]...), nil
+}
+
+// parseTrustedProxies parses a comma-separated list of the addresses, or
+// CIDR ranges, of the proxies in front of the server, such as
+// "10.0.0.0/8,192.0.2.1". Only they are believed about X-Forwarded-For.
+func parseTrustedProxies(list string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	for _, proxy := range strings.Split(list, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			addr, err := netip.ParseAddr(proxy)
+			if err != nil {
+				return nil, err
+			}
+			proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/YaguarEgor/chirpy_server/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 100
+)
+
+// chirpCursor points at the last chirp of a page. Chirps are ordered by
+// (created_at, id), so the next page starts right after this pair.
+type chirpCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        uuid.UUID `json:"id"`
+}
+
+// searchCursor is the position in a relevance-ranked result list. Ranks
+// are not stable keys, so search pages are addressed by offset.
+type searchCursor struct {
+	Offset int `json:"o"`
+}
+
+// encodeCursor turns a cursor into the opaque string handed to clients.
+func encodeCursor[T chirpCursor | searchCursor](c T) string {
+	dat, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(dat)
+}
+
+func decodeCursor[T chirpCursor | searchCursor](s string) (T, error) {
+	var c T
+	dat, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, fmt.Errorf("malformed cursor: %w", err)
+	}
+	if err := json.Unmarshal(dat, &c); err != nil {
+		return c, fmt.Errorf("malformed cursor: %w", err)
+	}
+	return c, nil
+}
+
+// parseLimit reads the `limit` query parameter, defaulting to
+// defaultPageLimit and capping it at maxPageLimit.
+func parseLimit(s string) (int, error) {
+	if s == "" {
+		return defaultPageLimit, nil
+	}
+	limit, err := strconv.Atoi(s)
+	if err != nil || limit < 1 {
+		return 0, fmt.Errorf("limit must be a positive integer")
+	}
+	return min(limit, maxPageLimit), nil
+}
+
+// chirpPage holds the `limit` and `cursor` query parameters of a
+// chronological chirp list.
+type chirpPage struct {
+	limit          int
+	afterCreatedAt sql.NullTime
+	afterID        uuid.NullUUID
+}
+
+func parseChirpPage(r *http.Request) (chirpPage, error) {
+	limit, err := parseLimit(r.URL.Query().Get("limit"))
+	if err != nil {
+		return chirpPage{}, err
+	}
+	page := chirpPage{limit: limit}
+	if c := r.URL.Query().Get("cursor"); c != "" {
+		cursor, err := decodeCursor[chirpCursor](c)
+		if err != nil {
+			return chirpPage{}, err
+		}
+		page.afterCreatedAt = sql.NullTime{Time: cursor.CreatedAt, Valid: true}
+		page.afterID = uuid.NullUUID{UUID: cursor.ID, Valid: true}
+	}
+	return page, nil
+}
+
+// rowLimit asks for one row more than the page holds, which tells whether
+// there is a next page.
+func (p chirpPage) rowLimit() int32 {
+	return int32(p.limit + 1)
+}
+
+// trim cuts chirps down to the page size and links to the next page if
+// there were rows left over.
+func (p chirpPage) trim(w http.ResponseWriter, r *http.Request, chirps []database.Chirp) []database.Chirp {
+	if len(chirps) <= p.limit {
+		return chirps
+	}
+	chirps = chirps[:p.limit]
+	last := chirps[len(chirps)-1]
+	setNextLink(w, r, encodeCursor(chirpCursor{CreatedAt: last.CreatedAt, ID: last.ID}))
+	return chirps
+}
+
+// setNextLink advertises the next page in a Link header, keeping every
+// other query parameter of the current request.
+func setNextLink(w http.ResponseWriter, r *http.Request, cursor string) {
+	query := r.URL.Query()
+	query.Set("cursor", cursor)
+	next := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.String()))
+}
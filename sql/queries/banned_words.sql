@@ -0,0 +1,18 @@
+-- name: ListBannedWords :many
+SELECT * FROM banned_words
+ORDER BY word;
+
+-- name: UpsertBannedWord :one
+INSERT INTO banned_words (word, created_at, updated_at, policy)
+VALUES (
+    $1,
+    NOW(),
+    NOW(),
+    $2
+) ON CONFLICT (word) DO UPDATE
+SET policy = EXCLUDED.policy, updated_at = NOW()
+RETURNING *;
+
+-- name: DeleteBannedWord :execrows
+DELETE FROM banned_words
+WHERE word = $1;
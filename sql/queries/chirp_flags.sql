@@ -0,0 +1,26 @@
+-- name: FlagChirp :exec
+-- Flagging a chirp again, after an edit for instance, puts it back in the
+-- review queue with the words that were found this time.
+INSERT INTO chirp_flags (chirp_id, created_at, words)
+VALUES (
+    $1,
+    NOW(),
+    $2
+) ON CONFLICT (chirp_id) DO UPDATE
+SET created_at = NOW(), words = EXCLUDED.words, reviewed_at = NULL;
+
+-- name: ListFlaggedChirps :many
+SELECT sqlc.embed(chirps), chirp_flags.words AS flagged_words FROM chirp_flags
+JOIN chirps ON chirps.id = chirp_flags.chirp_id
+WHERE chirp_flags.reviewed_at IS NULL
+AND chirps.deleted_at IS NULL
+AND (sqlc.narg('after_created_at')::timestamp IS NULL
+    OR (chirps.created_at, chirps.id) > (sqlc.narg('after_created_at')::timestamp, sqlc.narg('after_id')::uuid))
+ORDER BY chirps.created_at, chirps.id
+LIMIT sqlc.arg('row_limit');
+
+-- name: ReviewChirpFlag :execrows
+UPDATE chirp_flags
+SET reviewed_at = NOW()
+WHERE chirp_id = $1
+AND reviewed_at IS NULL;
@@ -0,0 +1,17 @@
+-- name: RecordChirpImport :exec
+INSERT INTO chirp_imports (id, user_id, created_at, chirps)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    NOW(),
+    $2
+);
+
+-- name: GetImportActivity :one
+-- Counts the chirps a user imported after since. Oldest is the first import
+-- among them, or since when there are none.
+SELECT COALESCE(SUM(chirps), 0)::int AS chirps,
+    COALESCE(MIN(created_at), sqlc.arg('since'))::timestamp AS oldest
+FROM chirp_imports
+WHERE user_id = sqlc.arg('user_id')
+AND created_at > sqlc.arg('since');
@@ -0,0 +1,16 @@
+-- name: RecordChirpPost :exec
+INSERT INTO chirp_posts (id, user_id, created_at)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    NOW()
+);
+
+-- name: GetPostingActivity :one
+-- Counts the chirps a user posted after since, deleted or not. Oldest is the
+-- first of them, or since when there are none.
+SELECT COUNT(*)::int AS chirps,
+    COALESCE(MIN(created_at), sqlc.arg('since'))::timestamp AS oldest
+FROM chirp_posts
+WHERE user_id = sqlc.arg('user_id')
+AND created_at > sqlc.arg('since');
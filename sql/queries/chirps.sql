@@ -1,21 +1,102 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, in_reply_to, kind, reference_id)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    $3,
+    $4,
+    $5
 ) RETURNING *;
 
+-- name: ImportChirp :one
+-- Imported chirps keep the time they were originally posted.
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, kind)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    NOW(),
+    $2,
+    $3,
+    'chirp'
+) RETURNING *;
+
+-- name: UpdateChirpBody :one
+UPDATE chirps SET body = $2, updated_at = NOW()
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING *;
+
 -- name: GetChirps :many
-SELECT * FROM chirps ORDER BY created_at ASC;
+SELECT * FROM chirps WHERE deleted_at IS NULL ORDER BY created_at ASC;
 
 -- name: GetChirp :one
 SELECT * FROM chirps WHERE id = $1;
 
+-- name: GetChirpsByIDs :many
+SELECT * FROM chirps WHERE id = ANY(sqlc.arg('ids')::uuid[]);
+
 -- name: DeleteChirp :exec
 DELETE FROM chirps WHERE id = $1;
 
 -- name: GetChirpsByAuthor :many
-SELECT * FROM chirps WHERE user_id = $1;
\ No newline at end of file
+SELECT * FROM chirps WHERE user_id = $1 AND deleted_at IS NULL;
+
+-- name: ListChirpsAsc :many
+SELECT * FROM chirps
+WHERE deleted_at IS NULL
+AND (sqlc.narg('user_id')::uuid IS NULL OR user_id = sqlc.narg('user_id'))
+AND (sqlc.narg('after_created_at')::timestamp IS NULL
+    OR (created_at, id) > (sqlc.narg('after_created_at')::timestamp, sqlc.narg('after_id')::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('row_limit');
+
+-- name: ListChirpsDesc :many
+SELECT * FROM chirps
+WHERE deleted_at IS NULL
+AND (sqlc.narg('user_id')::uuid IS NULL OR user_id = sqlc.narg('user_id'))
+AND (sqlc.narg('after_created_at')::timestamp IS NULL
+    OR (created_at, id) < (sqlc.narg('after_created_at')::timestamp, sqlc.narg('after_id')::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('row_limit');
+
+-- name: SearchChirps :many
+SELECT sqlc.embed(chirps),
+    ts_rank(to_tsvector('english', chirps.body), query)::real AS rank,
+    ts_headline('english', chirps.body, query,
+        'StartSel=' || sqlc.arg('start_sel')::text || ', StopSel=' || sqlc.arg('stop_sel')::text || ', HighlightAll=true')::text AS headline
+FROM chirps, websearch_to_tsquery('english', sqlc.arg('query')) query
+WHERE to_tsvector('english', chirps.body) @@ query
+AND chirps.deleted_at IS NULL
+AND (sqlc.narg('user_id')::uuid IS NULL OR chirps.user_id = sqlc.narg('user_id'))
+ORDER BY rank DESC, chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg('row_limit') OFFSET GREATEST(sqlc.arg('row_offset')::int, 0);
+
+
+-- name: TombstoneChirp :exec
+UPDATE chirps SET body = '', deleted_at = NOW(), updated_at = NOW()
+WHERE id = $1;
+
+-- name: GetChirpAncestors :many
+WITH RECURSIVE ancestors(id, in_reply_to, depth) AS (
+    SELECT c.id, c.in_reply_to, 0 FROM chirps c WHERE c.id = $1
+    UNION ALL
+    SELECT c.id, c.in_reply_to, a.depth + 1 FROM chirps c
+    JOIN ancestors a ON c.id = a.in_reply_to
+)
+SELECT chirps.* FROM chirps
+JOIN ancestors ON chirps.id = ancestors.id
+WHERE ancestors.depth > 0
+ORDER BY ancestors.depth DESC;
+
+-- name: GetChirpDescendants :many
+WITH RECURSIVE descendants(id) AS (
+    SELECT c.id FROM chirps c WHERE c.in_reply_to = $1
+    UNION ALL
+    SELECT c.id FROM chirps c
+    JOIN descendants d ON c.in_reply_to = d.id
+)
+SELECT chirps.* FROM chirps
+JOIN descendants ON chirps.id = descendants.id
+ORDER BY chirps.created_at ASC, chirps.id ASC;
@@ -0,0 +1,32 @@
+-- name: FollowUser :exec
+INSERT INTO follows (follower_id, followee_id, created_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+) ON CONFLICT DO NOTHING;
+
+-- name: UnfollowUser :exec
+DELETE FROM follows WHERE follower_id = $1 AND followee_id = $2;
+
+-- name: GetFollowers :many
+SELECT users.* FROM users
+JOIN follows ON users.id = follows.follower_id
+WHERE follows.followee_id = $1
+ORDER BY follows.created_at DESC;
+
+-- name: GetFollowing :many
+SELECT users.* FROM users
+JOIN follows ON users.id = follows.followee_id
+WHERE follows.follower_id = $1
+ORDER BY follows.created_at DESC;
+
+-- name: GetTimeline :many
+SELECT chirps.* FROM chirps
+JOIN follows ON follows.followee_id = chirps.user_id
+WHERE follows.follower_id = sqlc.arg('follower_id')
+AND chirps.deleted_at IS NULL
+AND (sqlc.narg('after_created_at')::timestamp IS NULL
+    OR (chirps.created_at, chirps.id) < (sqlc.narg('after_created_at')::timestamp, sqlc.narg('after_id')::uuid))
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg('row_limit');
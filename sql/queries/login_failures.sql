@@ -0,0 +1,40 @@
+-- name: GetLoginFailures :one
+SELECT * FROM login_failures
+WHERE key = $1;
+
+-- name: TakeLoginAttempt :one
+-- Counts an attempt unless the key still has to wait after its failures, in
+-- which case the update is skipped and no row comes back. Failures at or
+-- before since are forgotten: the row's count starts over and other stale
+-- rows are deleted.
+WITH pruned AS (
+    DELETE FROM login_failures
+    WHERE last_failure_at <= sqlc.arg(since)
+    AND key <> sqlc.arg(key)
+)
+INSERT INTO login_failures (key, failures, last_failure_at)
+VALUES (sqlc.arg(key), 1, sqlc.arg(attempted_at))
+ON CONFLICT (key) DO UPDATE
+SET failures = CASE
+        WHEN login_failures.last_failure_at <= sqlc.arg(since) THEN 1
+        ELSE login_failures.failures + 1
+    END,
+    last_failure_at = sqlc.arg(attempted_at)
+WHERE login_failures.last_failure_at <= sqlc.arg(since)
+OR login_failures.failures <= sqlc.arg(free_attempts)::int
+OR login_failures.last_failure_at + make_interval(secs => LEAST(
+        sqlc.arg(base_delay_seconds)::float8
+            * POWER(2, LEAST(login_failures.failures - sqlc.arg(free_attempts)::int - 1, 60)),
+        sqlc.arg(max_delay_seconds)::float8
+    )) <= sqlc.arg(attempted_at)
+RETURNING *;
+
+-- name: ForgiveLoginAttempt :exec
+UPDATE login_failures
+SET failures = failures - 1
+WHERE key = $1
+AND failures > 0;
+
+-- name: DeleteLoginFailures :exec
+DELETE FROM login_failures
+WHERE key = $1;
@@ -0,0 +1,32 @@
+-- name: CreateNotification :exec
+INSERT INTO notifications (id, created_at, user_id, actor_id, chirp_id, kind)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+) ON CONFLICT DO NOTHING;
+
+-- name: ListNotifications :many
+SELECT sqlc.embed(notifications), users.handle AS actor_handle FROM notifications
+JOIN users ON users.id = notifications.actor_id
+JOIN chirps ON chirps.id = notifications.chirp_id
+WHERE notifications.user_id = sqlc.arg('user_id')
+AND chirps.deleted_at IS NULL
+AND (NOT sqlc.arg('unread_only')::bool OR notifications.read_at IS NULL)
+AND (sqlc.narg('after_created_at')::timestamp IS NULL
+    OR (notifications.created_at, notifications.id) < (sqlc.narg('after_created_at')::timestamp, sqlc.narg('after_id')::uuid))
+ORDER BY notifications.created_at DESC, notifications.id DESC
+LIMIT sqlc.arg('row_limit');
+
+-- name: MarkAllNotificationsRead :exec
+UPDATE notifications SET read_at = NOW()
+WHERE user_id = $1 AND read_at IS NULL;
+
+-- name: MarkNotificationsRead :exec
+UPDATE notifications SET read_at = NOW()
+WHERE user_id = sqlc.arg('user_id')
+AND id = ANY(sqlc.arg('ids')::uuid[])
+AND read_at IS NULL;
@@ -0,0 +1,14 @@
+-- name: CreateRecoveryCodes :exec
+INSERT INTO recovery_codes (code_hash, created_at, user_id)
+SELECT unnest(sqlc.arg(code_hashes)::text[]), NOW(), sqlc.arg(user_id);
+
+-- name: UseRecoveryCode :execrows
+UPDATE recovery_codes
+SET used_at = NOW()
+WHERE user_id = $1
+AND code_hash = $2
+AND used_at IS NULL;
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes
+WHERE user_id = $1;
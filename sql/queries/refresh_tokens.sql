@@ -1,22 +1,72 @@
 -- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at) 
+INSERT INTO refresh_tokens (token_hash, created_at, updated_at, user_id, expires_at, family_id, user_agent, ip) 
 VALUES (
     $1,
     NOW(),
     NOW(),
     $2,
-    $3
+    $3,
+    $4,
+    $5,
+    $6
 ) RETURNING *;
 
 -- name: GetUserFromRefreshToken :one
 SELECT users.* FROM users
 JOIN refresh_tokens ON users.id = refresh_tokens.user_id
-WHERE refresh_tokens.token = $1
+WHERE refresh_tokens.token_hash = $1
 AND revoked_at IS NULL
 AND expires_at > NOW();
 
+-- name: GetRefreshToken :one
+SELECT * FROM refresh_tokens WHERE token_hash = $1;
+
 -- name: RevokeRefreshToken :one
 UPDATE refresh_tokens 
 SET revoked_at = NOW(), updated_at = NOW()
-WHERE token = $1
+WHERE token_hash = $1
 RETURNING *;
+
+-- name: RotateRefreshToken :execrows
+-- Only an unrevoked token can be rotated, so of two concurrent refreshes
+-- with the same token exactly one succeeds.
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW(), replaced_by = sqlc.arg('replaced_by')
+WHERE token_hash = sqlc.arg('token_hash')
+AND revoked_at IS NULL;
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE family_id = $1
+AND revoked_at IS NULL;
+
+-- name: ListSessions :many
+-- A session is a token family. Its current token was issued by the last
+-- refresh, so that token's created_at is when the session was last used.
+SELECT refresh_tokens.family_id,
+    (SELECT MIN(family.created_at) FROM refresh_tokens family
+        WHERE family.family_id = refresh_tokens.family_id)::timestamp AS created_at,
+    refresh_tokens.created_at AS last_used_at,
+    refresh_tokens.expires_at,
+    refresh_tokens.user_agent,
+    refresh_tokens.ip
+FROM refresh_tokens
+WHERE refresh_tokens.user_id = $1
+AND refresh_tokens.revoked_at IS NULL
+AND refresh_tokens.expires_at > NOW()
+ORDER BY last_used_at DESC;
+
+-- name: RevokeSession :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE family_id = sqlc.arg('family_id')
+AND user_id = sqlc.arg('user_id')
+AND revoked_at IS NULL
+AND expires_at > NOW();
+
+-- name: RevokeUserRefreshTokens :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1
+AND revoked_at IS NULL;
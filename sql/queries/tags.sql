@@ -0,0 +1,51 @@
+-- name: UpsertTag :one
+INSERT INTO tags (id, name, created_at)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    NOW()
+) ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
+RETURNING *;
+
+-- name: AddChirpTag :exec
+INSERT INTO chirp_tags (chirp_id, tag_id, created_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+) ON CONFLICT DO NOTHING;
+
+-- name: GetChirpsByTag :many
+SELECT chirps.* FROM chirps
+JOIN chirp_tags ON chirp_tags.chirp_id = chirps.id
+JOIN tags ON tags.id = chirp_tags.tag_id
+WHERE tags.name = sqlc.arg('tag')
+AND chirps.deleted_at IS NULL
+AND (sqlc.narg('after_created_at')::timestamp IS NULL
+    OR (chirps.created_at, chirps.id) < (sqlc.narg('after_created_at')::timestamp, sqlc.narg('after_id')::uuid))
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg('row_limit');
+
+-- name: GetTrendingTags :many
+-- Every use of a tag inside the window counts, but its weight halves every
+-- half_life_seconds, so recent chirps dominate the ranking. The window is
+-- measured from NOW(), like the ages, so both use the database's clock.
+SELECT tags.name,
+    COUNT(*)::int AS uses,
+    SUM(POWER(0.5, EXTRACT(EPOCH FROM (NOW() - chirp_tags.created_at)) / sqlc.arg('half_life_seconds')::float8))::float8 AS score
+FROM chirp_tags
+JOIN tags ON tags.id = chirp_tags.tag_id
+JOIN chirps ON chirps.id = chirp_tags.chirp_id
+WHERE chirp_tags.created_at > NOW() - make_interval(secs => sqlc.arg('window_seconds')::float8)
+AND chirps.deleted_at IS NULL
+GROUP BY tags.name
+ORDER BY score DESC, tags.name ASC
+LIMIT sqlc.arg('row_limit');
+
+-- name: PruneChirpTags :exec
+-- Removes the tags a chirp no longer has after an edit.
+DELETE FROM chirp_tags
+USING tags
+WHERE tags.id = chirp_tags.tag_id
+AND chirp_tags.chirp_id = sqlc.arg('chirp_id')
+AND NOT (tags.name = ANY(COALESCE(sqlc.arg('keep')::text[], '{}')));
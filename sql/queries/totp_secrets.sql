@@ -0,0 +1,35 @@
+-- name: CreateTOTPSecret :one
+-- Starting over replaces an unconfirmed secret; a confirmed one has to be
+-- deleted first, so no row is returned.
+INSERT INTO totp_secrets (user_id, created_at, secret)
+VALUES (
+    $1,
+    NOW(),
+    $2
+)
+ON CONFLICT (user_id) DO UPDATE
+SET created_at = NOW(), secret = EXCLUDED.secret
+WHERE totp_secrets.confirmed_at IS NULL
+RETURNING *;
+
+-- name: GetTOTPSecret :one
+SELECT * FROM totp_secrets
+WHERE user_id = $1;
+
+-- name: ConfirmTOTPSecret :execrows
+UPDATE totp_secrets
+SET confirmed_at = NOW(), last_used_step = $2
+WHERE user_id = $1
+AND confirmed_at IS NULL
+AND last_used_step < $2;
+
+-- name: UseTOTPStep :execrows
+UPDATE totp_secrets
+SET last_used_step = $2
+WHERE user_id = $1
+AND confirmed_at IS NOT NULL
+AND last_used_step < $2;
+
+-- name: DeleteTOTPSecret :exec
+DELETE FROM totp_secrets
+WHERE user_id = $1;
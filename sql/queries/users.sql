@@ -1,11 +1,12 @@
 -- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password) 
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, handle) 
 VALUES (
     gen_random_uuid (),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    $3
 ) RETURNING *;   
 
 -- name: DeleteUsers :exec
@@ -15,8 +16,65 @@ DELETE FROM users;
 SELECT * FROM users WHERE email = $1;
 
 -- name: UpdateUser :one
-UPDATE users SET email = $2, hashed_password = $3, updated_at = NOW()
+-- A new email address has to be verified again.
+UPDATE users SET email = $2, hashed_password = $3, handle = COALESCE(sqlc.narg('handle'), handle),
+    email_verified = email_verified AND email = $2, updated_at = NOW()
 WHERE id = $1 RETURNING *;
 
--- name: UpdateSubscription :exec
-UPDATE users SET is_chirpy_red = true WHERE id = $1;
\ No newline at end of file
+-- name: UpdatePassword :exec
+UPDATE users SET hashed_password = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: UpdateSubscription :execrows
+-- Upgrading ends any grace period left by a failed payment. Like the other
+-- subscription events, it is skipped if a newer event was applied already.
+UPDATE users SET is_chirpy_red = true, chirpy_red_expires_at = sqlc.arg('chirpy_red_expires_at'),
+    payment_failed_at = NULL, subscription_event_at = sqlc.arg('event_at')::timestamp, updated_at = NOW()
+WHERE id = sqlc.arg('id')
+AND (subscription_event_at IS NULL OR subscription_event_at <= sqlc.arg('event_at')::timestamp);
+
+-- name: CancelSubscription :execrows
+UPDATE users SET is_chirpy_red = false, chirpy_red_expires_at = NULL,
+    payment_failed_at = NULL, subscription_event_at = sqlc.arg('event_at')::timestamp, updated_at = NOW()
+WHERE id = sqlc.arg('id')
+AND (subscription_event_at IS NULL OR subscription_event_at <= sqlc.arg('event_at')::timestamp);
+
+-- name: RecordPaymentFailure :execrows
+-- Only the first failure starts a grace period, so retries that fail too
+-- don't stretch it, and it never cuts short a period already paid for.
+-- Users without Chirpy Red have nothing to lose.
+UPDATE users SET chirpy_red_expires_at = CASE
+        WHEN payment_failed_at IS NULL THEN GREATEST(chirpy_red_expires_at, sqlc.arg('grace_until')::timestamp)
+        ELSE chirpy_red_expires_at
+    END,
+    payment_failed_at = COALESCE(payment_failed_at, NOW()),
+    subscription_event_at = sqlc.arg('event_at')::timestamp, updated_at = NOW()
+WHERE id = sqlc.arg('id')
+AND is_chirpy_red
+AND (subscription_event_at IS NULL OR subscription_event_at <= sqlc.arg('event_at')::timestamp);
+
+-- name: ExpireSubscriptions :execrows
+UPDATE users SET is_chirpy_red = false, chirpy_red_expires_at = NULL,
+    payment_failed_at = NULL, updated_at = NOW()
+WHERE chirpy_red_expires_at <= sqlc.arg('as_of');
+
+-- name: GetUserByID :one
+SELECT * FROM users WHERE id = $1;
+
+-- name: GetUsersByHandles :many
+SELECT * FROM users WHERE handle = ANY(sqlc.arg('handles')::text[]);
+
+-- name: LockUser :exec
+-- Holds the user's row until the transaction ends, so checks that count
+-- the user's rows and then insert one run one at a time.
+SELECT id FROM users WHERE id = $1 FOR UPDATE;
+
+-- name: VerifyEmail :one
+UPDATE users SET email_verified = true, updated_at = NOW()
+WHERE id = $1 AND email = $2
+RETURNING *;
+
+-- name: SetUserRole :one
+UPDATE users SET role = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
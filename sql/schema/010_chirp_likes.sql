@@ -0,0 +1,34 @@
+-- +goose Up
+CREATE TABLE chirp_likes (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    chirp_id UUID NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, chirp_id)
+);
+CREATE INDEX chirp_likes_chirp_id_idx ON chirp_likes (chirp_id);
+
+ALTER TABLE chirps
+ADD like_count INTEGER NOT NULL DEFAULT 0;
+
+-- +goose StatementBegin
+CREATE FUNCTION chirp_likes_update_like_count() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE chirps SET like_count = like_count + 1 WHERE id = NEW.chirp_id;
+    ELSE
+        UPDATE chirps SET like_count = like_count - 1 WHERE id = OLD.chirp_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER chirp_likes_like_count
+AFTER INSERT OR DELETE ON chirp_likes
+FOR EACH ROW EXECUTE FUNCTION chirp_likes_update_like_count();
+
+-- +goose Down
+DROP TRIGGER chirp_likes_like_count ON chirp_likes;
+DROP FUNCTION chirp_likes_update_like_count;
+ALTER TABLE chirps DROP like_count;
+DROP TABLE chirp_likes;
@@ -0,0 +1,12 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD kind TEXT NOT NULL DEFAULT 'chirp' CHECK (kind IN ('chirp', 'rechirp', 'quote')),
+ADD reference_id UUID REFERENCES chirps(id) ON DELETE SET NULL;
+CREATE INDEX chirps_reference_id_idx ON chirps (reference_id);
+CREATE UNIQUE INDEX chirps_one_rechirp_per_user_idx ON chirps (user_id, reference_id)
+WHERE kind = 'rechirp';
+
+-- +goose Down
+ALTER TABLE chirps
+DROP kind,
+DROP reference_id;
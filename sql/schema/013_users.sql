@@ -0,0 +1,9 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN handle TEXT;
+-- Existing users get a placeholder handle they can change later.
+UPDATE users SET handle = 'user_' || left(md5(id::text), 10);
+ALTER TABLE users ALTER COLUMN handle SET NOT NULL;
+ALTER TABLE users ADD CONSTRAINT users_handle_key UNIQUE (handle);
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN handle;
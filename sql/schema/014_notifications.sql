@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE TABLE notifications (
+    id UUID PRIMARY KEY NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    actor_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    chirp_id UUID NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+    kind TEXT NOT NULL CHECK (kind IN ('mention')),
+    read_at TIMESTAMP,
+    UNIQUE (user_id, chirp_id, kind)
+);
+CREATE INDEX notifications_user_id_created_at_idx ON notifications (user_id, created_at, id);
+
+-- +goose Down
+DROP TABLE notifications;
@@ -0,0 +1,12 @@
+-- +goose Up
+-- A family is every token issued from one login. Each refresh revokes the
+-- presented token and points it at its replacement.
+ALTER TABLE refresh_tokens ADD COLUMN family_id UUID;
+UPDATE refresh_tokens SET family_id = gen_random_uuid();
+ALTER TABLE refresh_tokens ALTER COLUMN family_id SET NOT NULL;
+ALTER TABLE refresh_tokens ADD COLUMN replaced_by TEXT REFERENCES refresh_tokens(token) ON DELETE SET NULL;
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+
+-- +goose Down
+ALTER TABLE refresh_tokens DROP COLUMN replaced_by;
+ALTER TABLE refresh_tokens DROP COLUMN family_id;
@@ -0,0 +1,10 @@
+-- +goose Up
+-- Refresh tokens are now stored as an HMAC of the token, keyed with a
+-- pepper the database never sees, so existing plaintext rows can't be
+-- converted. They are dropped and their users have to log in again.
+DELETE FROM refresh_tokens;
+ALTER TABLE refresh_tokens RENAME COLUMN token TO token_hash;
+
+-- +goose Down
+DELETE FROM refresh_tokens;
+ALTER TABLE refresh_tokens RENAME COLUMN token_hash TO token;
@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT false;
+-- Accounts created before verification existed keep working.
+UPDATE users SET email_verified = true;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN email_verified;
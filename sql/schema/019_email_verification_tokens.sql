@@ -0,0 +1,14 @@
+-- +goose Up
+-- A token verifies the address it was sent to, so changing the email in
+-- between makes it useless.
+CREATE TABLE email_verification_tokens (
+    token_hash TEXT PRIMARY KEY NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE email_verification_tokens;
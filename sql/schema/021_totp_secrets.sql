@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE totp_secrets (
+    user_id UUID PRIMARY KEY NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    secret TEXT NOT NULL,
+    confirmed_at TIMESTAMP,
+    -- The time step of the last accepted code, so a code can't be replayed.
+    last_used_step BIGINT NOT NULL DEFAULT 0
+);
+
+-- +goose Down
+DROP TABLE totp_secrets;
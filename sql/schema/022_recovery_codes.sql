@@ -0,0 +1,11 @@
+-- +goose Up
+CREATE TABLE recovery_codes (
+    code_hash TEXT PRIMARY KEY NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    used_at TIMESTAMP
+);
+CREATE INDEX recovery_codes_user_id_idx ON recovery_codes (user_id);
+
+-- +goose Down
+DROP TABLE recovery_codes;
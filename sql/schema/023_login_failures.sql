@@ -0,0 +1,10 @@
+-- +goose Up
+CREATE TABLE login_failures (
+    key TEXT PRIMARY KEY NOT NULL,
+    failures INTEGER NOT NULL,
+    last_failure_at TIMESTAMP NOT NULL
+);
+CREATE INDEX login_failures_last_failure_at_idx ON login_failures (last_failure_at);
+
+-- +goose Down
+DROP TABLE login_failures;
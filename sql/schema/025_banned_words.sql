@@ -0,0 +1,17 @@
+-- +goose Up
+CREATE TABLE banned_words (
+    word TEXT PRIMARY KEY NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    policy TEXT NOT NULL DEFAULT 'mask'
+    CHECK (policy IN ('mask', 'reject', 'flag'))
+);
+INSERT INTO banned_words (word, created_at, updated_at, policy)
+VALUES
+    ('fornax', NOW(), NOW(), 'mask'),
+    ('kerfuffle', NOW(), NOW(), 'mask'),
+    ('profane', NOW(), NOW(), 'mask'),
+    ('sharbert', NOW(), NOW(), 'mask');
+
+-- +goose Down
+DROP TABLE banned_words;
@@ -0,0 +1,10 @@
+-- +goose Up
+CREATE TABLE chirp_flags (
+    chirp_id UUID PRIMARY KEY NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    words TEXT[] NOT NULL,
+    reviewed_at TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE chirp_flags;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- A NULL expiry means the subscription runs until it is cancelled.
+ALTER TABLE users ADD COLUMN chirpy_red_expires_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN payment_failed_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN payment_failed_at;
+ALTER TABLE users DROP COLUMN chirpy_red_expires_at;
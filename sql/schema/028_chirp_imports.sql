@@ -0,0 +1,13 @@
+-- +goose Up
+-- One row per import, so the import quota counts chirps by when they were
+-- imported rather than by the dates they carry.
+CREATE TABLE chirp_imports (
+    id UUID PRIMARY KEY NOT NULL,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    chirps INTEGER NOT NULL
+);
+CREATE INDEX chirp_imports_user_id_created_at_idx ON chirp_imports (user_id, created_at);
+
+-- +goose Down
+DROP TABLE chirp_imports;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- When the latest Polka event applied to the subscription happened, so an
+-- event delivered late can't undo a newer one.
+ALTER TABLE users ADD COLUMN subscription_event_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN subscription_event_at;
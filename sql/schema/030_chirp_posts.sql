@@ -0,0 +1,12 @@
+-- +goose Up
+-- One row per chirp posted, so deleting a chirp doesn't give back its place
+-- in the posting rate.
+CREATE TABLE chirp_posts (
+    id UUID PRIMARY KEY NOT NULL,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX chirp_posts_user_id_created_at_idx ON chirp_posts (user_id, created_at);
+
+-- +goose Down
+DROP TABLE chirp_posts;
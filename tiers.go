@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/YaguarEgor/chirpy_server/internal/database"
+	"github.com/google/uuid"
+)
+
+// Tier is a user's subscription tier.
+type Tier string
+
+const (
+	TierFree      Tier = "free"
+	TierChirpyRed Tier = "chirpy_red"
+)
+
+// Limits are what a tier allows. Every write endpoint checks the ones that
+// apply to it, and GET /api/limits shows them to the caller.
+type Limits struct {
+	Tier           Tier `json:"tier"`
+	MaxChirpLength int  `json:"max_chirp_length"`
+	CanEditChirps  bool `json:"can_edit_chirps"`
+	// ChirpsPerHour caps the chirps, replies, quotes and rechirps a user
+	// posts in any hour.
+	ChirpsPerHour int `json:"chirps_per_hour"`
+	// ImportsPerHour caps the chirps a user imports in any hour. Imported
+	// chirps keep their old dates, so ChirpsPerHour would not see them.
+	ImportsPerHour int `json:"imports_per_hour"`
+}
+
+// tierLimits is the one place the perks of each tier are set.
+var tierLimits = map[Tier]Limits{
+	TierFree: {
+		Tier:           TierFree,
+		MaxChirpLength: maxChirpLength,
+		CanEditChirps:  false,
+		ChirpsPerHour:  30,
+		ImportsPerHour: maxImportChirps,
+	},
+	TierChirpyRed: {
+		Tier:           TierChirpyRed,
+		MaxChirpLength: 280,
+		CanEditChirps:  true,
+		ChirpsPerHour:  300,
+		ImportsPerHour: 10 * maxImportChirps,
+	},
+}
+
+func userTier(user database.User) Tier {
+	if user.IsChirpyRed {
+		return TierChirpyRed
+	}
+	return TierFree
+}
+
+// limitsFor returns the limits of a user's current tier.
+func (cfg *apiConfig) limitsFor(ctx context.Context, user_id uuid.UUID) (Limits, error) {
+	user, err := cfg.db.GetUserByID(ctx, user_id)
+	if err != nil {
+		return Limits{}, err
+	}
+	return tierLimits[userTier(user)], nil
+}
+
+// rateError says a user has used up one of their tier's hourly quotas.
+// Wait is how long until the oldest use stops counting.
+type rateError struct {
+	Message string
+	Wait    time.Duration
+}
+
+func (e *rateError) Error() string {
+	return e.Message
+}
+
+func respondWithRateError(w http.ResponseWriter, err *rateError) {
+	w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(err.Wait.Seconds())))))
+	respondWithError(w, http.StatusTooManyRequests, err.Message, nil)
+}
+
+// takePostingSlot counts one more chirp against the user's posting rate,
+// or returns a *rateError when they have already posted as many chirps in
+// the last hour as their tier allows. It belongs in the transaction that
+// posts the chirp: the user stays locked until that ends, so parallel
+// posts can't all pass on the same count.
+func takePostingSlot(ctx context.Context, db database.Store, user_id uuid.UUID, limits Limits) error {
+	if err := db.LockUser(ctx, user_id); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	activity, err := db.GetPostingActivity(ctx, database.GetPostingActivityParams{
+		Since:  now.Add(-time.Hour),
+		UserID: user_id,
+	})
+	if err != nil {
+		return err
+	}
+	if int(activity.Chirps) >= limits.ChirpsPerHour {
+		return &rateError{
+			Message: fmt.Sprintf("You can post %d chirps an hour", limits.ChirpsPerHour),
+			Wait:    activity.Oldest.Add(time.Hour).Sub(now),
+		}
+	}
+	return db.RecordChirpPost(ctx, user_id)
+}
+
+// takeImportSlots counts n more imported chirps against the user's import
+// rate, or returns a *rateError when that would take them past what their
+// tier allows in an hour. Like takePostingSlot, it belongs in the
+// transaction that imports the chirps.
+func takeImportSlots(ctx context.Context, db database.Store, user_id uuid.UUID, limits Limits, n int) error {
+	if err := db.LockUser(ctx, user_id); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	activity, err := db.GetImportActivity(ctx, database.GetImportActivityParams{
+		Since:  now.Add(-time.Hour),
+		UserID: user_id,
+	})
+	if err != nil {
+		return err
+	}
+	if int(activity.Chirps)+n > limits.ImportsPerHour {
+		return &rateError{
+			Message: fmt.Sprintf("You can import %d chirps an hour", limits.ImportsPerHour),
+			Wait:    activity.Oldest.Add(time.Hour).Sub(now),
+		}
+	}
+	return db.RecordChirpImport(ctx, database.RecordChirpImportParams{
+		UserID: user_id,
+		Chirps: int32(n),
+	})
+}
+
+// handlerGetLimits shows the caller their tier's limits and how much of
+// the posting rate they have used.
+func (cfg *apiConfig) handlerGetLimits(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Limits
+		ChirpsThisHour int `json:"chirps_this_hour"`
+	}
+
+	user_id, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT token", err)
+		return
+	}
+	limits, err := cfg.limitsFor(r.Context(), user_id)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User is not found", err)
+		return
+	}
+	activity, err := cfg.db.GetPostingActivity(r.Context(), database.GetPostingActivityParams{
+		Since:  time.Now().UTC().Add(-time.Hour),
+		UserID: user_id,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get posting activity", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Limits:         limits,
+		ChirpsThisHour: int(activity.Chirps),
+	})
+}